@@ -0,0 +1,148 @@
+package audit_log
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+// Bytes of the log before it is rotated
+const DefaultSize int64 = 1024 * 1024
+
+// Rotated logs kept besides the current one, path.1 is the newest
+const rotatedLogs int = 3
+
+// Entries kept when the log is only in memory
+const maxEntriesInMemory int = 1000
+
+// Setup appends the entries to the log in path from now on. An empty path keeps them only in memory
+func Setup(path string, size int64) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if size < 0 {
+		return errors.New("Audit log size should not be negative")
+	}
+	manager.path = path
+	manager.size = size
+	if manager.size == 0 {
+		manager.size = DefaultSize
+	}
+	manager.entries = nil
+	return nil
+}
+
+// Record appends the entry, entries are never modified once they are recorded
+func Record(entry types.AuditEntry) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if manager.path == "" {
+		manager.entries = append(manager.entries, entry)
+		if len(manager.entries) > maxEntriesInMemory {
+			manager.entries = manager.entries[len(manager.entries)-maxEntriesInMemory:]
+		}
+		return nil
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(manager.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.New("[audit_log]: Could not open the audit log: " + err.Error())
+	}
+	_, err = file.Write(append(content, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.New("[audit_log]: Could not record the entry: " + err.Error())
+	}
+	if info, err := os.Stat(manager.path); err == nil && info.Size() >= manager.size {
+		return manager.rotate()
+	}
+	return nil
+}
+
+// GetEntries returns the last count entries of the user (every user when 0) and the pin (every pin when empty), oldest first
+func GetEntries(userId int, pin string, count int) ([]types.AuditEntry, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	entries := manager.entries
+	if manager.path != "" {
+		var err error
+		entries, err = manager.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var result []types.AuditEntry
+	for _, entry := range entries {
+		if (userId == 0 || entry.UserId == userId) && (pin == "" || entry.Pin == pin) {
+			result = append(result, entry)
+		}
+	}
+	if len(result) > count {
+		result = result[len(result)-count:]
+	}
+	return result, nil
+}
+
+var manager = auditLog{size: DefaultSize}
+
+type auditLog struct {
+	path    string
+	size    int64
+	entries []types.AuditEntry
+	mutex   sync.Mutex
+}
+
+func (l *auditLog) rotatedPath(index int) string {
+	return l.path + "." + strconv.Itoa(index)
+}
+
+func (l *auditLog) rotate() error {
+	for index := rotatedLogs; index > 1; index-- {
+		err := os.Rename(l.rotatedPath(index-1), l.rotatedPath(index))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.New("[audit_log]: Could not rotate the audit log: " + err.Error())
+		}
+	}
+	err := os.Rename(l.path, l.rotatedPath(1))
+	if err != nil {
+		return errors.New("[audit_log]: Could not rotate the audit log: " + err.Error())
+	}
+	return nil
+}
+
+// Reads the rotated logs and the current one, oldest first
+func (l *auditLog) load() ([]types.AuditEntry, error) {
+	var entries []types.AuditEntry
+	paths := []string{}
+	for index := rotatedLogs; index > 0; index-- {
+		paths = append(paths, l.rotatedPath(index))
+	}
+	paths = append(paths, l.path)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.New("[audit_log]: Could not read the audit log: " + err.Error())
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry types.AuditEntry
+			// A power cut can leave the last line incomplete
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+		file.Close()
+	}
+	return entries, nil
+}
@@ -0,0 +1,65 @@
+package audit_log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogInMemory(t *testing.T) {
+	require.Nil(t, Setup("", 0))
+	for index := 0; index < maxEntriesInMemory+1; index++ {
+		Record(types.AuditEntry{UserId: index, Pin: "light"})
+	}
+	entries, err := GetEntries(0, "", maxEntriesInMemory+1)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), maxEntriesInMemory, "The entries in memory should be bounded")
+	assert.Equal(t, entries[0].UserId, 1, "The oldest entries should be discarded")
+	assert.NotNil(t, Setup("", -1), "Negative sizes should return an error")
+}
+
+func TestAuditLogIsPersistedAndRotated(t *testing.T) {
+	directory, err := ioutil.TempDir("", "audit")
+	require.Nil(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "audit.log")
+	date := time.Date(2020, 5, 3, 7, 0, 0, 0, time.Local)
+
+	require.Nil(t, Setup(path, 400))
+	for index := 0; index < 20; index++ {
+		pin := "light"
+		if index%2 == 1 {
+			pin = "heater"
+		}
+		require.Nil(t, Record(types.AuditEntry{Date: date.Add(time.Duration(index) * time.Minute), UserId: 1 + index%3, ChatId: 1, Command: pin + "On", Pin: pin, Result: "accepted"}))
+	}
+	_, err = os.Stat(path + ".1")
+	assert.Nil(t, err, "The log should be rotated when it grows over its size")
+	_, err = os.Stat(path + "." + "4")
+	assert.True(t, os.IsNotExist(err), "Only some rotated logs should be kept")
+
+	entries, err := GetEntries(0, "", 3)
+	assert.Nil(t, err)
+	require.Equal(t, len(entries), 3)
+	assert.True(t, entries[2].Date.Equal(date.Add(19*time.Minute)), "The last entries should be returned, oldest first")
+	entries, _ = GetEntries(2, "light", 100)
+	for _, entry := range entries {
+		assert.Equal(t, entry.UserId, 2)
+		assert.Equal(t, entry.Pin, "light")
+	}
+	assert.NotEqual(t, len(entries), 0, "Entries should be filtered by user and pin")
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.Nil(t, err)
+	file.Write([]byte(`{"UserId":`))
+	file.Close()
+	entries, err = GetEntries(0, "", 100)
+	assert.Nil(t, err, "Incomplete lines should be ignored")
+	assert.True(t, entries[len(entries)-1].Date.Equal(date.Add(19*time.Minute)))
+}
@@ -3,24 +3,82 @@ package configuration_loader
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"regexp"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/schedule_checker"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
 )
 
 type ServerConfiguration struct {
-	GRPCServerPort          int
-	TelegramBotToken        string
+	GRPCServerPort   int
+	TelegramBotToken string
+	// Users with the admin role
 	TelegramAuthorizedUsers []int
+	TelegramUsers           []TelegramUser
+	// Bot API server (e.g. a local one), api.telegram.org when empty
+	TelegramApiUrl string
+	DailySummary   *DailySummaryConfiguration
+	// Other names of the pins understood in phrases sent to the bot (e.g. "kitchen light": "Light1")
+	PinAliases map[string]string
+	// Telegram pushes the updates to this endpoint when set, otherwise the bot polls them
+	TelegramWebhook *TelegramWebhookConfiguration
+	// Bytes of the audit log before it is rotated, 1MB when 0
+	AuditLogSize int64
+	// Pins only changed after the user confirms it in the bot (e.g. the garage door), programmed actions on them are confirmed when they are created
+	RequireConfirmation []string
+}
+
+type TelegramWebhookConfiguration struct {
+	// Address the endpoint listens on (e.g. ":8443")
+	ListenAddress string
+	// Public url of the endpoint without the secret (e.g. "https://example.com:8443"), it is registered in telegram when set
+	Url string
+	// Path of the endpoint, only telegram should know it
+	Secret string
+	// Certificate and key of the HTTPS server, it serves plain HTTP when empty (e.g. behind a reverse proxy)
+	CertificateFile string
+	KeyFile         string
+	// Uploads the certificate to telegram so it trusts it
+	SelfSigned bool
+}
+
+const (
+	ADMIN_ROLE    = "admin"
+	OPERATOR_ROLE = "operator"
+	VIEWER_ROLE   = "viewer"
+)
+
+type TelegramUser struct {
+	Id int
+	// Admins can do everything, operators can change pins and programmed actions and viewers can only query the status
+	Role string
+	// Pins (or pins of the nodes) the user can see and change, every pin when both are empty
+	Pins  []string
+	Nodes []string
+}
+
+type DailySummaryConfiguration struct {
+	Time    types.MyTime
+	ChatIds []int64
 }
 
 type InitialConfiguration struct {
-	GRPCServerIp        string
-	PinsActive          []types.PairNamePin
+	GRPCServerIp string
+	// Name of the node, used to allow telegram users to control every pin of the node
+	NodeName   string
+	PinsActive []types.PairNamePin
+	Sensors    []types.Sensor
+	// Directory where the node stores the data that should survive restarts (e.g. the execution history)
+	DataDirectory       string
+	HistorySize         int
+	Vacation            *types.VacationConfiguration
+	Overrides           []types.OverrideConfiguration
+	Sequences           []types.Sequence
 	ServerConfiguration *ServerConfiguration
 	AutomaticMessages   []types.ProgrammedAction
 }
@@ -34,17 +92,53 @@ func loadConfigurationFromFileContent(fileContent []byte) (result InitialConfigu
 		for _, pin := range result.PinsActive {
 			if len(strings.Fields(pin.Name)) > 1 {
 				err = errors.New("Pin names should only have one word. Wrong pin: \"" + pin.Name + "\"")
-			} else if matched, _ := regexp.Match("(On$)|(Off$)|(OnAndOff$)", []byte(pin.Name)); err == nil && matched {
-				err = errors.New("Pin name should not end with \"On\", \"Off\" or \"OnAndOff\". Wrong pin: \"" + pin.Name + "\"")
+			} else if strings.HasSuffix(pin.Name, "OnAnd") {
+				// The off button of the pin would be read as the OnAndOff button of another one
+				err = errors.New("Pin name should not end with \"OnAnd\". Wrong pin: \"" + pin.Name + "\"")
 			}
 		}
 		if result.ServerConfiguration != nil {
 			if result.ServerConfiguration.TelegramBotToken == "" {
 				err = errors.New("Telegram bot token not defined")
 			}
-			if len(result.ServerConfiguration.TelegramAuthorizedUsers) == 0 {
+			if len(result.ServerConfiguration.TelegramAuthorizedUsers) == 0 && len(result.ServerConfiguration.TelegramUsers) == 0 {
 				err = errors.New("Telegram bot does not have any authorized users")
 			}
+			for _, user := range result.ServerConfiguration.TelegramUsers {
+				if user.Id == 0 {
+					err = errors.New("Telegram users should set their Id")
+				} else if user.Role != ADMIN_ROLE && user.Role != OPERATOR_ROLE && user.Role != VIEWER_ROLE {
+					err = errors.New("Telegram user " + strconv.Itoa(user.Id) + " has a wrong role: \"" + user.Role + "\" (it should be admin, operator or viewer)")
+				} else if user.Role == ADMIN_ROLE && (len(user.Pins) > 0 || len(user.Nodes) > 0) {
+					err = errors.New("Telegram user " + strconv.Itoa(user.Id) + " is an admin, admins can not have Pins or Nodes")
+				}
+			}
+			if apiUrl := result.ServerConfiguration.TelegramApiUrl; apiUrl != "" {
+				if parsed, urlErr := url.Parse(apiUrl); urlErr != nil || parsed.Scheme == "" || parsed.Host == "" {
+					err = errors.New("Telegram API url not valid: \"" + apiUrl + "\"")
+				}
+			}
+			if webhook := result.ServerConfiguration.TelegramWebhook; webhook != nil {
+				if webhookErr := checkTelegramWebhook(*webhook); webhookErr != nil {
+					err = webhookErr
+				}
+			}
+			for alias, pin := range result.ServerConfiguration.PinAliases {
+				if strings.TrimSpace(alias) == "" || len(strings.Fields(pin)) != 1 {
+					err = errors.New("Pin alias not valid: \"" + alias + "\": \"" + pin + "\"")
+				}
+			}
+			for _, pin := range result.ServerConfiguration.RequireConfirmation {
+				if len(strings.Fields(pin)) != 1 {
+					err = errors.New("Pin requiring confirmation not valid: \"" + pin + "\"")
+				}
+			}
+			if result.ServerConfiguration.AuditLogSize < 0 {
+				err = errors.New("Audit log size should not be negative")
+			}
+			if result.ServerConfiguration.DailySummary != nil && len(result.ServerConfiguration.DailySummary.ChatIds) == 0 {
+				err = errors.New("Daily summary does not have any chats to send it to")
+			}
 			if result.ServerConfiguration.GRPCServerPort == 0 {
 				err = errors.New("gRPC server port not defined")
 			}
@@ -54,8 +148,63 @@ func loadConfigurationFromFileContent(fileContent []byte) (result InitialConfigu
 				result.GRPCServerIp = "localhost:" + strconv.Itoa(result.ServerConfiguration.GRPCServerPort)
 			}
 		}
+		if result.Vacation != nil {
+			for _, episodePin := range result.Vacation.EpisodePins {
+				found := false
+				for _, pin := range result.PinsActive {
+					found = found || pin.Name == episodePin
+				}
+				if !found {
+					err = errors.New("Vacation episode pin " + episodePin + " not present in the pins active")
+				}
+			}
+			if result.Vacation.JitterMinutes < 0 || result.Vacation.Episodes < 0 || result.Vacation.EpisodeMaxMinutes < 0 {
+				err = errors.New("Vacation parameters should not be negative")
+			}
+		}
+		for _, override := range result.Overrides {
+			found := false
+			for _, pin := range result.PinsActive {
+				found = found || pin.Name == override.Pin
+			}
+			if !found {
+				err = errors.New("Override pin " + override.Pin + " not present in the pins active")
+			} else if override.Minutes <= 0 && !override.UntilTomorrow {
+				err = errors.New("Override of pin " + override.Pin + " should set a positive number of Minutes or UntilTomorrow")
+			}
+		}
+		sequences := make(map[string]bool)
+		for _, sequence := range result.Sequences {
+			if len(strings.Fields(sequence.Name)) != 1 {
+				err = errors.New("Sequence names should have one word. Wrong sequence: \"" + sequence.Name + "\"")
+			} else if sequences[sequence.Name] {
+				err = errors.New("Sequence " + sequence.Name + " defined more than once")
+			} else if len(sequence.Steps) == 0 {
+				err = errors.New("Sequence " + sequence.Name + " does not have any steps")
+			}
+			sequences[sequence.Name] = true
+			for _, step := range sequence.Steps {
+				found := false
+				for _, pin := range result.PinsActive {
+					found = found || pin.Name == step.Pin
+				}
+				if !found {
+					err = errors.New("Sequence " + sequence.Name + ", " + step.Pin + " not present in the pins active")
+				} else if step.Minutes <= 0 || step.GapMinutes < 0 {
+					err = errors.New("Sequence " + sequence.Name + ", step of " + step.Pin + " should set a positive number of Minutes and a non negative GapMinutes")
+				}
+			}
+		}
 		if len(result.AutomaticMessages) > 0 {
+			ids := make(map[string]bool)
 			for index, automaticMessage := range result.AutomaticMessages {
+				// Deterministic ids, so the same configuration always produces the same ids
+				id := types.ProgrammedActionIdFromString(types.ProgrammedActionToString(automaticMessage))
+				if ids[id] {
+					id = types.ProgrammedActionIdFromString(types.ProgrammedActionToString(automaticMessage) + ";" + strconv.Itoa(index))
+				}
+				ids[id] = true
+				result.AutomaticMessages[index].Id = id
 				found := false
 				for _, pin := range result.PinsActive {
 					if pin.Name == automaticMessage.Action.Pin {
@@ -63,9 +212,28 @@ func loadConfigurationFromFileContent(fileContent []byte) (result InitialConfigu
 						break
 					}
 				}
-				if !found {
+				if automaticMessage.Type == types.REMINDER_ACTION {
+					if automaticMessage.Message == "" || automaticMessage.Action.ChatId == 0 {
+						err = errors.New("Automatic message number " + strconv.Itoa(index) + " is a reminder, it should set a Message and the Action ChatId")
+					}
+				} else if automaticMessage.Type == types.SEQUENCE_ACTION {
+					if !sequences[automaticMessage.Sequence] {
+						err = errors.New("Automatic message number " + strconv.Itoa(index) + ", sequence " + automaticMessage.Sequence + " not defined")
+					}
+				} else if !found {
 					err = errors.New("Automatic message number " + strconv.Itoa(index) + ", " + automaticMessage.Action.Pin + " not present in the pins active")
 				}
+				// Automatic messages do not have a creator to notify, so the chat should be configured
+				if automaticMessage.NotifyChatId != 0 {
+					result.AutomaticMessages[index].Notify = true
+				} else if automaticMessage.Notify || automaticMessage.NotifySkipped || automaticMessage.AlertOnFailure {
+					err = errors.New("Automatic message number " + strconv.Itoa(index) + " should set a NotifyChatId to send notifications")
+				}
+				for _, condition := range automaticMessage.Conditions {
+					if conditionErr := checkCondition(condition, result.Sensors); conditionErr != nil {
+						err = errors.New("Automatic message number " + strconv.Itoa(index) + ": " + conditionErr.Error())
+					}
+				}
 				currTime := time.Time(result.AutomaticMessages[index].Time)
 				now := time.Now()
 				date := time.Date(now.Year(), now.Month(), now.Day(), currTime.Hour(), currTime.Minute(), currTime.Second(), 0, now.Location())
@@ -74,11 +242,53 @@ func loadConfigurationFromFileContent(fileContent []byte) (result InitialConfigu
 				}
 				result.AutomaticMessages[index].Time = types.MyTime(date)
 			}
+			issues := schedule_checker.Check(result.AutomaticMessages)
+			if schedule_checker.HasErrors(issues) {
+				err = errors.New("Automatic messages not valid:\n" + schedule_checker.IssuesToString(issues))
+			} else if len(issues) > 0 {
+				fmt.Println("Automatic messages:\n" + schedule_checker.IssuesToString(issues))
+			}
 		}
 	}
 	return result, err
 }
 
+func checkTelegramWebhook(webhook TelegramWebhookConfiguration) error {
+	if webhook.ListenAddress == "" {
+		return errors.New("Telegram webhook listen address not defined")
+	} else if webhook.Secret == "" || strings.ContainsAny(webhook.Secret, "/?# ") {
+		return errors.New("Telegram webhook secret should not be empty or contain \"/\", \"?\", \"#\" or spaces")
+	} else if (webhook.CertificateFile == "") != (webhook.KeyFile == "") {
+		return errors.New("Telegram webhook needs both the certificate and the key files")
+	} else if webhook.SelfSigned && webhook.CertificateFile == "" {
+		return errors.New("Telegram webhook self signed certificate not defined")
+	}
+	if webhook.Url != "" {
+		if parsed, urlErr := url.Parse(webhook.Url); urlErr != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return errors.New("Telegram webhook url should be an https url: \"" + webhook.Url + "\"")
+		}
+	}
+	return nil
+}
+
+func checkCondition(condition types.Condition, sensors []types.Sensor) error {
+	if condition.Sensor == "" {
+		if condition.Pin == "" {
+			return errors.New("conditions should reference a pin or a sensor")
+		}
+		return nil
+	}
+	if _, err := condition.Compare(0); err != nil {
+		return err
+	}
+	for _, sensor := range sensors {
+		if sensor.Name == condition.Sensor {
+			return nil
+		}
+	}
+	return errors.New("sensor " + condition.Sensor + " not present in the sensors")
+}
+
 func LoadConfigurationFromPath(filePath string) (result InitialConfiguration, err error) {
 	fileContent, err := ioutil.ReadFile(filePath)
 	if err == nil {
@@ -1,6 +1,7 @@
 package configuration_loader
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -249,7 +250,7 @@ func TestLoadClientConfigurationFromStringWithComplexPinName(t *testing.T) {
 	assert.NotNil(t, err, "loadConfigurationFromFileContent() with complex pin names should return an error")
 }
 
-func TestLoadClientConfigurationFromStringWithNotValidNames(t *testing.T) {
+func TestLoadClientConfigurationFromStringWithNamesLikeCommands(t *testing.T) {
 	content := []byte(`
 	{
 		"GRPCServerIp": "192.168.2.160:8000",
@@ -257,38 +258,506 @@ func TestLoadClientConfigurationFromStringWithNotValidNames(t *testing.T) {
 			{
 				"name": "lightOn",
 				"pin": 	18
+			},
+			{
+				"name": "TurnOff",
+				"pin": 	23
+			},
+			{
+				"name": "fanOnAndOff",
+				"pin": 	24
 			}
 		]
 	}`)
 
 	_, err := loadConfigurationFromFileContent(content)
-	assert.NotNil(t, err, "loadConfigurationFromFileContent() with pin name ending with \"On\" should return an error")
+	assert.Nil(t, err, "Pin names ending with \"On\", \"Off\" or \"OnAndOff\" should be valid")
 
 	content = []byte(`
 	{
 		"GRPCServerIp": "192.168.2.160:8000",
 		"PinsActive": [
 			{
-				"name": "lightOff",
+				"name": "lightOnAnd",
 				"pin": 	18
 			}
 		]
 	}`)
 
 	_, err = loadConfigurationFromFileContent(content)
-	assert.NotNil(t, err, "loadConfigurationFromFileContent() with pin name ending with \"Off\" should return an error")
+	assert.NotNil(t, err, "loadConfigurationFromFileContent() with pin name ending with \"OnAnd\" should return an error")
+}
 
-	content = []byte(`
+func TestLoadClientConfigurationAssignsDeterministicIds(t *testing.T) {
+	content := []byte(`
+	{
+		"GRPCServerIp": "192.168.2.160:8000",
+		"PinsActive": [
+			{
+				"name": "light",
+				"pin": 	18
+			}
+		],
+		"AutomaticMessages": [
+			{
+				"Action": {
+					"Pin": "light",
+					"State": true
+				},
+				"Time": "03:45:10"
+			},
+			{
+				"Action": {
+					"Pin": "light",
+					"State": true
+				},
+				"Time": "03:45:10"
+			}
+		]
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, len(config.AutomaticMessages[0].Id), 6, "Automatic messages should have an id")
+	assert.NotEqual(t, config.AutomaticMessages[0].Id, config.AutomaticMessages[1].Id, "Automatic messages should have unique ids")
+	otherConfig, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, config.AutomaticMessages[0].Id, otherConfig.AutomaticMessages[0].Id, "Automatic messages ids should be deterministic")
+	assert.Equal(t, config.AutomaticMessages[1].Id, otherConfig.AutomaticMessages[1].Id, "Automatic messages ids should be deterministic")
+}
+
+func TestLoadClientConfigurationWithConditions(t *testing.T) {
+	content := []byte(`
+	{
+		"GRPCServerIp": "192.168.2.160:8000",
+		"PinsActive": [
+			{
+				"name": "heater",
+				"pin": 	18
+			}
+		],
+		"Sensors": [
+			{
+				"Name": "temperature",
+				"Path": "/sys/bus/w1/devices/28-000005e2fdc3/temperature",
+				"Scale": 0.001
+			}
+		],
+		"AutomaticMessages": [
+			{
+				"Action": {
+					"Pin": "heater",
+					"State": true
+				},
+				"Time": "07:00:00",
+				"Conditions": [
+					{
+						"Pin": "window",
+						"State": false
+					},
+					{
+						"Sensor": "temperature",
+						"Operator": "<",
+						"Value": 18
+					}
+				]
+			}
+		]
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, len(config.Sensors), 1)
+	assert.Equal(t, len(config.AutomaticMessages[0].Conditions), 2)
+
+	wrongSensor := strings.Replace(string(content), `"Sensor": "temperature"`, `"Sensor": "humidity"`, 1)
+	_, err = loadConfigurationFromFileContent([]byte(wrongSensor))
+	assert.NotNil(t, err, "Conditions with sensors not configured should return an error")
+	wrongOperator := strings.Replace(string(content), `"Operator": "<"`, `"Operator": "~"`, 1)
+	_, err = loadConfigurationFromFileContent([]byte(wrongOperator))
+	assert.NotNil(t, err, "Conditions with unknown operators should return an error")
+}
+
+func TestLoadClientConfigurationWithNotifications(t *testing.T) {
+	content := `
+	{
+		"GRPCServerIp": "192.168.2.160:8000",
+		"PinsActive": [
+			{
+				"name": "light",
+				"pin": 	18
+			}
+		],
+		"AutomaticMessages": [
+			{
+				"Action": {
+					"Pin": "light",
+					"State": true
+				},
+				"Time": "07:00:00",
+				"NotifyChatId": 1234
+			}
+		]
+	}`
+
+	config, err := loadConfigurationFromFileContent([]byte(content))
+	assert.Nil(t, err)
+	assert.True(t, config.AutomaticMessages[0].Notify, "Setting a chat should enable the notifications")
+	assert.Equal(t, config.AutomaticMessages[0].NotificationChatId(), int64(1234))
+
+	withoutChat := strings.Replace(content, `"NotifyChatId": 1234`, `"Notify": true`, 1)
+	_, err = loadConfigurationFromFileContent([]byte(withoutChat))
+	assert.NotNil(t, err, "Automatic messages can not notify without a chat")
+}
+
+func TestLoadClientConfigurationWithContradictoryAutomaticMessages(t *testing.T) {
+	content := []byte(`
+	{
+		"GRPCServerIp": "192.168.2.160:8000",
+		"PinsActive": [
+			{
+				"name": "light",
+				"pin": 	18
+			}
+		],
+		"AutomaticMessages": [
+			{
+				"Action": {
+					"Pin": "light",
+					"State": true
+				},
+				"Time": "07:00:00",
+				"Repeat": true
+			},
+			{
+				"Action": {
+					"Pin": "light",
+					"State": false
+				},
+				"Time": "07:00:00",
+				"Repeat": true
+			}
+		]
+	}`)
+
+	_, err := loadConfigurationFromFileContent(content)
+	assert.NotNil(t, err, "Turning a pin on and off at the same time should return an error")
+}
+
+func TestLoadClientConfigurationWithReminders(t *testing.T) {
+	content := []byte(`
 	{
 		"GRPCServerIp": "192.168.2.160:8000",
 		"PinsActive": [
 			{
-				"name": "lightOnAndOff",
+				"name": "light",
 				"pin": 	18
 			}
+		],
+		"AutomaticMessages": [
+			{
+				"Type": 1,
+				"Message": "take the bins out",
+				"Action": {
+					"ChatId": 123
+				},
+				"Time": "20:00:00",
+				"Repeat": true
+			}
 		]
 	}`)
 
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err, "Reminders do not need a pin")
+	assert.Equal(t, config.AutomaticMessages[0].Message, "take the bins out")
+
+	content = []byte(strings.Replace(string(content), `"Message": "take the bins out",`, "", 1))
+	_, err = loadConfigurationFromFileContent(content)
+	assert.NotNil(t, err, "Reminders without message should return an error")
+}
+
+func TestLoadServerConfigurationWithDailySummary(t *testing.T) {
+	content := []byte(`
+	{
+		"PinsActive": [
+			{
+				"name": "light",
+				"pin": 	18
+			}
+		],
+		"ServerConfiguration": {
+			"TelegramBotToken": "randomToken",
+			"TelegramAuthorizedUsers": [
+				1234
+			],
+			"GRPCServerPort": 8080,
+			"DailySummary": {
+				"Time": "21:00:00",
+				"ChatIds": [1234]
+			}
+		}
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, config.ServerConfiguration.DailySummary.Time.Format("15:04:05"), "21:00:00")
+	assert.Equal(t, config.ServerConfiguration.DailySummary.ChatIds, []int64{1234})
+
+	content = []byte(strings.Replace(string(content), "[1234]", "[]", 1))
 	_, err = loadConfigurationFromFileContent(content)
-	assert.NotNil(t, err, "loadConfigurationFromFileContent() with pin name ending with \"OnAndOff\" should return an error")
+	assert.NotNil(t, err, "Daily summaries without chats should return an error")
+}
+
+func TestLoadClientConfigurationWithOverrides(t *testing.T) {
+	content := []byte(`
+	{
+		"GRPCServerIp": "192.168.2.160:8000",
+		"PinsActive": [
+			{
+				"name": "light",
+				"pin": 	18
+			}
+		],
+		"Overrides": [
+			{
+				"Pin": "light",
+				"Minutes": 90
+			}
+		]
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, config.Overrides[0].Minutes, 90)
+
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Minutes": 90`, `"Minutes": 0`, 1)))
+	assert.NotNil(t, err, "Overrides without duration should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Minutes": 90`, `"UntilTomorrow": true`, 1)))
+	assert.Nil(t, err)
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Pin": "light"`, `"Pin": "water"`, 1)))
+	assert.NotNil(t, err, "Overrides of pins not active should return an error")
+}
+
+func TestLoadClientConfigurationWithSequences(t *testing.T) {
+	content := []byte(`
+	{
+		"GRPCServerIp": "192.168.2.160:8000",
+		"PinsActive": [
+			{
+				"name": "zone1",
+				"pin": 	18
+			},
+			{
+				"name": "zone2",
+				"pin": 	19
+			}
+		],
+		"Sequences": [
+			{
+				"Name": "garden",
+				"Steps": [
+					{
+						"Pin": "zone1",
+						"Minutes": 10,
+						"GapMinutes": 2
+					},
+					{
+						"Pin": "zone2",
+						"Minutes": 15
+					}
+				]
+			}
+		],
+		"AutomaticMessages": [
+			{
+				"Type": 2,
+				"Sequence": "garden",
+				"Time": "06:00:00",
+				"Repeat": true
+			}
+		]
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, len(config.Sequences[0].Steps), 2)
+	assert.Equal(t, config.Sequences[0].Steps[0].GapMinutes, 2)
+
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Sequence": "garden"`, `"Sequence": "lawn"`, 1)))
+	assert.NotNil(t, err, "Automatic messages of sequences not defined should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Pin": "zone2"`, `"Pin": "zone3"`, 1)))
+	assert.NotNil(t, err, "Steps of pins not active should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Minutes": 15`, `"Minutes": 0`, 1)))
+	assert.NotNil(t, err, "Steps without duration should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Name": "garden"`, `"Name": "front garden"`, 1)))
+	assert.NotNil(t, err, "Sequence names with more than one word should return an error")
+}
+
+func TestLoadServerConfigurationWithTelegramApiUrl(t *testing.T) {
+	content := []byte(`
+	{
+		"PinsActive": [
+			{
+				"name": "light",
+				"pin": 	18
+			}
+		],
+		"ServerConfiguration": {
+			"TelegramBotToken": "randomToken",
+			"TelegramAuthorizedUsers": [
+				1234
+			],
+			"GRPCServerPort": 8080,
+			"TelegramApiUrl": "http://localhost:8081"
+		}
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, config.ServerConfiguration.TelegramApiUrl, "http://localhost:8081")
+
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), "http://localhost:8081", "localhost", 1)))
+	assert.NotNil(t, err, "Telegram API urls without scheme should return an error")
+}
+
+func TestLoadServerConfigurationWithTelegramUsers(t *testing.T) {
+	content := []byte(`
+	{
+		"NodeName": "living",
+		"PinsActive": [
+			{
+				"name": "lamp",
+				"pin": 	18
+			}
+		],
+		"ServerConfiguration": {
+			"TelegramBotToken": "randomToken",
+			"GRPCServerPort": 8080,
+			"TelegramUsers": [
+				{
+					"Id": 1234,
+					"Role": "admin"
+				},
+				{
+					"Id": 5678,
+					"Role": "operator",
+					"Pins": ["lamp"],
+					"Nodes": ["garden"]
+				}
+			]
+		}
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err, "Users without TelegramAuthorizedUsers should be valid")
+	assert.Equal(t, config.NodeName, "living")
+	assert.Equal(t, config.ServerConfiguration.TelegramUsers[1].Pins, []string{"lamp"})
+
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Role": "operator"`, `"Role": "kid"`, 1)))
+	assert.NotNil(t, err, "Wrong roles should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Role": "operator"`, `"Role": "admin"`, 1)))
+	assert.NotNil(t, err, "Admins with allow lists should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Id": 1234,`, ``, 1)))
+	assert.NotNil(t, err, "Users without id should return an error")
+}
+
+func TestLoadServerConfigurationWithPinAliases(t *testing.T) {
+	content := []byte(`
+	{
+		"PinsActive": [
+			{
+				"name": "light",
+				"pin": 	18
+			}
+		],
+		"ServerConfiguration": {
+			"TelegramBotToken": "randomToken",
+			"TelegramAuthorizedUsers": [
+				1234
+			],
+			"GRPCServerPort": 8080,
+			"PinAliases": {
+				"kitchen light": "light"
+			}
+		}
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, config.ServerConfiguration.PinAliases, map[string]string{"kitchen light": "light"})
+
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"kitchen light": "light"`, `"kitchen light": "light one"`, 1)))
+	assert.NotNil(t, err, "Aliases of pins with spaces should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"kitchen light": "light"`, `" ": "light"`, 1)))
+	assert.NotNil(t, err, "Empty aliases should return an error")
+}
+
+func TestLoadServerConfigurationWithTelegramWebhook(t *testing.T) {
+	content := []byte(`
+	{
+		"PinsActive": [
+			{
+				"name": "light",
+				"pin": 	18
+			}
+		],
+		"ServerConfiguration": {
+			"TelegramBotToken": "randomToken",
+			"TelegramAuthorizedUsers": [
+				1234
+			],
+			"GRPCServerPort": 8080,
+			"TelegramWebhook": {
+				"ListenAddress": ":8443",
+				"Url": "https://example.com:8443",
+				"Secret": "s3cret",
+				"CertificateFile": "/etc/rpi/cert.pem",
+				"KeyFile": "/etc/rpi/key.pem"
+			}
+		}
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, *config.ServerConfiguration.TelegramWebhook, TelegramWebhookConfiguration{":8443", "https://example.com:8443", "s3cret", "/etc/rpi/cert.pem", "/etc/rpi/key.pem", false})
+
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"Secret": "s3cret",`, ``, 1)))
+	assert.NotNil(t, err, "Webhooks without secret should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"s3cret"`, `"s3/cret"`, 1)))
+	assert.NotNil(t, err, "Secrets with slashes should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"ListenAddress": ":8443",`, ``, 1)))
+	assert.NotNil(t, err, "Webhooks without listen address should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"KeyFile": "/etc/rpi/key.pem"`, `"SelfSigned": true`, 1)))
+	assert.NotNil(t, err, "Certificates without key should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `https://example.com:8443`, `http://example.com:8443`, 1)))
+	assert.NotNil(t, err, "Webhook urls that are not https should return an error")
+}
+
+func TestLoadServerConfigurationWithRequireConfirmation(t *testing.T) {
+	content := []byte(`
+	{
+		"PinsActive": [
+			{
+				"name": "garage",
+				"pin": 	18
+			}
+		],
+		"ServerConfiguration": {
+			"TelegramBotToken": "randomToken",
+			"TelegramAuthorizedUsers": [
+				1234
+			],
+			"GRPCServerPort": 8080,
+			"RequireConfirmation": ["garage", "waterValve"]
+		}
+	}`)
+
+	config, err := loadConfigurationFromFileContent(content)
+	assert.Nil(t, err)
+	assert.Equal(t, config.ServerConfiguration.RequireConfirmation, []string{"garage", "waterValve"})
+
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"waterValve"`, `"water valve"`, 1)))
+	assert.NotNil(t, err, "Pins with spaces should return an error")
+	_, err = loadConfigurationFromFileContent([]byte(strings.Replace(string(content), `"waterValve"`, `""`, 1)))
+	assert.NotNil(t, err, "Empty pins should return an error")
 }
@@ -0,0 +1,21 @@
+package file_storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// StoreJSON writes the value serialized as JSON to path. It is written to a temporary file first and then
+// renamed, so a power cut does not leave a corrupted file
+func StoreJSON(path string, value interface{}) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".tmp", content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
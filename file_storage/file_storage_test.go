@@ -0,0 +1,31 @@
+package file_storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreJSON(t *testing.T) {
+	directory, err := ioutil.TempDir("", "file_storage")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "values.json")
+	err = StoreJSON(path, []string{"first"})
+	assert.Nil(t, err)
+	err = StoreJSON(path, []string{"first", "second"})
+	assert.Nil(t, err)
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(content), "[\"first\",\"second\"]")
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStoreJSONInvalidPath(t *testing.T) {
+	err := StoreJSON(filepath.Join("not", "existing", "directory", "values.json"), []string{"first"})
+	assert.NotNil(t, err)
+}
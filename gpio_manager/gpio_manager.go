@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
 	"github.com/stianeikeland/go-rpio"
@@ -45,7 +46,43 @@ func Setup(pins []types.PairNamePin) (err error) {
 }
 
 func HandleAction(action types.Action) (bool, error) {
-	return setPinState(action.Pin, action.State)
+	return handleAction(action, false)
+}
+
+// Actions requested by a user (e.g. from Telegram) instead of by the scheduler
+func HandleManualAction(action types.Action) (bool, error) {
+	return handleAction(action, true)
+}
+
+// The listener is called every time a pin changes its state
+func SetPinChangesListener(listener func(types.PinChange)) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.pinChangesListener = listener
+}
+
+// The listener is called for every action requested by a user, even if the pin already had that state
+// (e.g. a manual "off" on a pin that is off should still suspend its programmed actions)
+func SetManualActionsListener(listener func(types.PinChange)) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.manualActionsListener = listener
+}
+
+func handleAction(action types.Action, manual bool) (bool, error) {
+	stateChanged, err := setPinState(action.Pin, action.State)
+	manager.mutex.Lock()
+	listener := manager.pinChangesListener
+	manualListener := manager.manualActionsListener
+	manager.mutex.Unlock()
+	pinChange := types.PinChange{Pin: action.Pin, State: action.State, Date: time.Now(), Manual: manual, ChatId: action.ChatId}
+	if stateChanged && listener != nil {
+		listener(pinChange)
+	}
+	if manual && err == nil && manualListener != nil {
+		manualListener(pinChange)
+	}
+	return stateChanged, err
 }
 
 func setPinState(pin string, state bool) (bool, error) {
@@ -86,9 +123,11 @@ type pinState struct {
 }
 
 type gpioManager struct {
-	PinStates     map[string]*pinState
-	gpioAvailable bool
-	mutex         sync.Mutex
+	PinStates             map[string]*pinState
+	gpioAvailable         bool
+	pinChangesListener    func(types.PinChange)
+	manualActionsListener func(types.PinChange)
+	mutex                 sync.Mutex
 }
 
 func (m *gpioManager) turnPinOn(pin string) (stateChanged bool, err error) {
@@ -67,3 +67,44 @@ func TestGetPinsAvailable(t *testing.T) {
 		assert.Equal(t, pinsActive[1], "test2", "Error, the pin should be \"test2\" and it is \"%s\"", pinsActive[1])
 	}
 }
+
+func TestPinChangesListener(t *testing.T) {
+	defer ClearAllPins()
+	defer SetPinChangesListener(nil)
+	err := Setup([]types.PairNamePin{types.PairNamePin{"test", 18}})
+	assert.Equal(t, err, nil, "Setup error: %s", err)
+	var pinChanges []types.PinChange
+	SetPinChangesListener(func(pinChange types.PinChange) {
+		pinChanges = append(pinChanges, pinChange)
+	})
+	HandleManualAction(types.Action{"test", true, 5})
+	HandleManualAction(types.Action{"test", true, 5})
+	HandleAction(types.Action{"test", false, 0})
+	assert.Equal(t, len(pinChanges), 2, "Only actions that change the state should be notified")
+	assert.Equal(t, pinChanges[0].Pin, "test")
+	assert.True(t, pinChanges[0].State)
+	assert.True(t, pinChanges[0].Manual, "Actions sent by users should be marked as manual")
+	assert.Equal(t, pinChanges[0].ChatId, int64(5))
+	assert.False(t, pinChanges[1].Manual, "Programmed actions should not be marked as manual")
+}
+
+func TestManualActionsListener(t *testing.T) {
+	defer ClearAllPins()
+	defer SetManualActionsListener(nil)
+	err := Setup([]types.PairNamePin{types.PairNamePin{"test", 18}})
+	assert.Equal(t, err, nil, "Setup error: %s", err)
+	var manualActions []types.PinChange
+	SetManualActionsListener(func(pinChange types.PinChange) {
+		manualActions = append(manualActions, pinChange)
+	})
+	HandleManualAction(types.Action{"test", false, 5})
+	HandleAction(types.Action{"test", true, 0})
+	HandleManualAction(types.Action{"test", true, 5})
+	HandleManualAction(types.Action{"unknown", true, 5})
+	assert.Equal(t, len(manualActions), 2, "Every valid manual action should be notified")
+	assert.Equal(t, manualActions[0].Pin, "test")
+	assert.False(t, manualActions[0].State, "Manual actions that do not change the state should be notified too")
+	assert.True(t, manualActions[0].Manual)
+	assert.Equal(t, manualActions[0].ChatId, int64(5))
+	assert.True(t, manualActions[1].State)
+}
@@ -8,13 +8,16 @@ import (
 
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/gpio_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/history_manager"
 	messages_protocol "github.com/Alberto-Izquierdo/RPIHomeServer-go/messages"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/proto_conversion"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
 	"google.golang.org/grpc"
 )
 
 const timeBetweenReconnectionAttempts time.Duration = 10 * time.Second
 const numberOfReconnectingAttemptsUntilShutdown int = 30
+const timeWaitingForPinState time.Duration = 10 * time.Second
 
 const EmptyPinsMessage string = "There are not any pins active, gRPC client will not be run"
 
@@ -26,9 +29,7 @@ func ConnectToGrpcServer(config configuration_loader.InitialConfiguration) (clie
 	return client, connection, err
 }
 
-func Run(programmedActionOperationsChannel chan types.ProgrammedActionOperation,
-	telegramResponsesChannel chan types.TelegramMessage,
-	grpcClientExitChannel chan bool, client messages_protocol.RPIHomeServerServiceClient,
+func Run(channels types.NodeChannels, grpcClientExitChannel chan bool, client messages_protocol.RPIHomeServerServiceClient,
 	connection *grpc.ClientConn, config configuration_loader.InitialConfiguration) {
 	defer connection.Close()
 	cachedProgrammedActions := config.AutomaticMessages
@@ -41,14 +42,31 @@ func Run(programmedActionOperationsChannel chan types.ProgrammedActionOperation,
 			}
 			fmt.Println("Exit signal received in gRPC client")
 			return
-		case response := <-telegramResponsesChannel:
+		case response := <-channels.Responses:
 			SendMessageToTelegram(client, response)
+		case agenda := <-channels.Agendas:
+			err := SendAgenda(client, agenda)
+			if err != nil {
+				fmt.Println("There was an error sending the agenda in gRPC client: ", err.Error())
+			}
+		case execution := <-channels.Executions:
+			// If it can not be sent, it will be synced from the history when registering again
+			err := SendExecution(client, execution)
+			if err != nil {
+				fmt.Println("There was an error sending an execution in gRPC client: ", err.Error())
+			}
+		case pinChange := <-channels.PinChanges:
+			err := SendPinChange(client, pinChange)
+			if err != nil {
+				fmt.Println("There was an error sending a pin change in gRPC client: ", err.Error())
+			}
 		default:
-			actions, programmedActionOperations, err := CheckForActions(client)
+			actionsToPerform, err := CheckForActions(client)
 			if err != nil {
 				fmt.Println("There was an error checking actions in gRPC client: ", err.Error())
 				fmt.Println("Trying to reconnect to server...")
 				time.Sleep(1 * time.Second)
+				cachedProgrammedActions = removeExpiredTimers(cachedProgrammedActions)
 				for err != nil {
 					select {
 					case <-grpcClientExitChannel:
@@ -66,8 +84,8 @@ func Run(programmedActionOperationsChannel chan types.ProgrammedActionOperation,
 					}
 				}
 			} else {
-				for _, action := range actions {
-					success, _ := gpio_manager.HandleAction(action)
+				for _, action := range actionsToPerform.Actions {
+					success, _ := gpio_manager.HandleManualAction(action)
 					message := ""
 					if success == true {
 						message = "Action " + action.Pin + " successful"
@@ -76,8 +94,8 @@ func Run(programmedActionOperationsChannel chan types.ProgrammedActionOperation,
 					}
 					SendMessageToTelegram(client, types.TelegramMessage{message, action.ChatId})
 				}
-				for _, programmedActionOperation := range programmedActionOperations {
-					programmedActionOperationsChannel <- programmedActionOperation
+				for _, programmedActionOperation := range actionsToPerform.ProgrammedActionOperations {
+					channels.ProgrammedActions <- programmedActionOperation
 					// Update the cache
 					operation := programmedActionOperation.Operation
 					if operation != types.GET_ACTIONS {
@@ -97,14 +115,71 @@ func Run(programmedActionOperationsChannel chan types.ProgrammedActionOperation,
 								(cachedProgrammedActions)[found] = (cachedProgrammedActions)[len(cachedProgrammedActions)-1]
 								cachedProgrammedActions = (cachedProgrammedActions)[:len(cachedProgrammedActions)-1]
 							}
+						} else if operation == types.UPDATE {
+							if found != -1 {
+								cachedProgrammedActions[found] = programmedActionOperation.ProgrammedAction
+							}
 						}
 					}
 				}
+				for _, agendaRequest := range actionsToPerform.AgendaRequests {
+					go func(request types.AgendaRequest) {
+						channels.AgendaRequests <- request
+					}(agendaRequest)
+				}
+				for _, vacationRequest := range actionsToPerform.VacationModeRequests {
+					go func(request types.VacationModeRequest) {
+						channels.VacationRequests <- request
+					}(vacationRequest)
+				}
+				for _, overrideRequest := range actionsToPerform.OverrideRequests {
+					go func(request types.OverrideRequest) {
+						channels.OverrideRequests <- request
+					}(overrideRequest)
+				}
+				for _, sequenceRequest := range actionsToPerform.SequenceRequests {
+					go func(request types.SequenceRequest) {
+						channels.SequenceRequests <- request
+					}(sequenceRequest)
+				}
+				for _, replayRequest := range actionsToPerform.ReplayRequests {
+					go func(request types.ReplayRequest) {
+						channels.ReplayRequests <- request
+					}(replayRequest)
+				}
+				for _, pinStateRequest := range actionsToPerform.PinStateRequests {
+					go func(request types.PinStateRequest) {
+						err := SendPinState(client, request)
+						if err != nil {
+							fmt.Println("There was an error sending a pin state in gRPC client: ", err.Error())
+						}
+					}(pinStateRequest)
+				}
+				for _, statusRequest := range actionsToPerform.StatusRequests {
+					go func(request types.StatusRequest) {
+						err := SendStatus(client, request)
+						if err != nil {
+							fmt.Println("There was an error sending the status in gRPC client: ", err.Error())
+						}
+					}(statusRequest)
+				}
 			}
 		}
 	}
 }
 
+// Timers already executed are not in the message generator anymore, so they should not be registered again
+func removeExpiredTimers(programmedActions []types.ProgrammedAction) []types.ProgrammedAction {
+	now := time.Now()
+	var remaining []types.ProgrammedAction
+	for _, programmedAction := range programmedActions {
+		if !programmedAction.Timer || time.Time(programmedAction.Time).After(now) {
+			remaining = append(remaining, programmedAction)
+		}
+	}
+	return remaining
+}
+
 func RegisterPinsToGRPCServer(client messages_protocol.RPIHomeServerServiceClient,
 	config configuration_loader.InitialConfiguration,
 	programmedActions []types.ProgrammedAction) (err error) {
@@ -114,19 +189,19 @@ func RegisterPinsToGRPCServer(client messages_protocol.RPIHomeServerServiceClien
 	}
 	var programmedActionsProto []*messages_protocol.ProgrammedAction
 	for _, programmedAction := range programmedActions {
-		programmedActionsProto = append(programmedActionsProto,
-			&messages_protocol.ProgrammedAction{
-				Action: &messages_protocol.PinStatePair{
-					Pin:   programmedAction.Action.Pin,
-					State: programmedAction.Action.State,
-				},
-				Repeat: programmedAction.Repeat,
-				Time:   programmedAction.Time.Format("15:04:05"),
-			})
+		programmedActionsProto = append(programmedActionsProto, proto_conversion.ProgrammedActionToProto(programmedAction))
+	}
+	var sequences []string
+	for _, sequence := range config.Sequences {
+		sequences = append(sequences, sequence.Name)
+	}
+	var historyProto []*messages_protocol.Execution
+	for _, execution := range history_manager.GetExecutions() {
+		historyProto = append(historyProto, executionToProto(execution))
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	result, err := client.RegisterToServer(ctx, &messages_protocol.RegistrationMessage{PinsToHandle: pins, ProgrammedActions: programmedActionsProto})
+	result, err := client.RegisterToServer(ctx, &messages_protocol.RegistrationMessage{PinsToHandle: pins, ProgrammedActions: programmedActionsProto, History: historyProto, Sequences: sequences, NodeName: config.NodeName})
 	if err == nil && result.Result != messages_protocol.RegistrationStatusCodes_Ok {
 		errorMessage := result.Result.String()
 		if result.Result == messages_protocol.RegistrationStatusCodes_PinNameAlreadyRegistered {
@@ -140,36 +215,71 @@ func RegisterPinsToGRPCServer(client messages_protocol.RPIHomeServerServiceClien
 	return err
 }
 
-func CheckForActions(client messages_protocol.RPIHomeServerServiceClient) ([]types.Action, []types.ProgrammedActionOperation, error) {
+type ActionsToPerform struct {
+	Actions                    []types.Action
+	ProgrammedActionOperations []types.ProgrammedActionOperation
+	AgendaRequests             []types.AgendaRequest
+	PinStateRequests           []types.PinStateRequest
+	VacationModeRequests       []types.VacationModeRequest
+	ReplayRequests             []types.ReplayRequest
+	OverrideRequests           []types.OverrideRequest
+	SequenceRequests           []types.SequenceRequest
+	StatusRequests             []types.StatusRequest
+}
+
+func CheckForActions(client messages_protocol.RPIHomeServerServiceClient) (ActionsToPerform, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
+	var result ActionsToPerform
 	protoActions, err := client.CheckForActions(ctx, &messages_protocol.Empty{})
 	if err != nil {
-		return nil, nil, err
+		return result, err
 	}
-	var actions []types.Action
 	for _, action := range protoActions.Actions {
-		actions = append(actions, types.Action{action.Pin, action.State, action.ChatId})
+		result.Actions = append(result.Actions, types.Action{action.Pin, action.State, action.ChatId})
 	}
-	var programmedActionOperations []types.ProgrammedActionOperation
 	for _, programmedAction := range protoActions.ProgrammedActionOperations {
-		time := types.MyTime(time.Now())
-		time.UnmarshalJSON([]byte(programmedAction.ProgrammedAction.Time))
+		converted, err := proto_conversion.ProgrammedActionFromProto(programmedAction.ProgrammedAction)
+		if err != nil {
+			fmt.Println("Programmed action received not valid: ", err.Error())
+			continue
+		}
 		action := types.ProgrammedActionOperation{
-			Operation: programmedAction.Operation,
-			ProgrammedAction: types.ProgrammedAction{
-				Action: types.Action{
-					programmedAction.ProgrammedAction.Action.Pin,
-					programmedAction.ProgrammedAction.Action.State,
-					programmedAction.ProgrammedAction.Action.ChatId,
-				},
-				Time:   time,
-				Repeat: programmedAction.ProgrammedAction.Repeat,
-			},
+			Operation:        programmedAction.Operation,
+			ProgrammedAction: converted,
 		}
-		programmedActionOperations = append(programmedActionOperations, action)
+		result.ProgrammedActionOperations = append(result.ProgrammedActionOperations, action)
+	}
+	for _, agendaRequest := range protoActions.AgendaRequests {
+		result.AgendaRequests = append(result.AgendaRequests, types.AgendaRequest{
+			Id:         agendaRequest.RequestId,
+			Horizon:    time.Duration(agendaRequest.Horizon) * time.Second,
+			Executions: int(agendaRequest.Executions),
+		})
 	}
-	return actions, programmedActionOperations, nil
+	for _, pinStateRequest := range protoActions.PinStateRequests {
+		result.PinStateRequests = append(result.PinStateRequests, types.PinStateRequest{Id: pinStateRequest.RequestId, Pin: pinStateRequest.Pin})
+	}
+	for _, vacationRequest := range protoActions.VacationModeRequests {
+		result.VacationModeRequests = append(result.VacationModeRequests, types.VacationModeRequest{Enabled: vacationRequest.Enabled, ChatId: vacationRequest.ChatId})
+	}
+	for _, replayRequest := range protoActions.ReplayRequests {
+		request := types.ReplayRequest{Enabled: replayRequest.Enabled, ChatId: replayRequest.ChatId}
+		if replayRequest.From != 0 {
+			request.From = time.Unix(replayRequest.From, 0)
+		}
+		result.ReplayRequests = append(result.ReplayRequests, request)
+	}
+	for _, overrideRequest := range protoActions.OverrideRequests {
+		result.OverrideRequests = append(result.OverrideRequests, types.OverrideRequest{Clear: overrideRequest.Clear, Pin: overrideRequest.Pin, ChatId: overrideRequest.ChatId})
+	}
+	for _, sequenceRequest := range protoActions.SequenceRequests {
+		result.SequenceRequests = append(result.SequenceRequests, types.SequenceRequest{Operation: sequenceRequest.Operation, Name: sequenceRequest.Name, Days: int(sequenceRequest.Days), ChatId: sequenceRequest.ChatId})
+	}
+	for _, statusRequest := range protoActions.StatusRequests {
+		result.StatusRequests = append(result.StatusRequests, types.StatusRequest{Id: statusRequest.RequestId})
+	}
+	return result, nil
 }
 
 func UnregisterPins(client messages_protocol.RPIHomeServerServiceClient) (err error) {
@@ -185,3 +295,105 @@ func SendMessageToTelegram(client messages_protocol.RPIHomeServerServiceClient,
 	_, err := client.SendMessageToTelegram(ctx, &messages_protocol.TelegramMessage{Message: message.Message, ChatId: message.ChatId})
 	return err
 }
+
+func SendAgenda(client messages_protocol.RPIHomeServerServiceClient, agenda types.Agenda) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	protoAgenda := messages_protocol.Agenda{RequestId: agenda.RequestId}
+	for _, entry := range agenda.Entries {
+		protoAgenda.Entries = append(protoAgenda.Entries, &messages_protocol.AgendaEntry{
+			ProgrammedAction: proto_conversion.ProgrammedActionToProto(entry.ProgrammedAction),
+			Timestamp:        entry.Date.Unix(),
+		})
+	}
+	_, err := client.SendAgenda(ctx, &protoAgenda)
+	return err
+}
+
+func SendPinState(client messages_protocol.RPIHomeServerServiceClient, request types.PinStateRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	response := messages_protocol.PinStateResponse{RequestId: request.Id, PinState: &messages_protocol.PinStatePair{Pin: request.Pin}}
+	found := false
+	for _, pin := range gpio_manager.GetPinsAvailable() {
+		if pin == request.Pin {
+			found = true
+			break
+		}
+	}
+	if found {
+		response.PinState.State = gpio_manager.GetPinState(request.Pin)
+	} else {
+		response.Error = "Pin " + request.Pin + " not set in the node"
+	}
+	_, err := client.SendPinState(ctx, &response)
+	return err
+}
+
+// SendStatus sends the state of every pin of the node and its last recorded change
+func SendStatus(client messages_protocol.RPIHomeServerServiceClient, request types.StatusRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	lastChanges := make(map[string]types.PinChange)
+	for _, pinChange := range history_manager.GetPinChanges() {
+		lastChanges[pinChange.Pin] = pinChange
+	}
+	status := messages_protocol.NodeStatus{RequestId: request.Id}
+	for _, pin := range gpio_manager.GetPinsAvailable() {
+		pinStatus := messages_protocol.PinStatus{Pin: pin, State: gpio_manager.GetPinState(pin)}
+		if lastChange, ok := lastChanges[pin]; ok {
+			pinStatus.LastChange = lastChange.Date.Unix()
+			pinStatus.Manual = lastChange.Manual
+			pinStatus.ChatId = lastChange.ChatId
+		}
+		status.Pins = append(status.Pins, &pinStatus)
+	}
+	_, err := client.SendStatus(ctx, &status)
+	return err
+}
+
+func GetPinState(client messages_protocol.RPIHomeServerServiceClient, pin string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeWaitingForPinState)
+	defer cancel()
+	pinState, err := client.QueryPinState(ctx, &messages_protocol.PinStatePair{Pin: pin})
+	if err != nil {
+		return false, err
+	}
+	return pinState.State, nil
+}
+
+func executionToProto(execution types.Execution) *messages_protocol.Execution {
+	return &messages_protocol.Execution{
+		ProgrammedActionId: execution.ProgrammedActionId,
+		Action: &messages_protocol.PinStatePair{
+			Pin:    execution.Action.Pin,
+			State:  execution.Action.State,
+			ChatId: execution.Action.ChatId,
+		},
+		Timestamp:   execution.Date.Unix(),
+		Success:     execution.Success,
+		Skipped:     execution.Skipped,
+		Error:       execution.Error,
+		Description: execution.Description,
+	}
+}
+
+func SendPinChange(client messages_protocol.RPIHomeServerServiceClient, pinChange types.PinChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.SendPinChange(ctx, &messages_protocol.PinChange{
+		Pin:       pinChange.Pin,
+		State:     pinChange.State,
+		Timestamp: pinChange.Date.Unix(),
+		Manual:    pinChange.Manual,
+		ChatId:    pinChange.ChatId,
+	})
+	return err
+}
+
+func SendExecution(client messages_protocol.RPIHomeServerServiceClient, execution types.Execution) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.SendExecution(ctx, executionToProto(execution))
+	return err
+}
@@ -0,0 +1,171 @@
+package grpc_server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+const maxPinChanges int = 5000
+const maxOfflineNodes int = 100
+const maxFailuresInDailySummary int = 10
+
+type offlineNode struct {
+	Pins []string
+	// Last time the node was seen
+	Date   time.Time
+	Reason string
+}
+
+func (s *rpiHomeServer) addPinChange(pinChange types.PinChange) {
+	s.pinChanges = append(s.pinChanges, pinChange)
+	if len(s.pinChanges) > maxPinChanges {
+		s.pinChanges = s.pinChanges[len(s.pinChanges)-maxPinChanges:]
+	}
+}
+
+func (s *rpiHomeServer) addOfflineNode(node offlineNode) {
+	s.offlineNodes = append(s.offlineNodes, node)
+	if len(s.offlineNodes) > maxOfflineNodes {
+		s.offlineNodes = s.offlineNodes[len(s.offlineNodes)-maxOfflineNodes:]
+	}
+}
+
+// Nil (it blocks forever) when the daily summary is not configured
+func (s *rpiHomeServer) dailySummaryChannel(now time.Time) <-chan time.Time {
+	if s.dailySummary == nil {
+		return nil
+	}
+	return time.After(nextDailySummary(time.Time(s.dailySummary.Time), now).Sub(now))
+}
+
+func nextDailySummary(summaryTime time.Time, now time.Time) time.Time {
+	date := time.Date(now.Year(), now.Month(), now.Day(), summaryTime.Hour(), summaryTime.Minute(), summaryTime.Second(), 0, now.Location())
+	if !date.After(now) {
+		date = date.AddDate(0, 0, 1)
+	}
+	return date
+}
+
+// Collects the agenda of tomorrow from every node and sends the summary of the last 24 hours to the configured chats
+func (s *rpiHomeServer) sendDailySummary(now time.Time) {
+	// Nodes that stopped answering should appear as offline
+	s.getPinsAndUpdateMap()
+	year, month, day := now.AddDate(0, 0, 1).Date()
+	tomorrow := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	chatIds := s.dailySummary.ChatIds
+	s.requestAgenda(types.AgendaRequest{Horizon: tomorrow.AddDate(0, 0, 1).Sub(now)}, func(pending *pendingAgenda) {
+		s.mutex.Lock()
+		message := buildDailySummary(now.Add(-24*time.Hour), now, s.pinChanges, s.history, s.offlineNodes, pending.entries, len(pending.clientsPending))
+		s.mutex.Unlock()
+		for _, chatId := range chatIds {
+			s.responsesChannel <- types.TelegramMessage{Message: message, ChatId: chatId}
+		}
+	})
+}
+
+func buildDailySummary(from time.Time, to time.Time, pinChanges []types.PinChange, history []types.Execution, offlineNodes []offlineNode, agenda []types.AgendaEntry, nodesNotAnswering int) string {
+	inside := func(date time.Time) bool {
+		return !date.Before(from) && date.Before(to)
+	}
+	response := "Daily summary (" + to.Format("Mon 02/01") + ")"
+
+	changes := make(map[string]int)
+	manualChanges := make(map[string]int)
+	var pins []string
+	for _, pinChange := range pinChanges {
+		if !inside(pinChange.Date) {
+			continue
+		}
+		if changes[pinChange.Pin] == 0 {
+			pins = append(pins, pinChange.Pin)
+		}
+		changes[pinChange.Pin]++
+		if pinChange.Manual {
+			manualChanges[pinChange.Pin]++
+		}
+	}
+	sort.Strings(pins)
+	response += "\n\nPins changed:"
+	if len(pins) == 0 {
+		response += "\nNone"
+	}
+	for _, pin := range pins {
+		response += "\n- " + pin + ": " + timesToString(changes[pin])
+		if manualChanges[pin] > 0 {
+			response += " (" + strconv.Itoa(manualChanges[pin]) + " manual)"
+		}
+	}
+
+	executed, skipped := 0, 0
+	var failures []types.Execution
+	for _, execution := range history {
+		if !inside(execution.Date) {
+			continue
+		}
+		if execution.Success {
+			executed++
+		} else if execution.Skipped {
+			skipped++
+		} else {
+			failures = append(failures, execution)
+		}
+	}
+	response += "\n\nProgrammed actions:\n" + strconv.Itoa(executed) + " executed, " + strconv.Itoa(len(failures)) + " failed, " + strconv.Itoa(skipped) + " skipped"
+	if len(failures) > maxFailuresInDailySummary {
+		response += "\n(" + strconv.Itoa(len(failures)-maxFailuresInDailySummary) + " older failures not shown)"
+		failures = failures[len(failures)-maxFailuresInDailySummary:]
+	}
+	for _, failure := range failures {
+		// Reminders and sequences do not have a pin
+		what := failure.Action.Pin
+		if failure.Description != "" {
+			what = failure.Description
+		}
+		response += "\n- " + failure.Date.Format("15:04") + " " + what + ": " + failure.Error
+	}
+
+	offline := false
+	for _, node := range offlineNodes {
+		if !inside(node.Date) {
+			continue
+		}
+		if !offline {
+			response += "\n\nNodes offline:"
+			offline = true
+		}
+		response += "\n- " + node.Date.Format("15:04") + " " + strings.Join(node.Pins, ", ") + " (" + node.Reason + ")"
+	}
+
+	year, month, day := to.AddDate(0, 0, 1).Date()
+	tomorrow := time.Date(year, month, day, 0, 0, 0, 0, to.Location())
+	sort.SliceStable(agenda, func(i, j int) bool {
+		return agenda[i].Date.Before(agenda[j].Date)
+	})
+	response += "\n\nTomorrow:"
+	scheduled := false
+	for _, entry := range agenda {
+		if entry.Date.Before(tomorrow) || !entry.Date.Before(tomorrow.AddDate(0, 0, 1)) {
+			continue
+		}
+		scheduled = true
+		response += "\n- " + entry.Date.Format("15:04") + " " + entry.ProgrammedAction.Description()
+	}
+	if !scheduled {
+		response += "\nNothing scheduled"
+	}
+	if nodesNotAnswering > 0 {
+		response += "\n(" + strconv.Itoa(nodesNotAnswering) + " node(s) did not answer)"
+	}
+	return response
+}
+
+func timesToString(times int) string {
+	if times == 1 {
+		return "once"
+	}
+	return strconv.Itoa(times) + " times"
+}
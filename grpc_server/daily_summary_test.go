@@ -0,0 +1,57 @@
+package grpc_server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextDailySummary(t *testing.T) {
+	summaryTime := time.Date(0, 1, 1, 21, 0, 0, 0, time.UTC)
+	now := time.Date(2020, 5, 3, 12, 0, 0, 0, time.Local)
+	assert.Equal(t, nextDailySummary(summaryTime, now), time.Date(2020, 5, 3, 21, 0, 0, 0, time.Local))
+	now = time.Date(2020, 5, 3, 21, 0, 0, 0, time.Local)
+	assert.Equal(t, nextDailySummary(summaryTime, now), time.Date(2020, 5, 4, 21, 0, 0, 0, time.Local), "The summary just sent should not be sent again")
+}
+
+func TestBuildDailySummary(t *testing.T) {
+	to := time.Date(2020, 5, 3, 21, 0, 0, 0, time.Local)
+	from := to.Add(-24 * time.Hour)
+	pinChanges := []types.PinChange{
+		types.PinChange{Pin: "light", State: true, Date: from.Add(-time.Hour)},
+		types.PinChange{Pin: "light", State: true, Date: from.Add(time.Hour), Manual: true},
+		types.PinChange{Pin: "light", State: false, Date: from.Add(2 * time.Hour)},
+		types.PinChange{Pin: "heater", State: true, Date: from.Add(3 * time.Hour)},
+	}
+	history := []types.Execution{
+		types.Execution{Action: types.Action{Pin: "light"}, Date: from.Add(2 * time.Hour), Success: true},
+		types.Execution{Action: types.Action{Pin: "heater"}, Date: from.Add(4 * time.Hour), Skipped: true},
+		types.Execution{Action: types.Action{Pin: "water"}, Date: from.Add(5 * time.Hour), Error: "pin not set"},
+	}
+	offlineNodes := []offlineNode{
+		offlineNode{Pins: []string{"water", "pump"}, Date: to.Add(-time.Hour), Reason: "stopped answering"},
+		offlineNode{Pins: []string{"old"}, Date: from.Add(-time.Hour), Reason: "unregistered"},
+	}
+	agenda := []types.AgendaEntry{
+		types.AgendaEntry{ProgrammedAction: types.ProgrammedAction{Action: types.Action{Pin: "light", State: false}}, Date: to.Add(time.Hour)},
+		types.AgendaEntry{ProgrammedAction: types.NewReminder("take the bins out", 1, to.Add(23*time.Hour), true), Date: to.Add(23 * time.Hour)},
+		types.AgendaEntry{ProgrammedAction: types.ProgrammedAction{Action: types.Action{Pin: "light", State: true}}, Date: to.Add(10 * time.Hour)},
+	}
+	summary := buildDailySummary(from, to, pinChanges, history, offlineNodes, agenda, 1)
+	assert.Equal(t, summary, "Daily summary (Sun 03/05)\n\n"+
+		"Pins changed:\n- heater: once\n- light: 2 times (1 manual)\n\n"+
+		"Programmed actions:\n1 executed, 1 failed, 1 skipped\n- 02:00 water: pin not set\n\n"+
+		"Nodes offline:\n- 20:00 water, pump (stopped answering)\n\n"+
+		"Tomorrow:\n- 07:00 light on\n- 20:00 remind \"take the bins out\"\n(1 node(s) did not answer)")
+
+	history = []types.Execution{
+		types.Execution{Date: from.Add(2 * time.Hour), Error: "sequences not available", Description: "sequence Garden"},
+	}
+	summary = buildDailySummary(from, to, nil, history, nil, nil, 0)
+	assert.Equal(t, summary, "Daily summary (Sun 03/05)\n\nPins changed:\nNone\n\nProgrammed actions:\n0 executed, 1 failed, 0 skipped\n- 23:00 sequence Garden: sequences not available\n\nTomorrow:\nNothing scheduled")
+
+	summary = buildDailySummary(from, to, nil, nil, nil, nil, 0)
+	assert.Equal(t, summary, "Daily summary (Sun 03/05)\n\nPins changed:\nNone\n\nProgrammed actions:\n0 executed, 0 failed, 0 skipped\n\nTomorrow:\nNothing scheduled")
+}
@@ -5,12 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/audit_log"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
 	messages_protocol "github.com/Alberto-Izquierdo/RPIHomeServer-go/messages"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/proto_conversion"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/schedule_checker"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/peer"
@@ -18,8 +24,12 @@ import (
 
 const timeWaitingForNewActions time.Duration = 2 * time.Second
 const timeWaitingForClientConnection time.Duration = timeWaitingForNewActions * 5
+const timeWaitingForAgendas time.Duration = timeWaitingForNewActions * 2
+const timeWaitingForPinStates time.Duration = timeWaitingForNewActions * 2
+const maxExecutionsInHistory int = 1000
+const maxExecutionsInHistoryMessage int = 30
 
-func SetupAndRun(config configuration_loader.InitialConfiguration, inputChannel chan types.Action, programmedActionsChannel chan types.ProgrammedActionOperation, responsesChannel chan types.TelegramMessage, exitChannel chan bool) error {
+func SetupAndRun(config configuration_loader.InitialConfiguration, channels types.BotChannels, exitChannel chan bool) error {
 	if config.ServerConfiguration == nil {
 		return errors.New("Server parameters not set in the configuration file")
 	}
@@ -30,6 +40,13 @@ func SetupAndRun(config configuration_loader.InitialConfiguration, inputChannel
 	if err != nil {
 		return errors.New("failed to listen: " + err.Error())
 	}
+	subscriptions := newSubscriptions()
+	if config.DataDirectory != "" {
+		err := subscriptions.load(filepath.Join(config.DataDirectory, "subscriptions.json"))
+		if err != nil {
+			fmt.Println("The subscriptions start empty: " + err.Error())
+		}
+	}
 	server := grpc.NewServer()
 	pinsRegistered := make([]string, len(config.PinsActive))
 	for _, pin := range config.PinsActive {
@@ -39,97 +56,256 @@ func SetupAndRun(config configuration_loader.InitialConfiguration, inputChannel
 		clientsRegistered: make(map[net.Addr]*clientRegisteredData),
 		actionsToPerform:  make(map[net.Addr]chan types.Action),
 		programmedActions: make(map[net.Addr]chan types.ProgrammedActionOperation),
-		responsesChannel:  responsesChannel,
+		agendaRequests:    make(map[net.Addr]chan types.AgendaRequest),
+		pendingAgendas:    make(map[int64]*pendingAgenda),
+		pinStateRequests:  make(map[net.Addr]chan types.PinStateRequest),
+		vacationRequests:  make(map[net.Addr]chan types.VacationModeRequest),
+		replayRequests:    make(map[net.Addr]chan types.ReplayRequest),
+		overrideRequests:  make(map[net.Addr]chan types.OverrideRequest),
+		sequenceRequests:  make(map[net.Addr]chan types.SequenceRequest),
+		statusRequests:    make(map[net.Addr]chan types.StatusRequest),
+		pendingStatuses:   make(map[int64]*pendingStatus),
+		pendingPinStates:  make(map[int64]chan *messages_protocol.PinStateResponse),
+		dailySummary:      config.ServerConfiguration.DailySummary,
+		subscriptions:     subscriptions,
+		responsesChannel:  channels.Responses,
 	}
 	messages_protocol.RegisterRPIHomeServerServiceServer(server, &rpiServer)
-	go run(server, &rpiServer, &lis, exitChannel, inputChannel, responsesChannel, programmedActionsChannel)
+	go run(server, &rpiServer, &lis, exitChannel, channels)
 	return nil
 }
 
-func run(server *grpc.Server, rpiServer *rpiHomeServer, listener *net.Listener, exitChannel chan bool, inputChannel chan types.Action, responsesChannel chan types.TelegramMessage, programmedActionsChannel chan types.ProgrammedActionOperation) {
+func run(server *grpc.Server, rpiServer *rpiHomeServer, listener *net.Listener, exitChannel chan bool, channels types.BotChannels) {
 	go server.Serve(*listener)
+	// Nodes that stop asking for actions are removed even if nobody asks for the pins
+	livenessTicker := time.NewTicker(timeWaitingForClientConnection)
+	defer livenessTicker.Stop()
 	for {
 		select {
+		case <-livenessTicker.C:
+			rpiServer.removeStaleClients()
 		case <-exitChannel:
 			server.GracefulStop()
 			fmt.Println("Exit signal received in gRPC server")
 			exitChannel <- true
 			return
-		case action := <-inputChannel:
+		case action := <-channels.Actions:
 			if action.Pin == "start" {
 				activePins := "start " + rpiServer.getPinsAndUpdateMap()
-				responsesChannel <- types.TelegramMessage{activePins, action.ChatId}
+				channels.Responses <- types.TelegramMessage{activePins, action.ChatId}
 			} else {
 				rpiServer.mutex.Lock()
 				client, err := getClientAssociatedWithPin(action.Pin, rpiServer)
 				if err != nil {
-					responsesChannel <- types.TelegramMessage{err.Error(), action.ChatId}
+					channels.Responses <- types.TelegramMessage{err.Error(), action.ChatId}
 				} else {
 					channel := rpiServer.actionsToPerform[client]
 					rpiServer.mutex.Unlock()
 					channel <- action
 				}
 			}
-		case action := <-programmedActionsChannel:
+		case action := <-channels.ProgrammedActions:
 			// Replace with a function
 			rpiServer.mutex.Lock()
 			if action.Operation == types.GET_ACTIONS {
 				// Return the cached programmed actions
-				response := "ProgrammedActions"
-				for _, client := range rpiServer.clientsRegistered {
-					for _, v := range *client.ProgrammedActions {
-						response += " " + types.ProgrammedActionToString(v)
-					}
-				}
-				responsesChannel <- types.TelegramMessage{response, action.ProgrammedAction.Action.ChatId}
+				channels.Responses <- types.TelegramMessage{getProgrammedActionsMessage(rpiServer, action.AllowedPins, action.AllowedNodes), action.ProgrammedAction.Action.ChatId}
+			} else if action.Operation == types.GET_TIMERS {
+				channels.Responses <- types.TelegramMessage{getTimersMessage(rpiServer, action.AllowedPins, action.AllowedNodes), action.ProgrammedAction.Action.ChatId}
+			} else if action.Operation == types.CHECK {
+				channels.Responses <- types.TelegramMessage{getCheckMessage(rpiServer, action.AllowedPins, action.AllowedNodes), action.ProgrammedAction.Action.ChatId}
 			} else {
-				client, err := getClientAssociatedWithPin(action.ProgrammedAction.Action.Pin, rpiServer)
+				var client net.Addr
+				var err error
+				if action.Operation == types.CREATE && action.ProgrammedAction.Type == types.REMINDER_ACTION {
+					client, err = getClientForReminders(rpiServer)
+				} else if action.Operation == types.CREATE && action.ProgrammedAction.Type == types.SEQUENCE_ACTION {
+					client, err = getClientAssociatedWithSequence(action.ProgrammedAction.Sequence, rpiServer)
+				} else if action.Operation == types.CREATE {
+					client, err = getClientAssociatedWithPin(action.ProgrammedAction.Action.Pin, rpiServer)
+				} else {
+					client, err = getClientAssociatedWithProgrammedAction(action.ProgrammedAction.Id, rpiServer)
+				}
 				if err != nil {
-					responsesChannel <- types.TelegramMessage{err.Error(), action.ProgrammedAction.Action.ChatId}
+					channels.Responses <- types.TelegramMessage{err.Error(), action.ProgrammedAction.Action.ChatId}
+					auditOperation(action, nil, "", err.Error())
 				} else {
+					if action.Operation != types.REMOVE && !action.ProgrammedAction.Repeat && !action.ProgrammedAction.Timer {
+						action.ProgrammedAction.Time = oneShotDate(action.ProgrammedAction.Time, time.Now())
+					}
 					// Update the cache
 					slice := rpiServer.clientsRegistered[client].ProgrammedActions
-					if action.Operation == types.REMOVE {
-						found := false
-						for index, v := range *slice {
-							if action.ProgrammedAction.Equals(v) {
-								(*slice)[index] = (*slice)[len(*slice)-1]
-								*slice = (*slice)[:len(*slice)-1]
-								found = true
-								break
-							}
-						}
-						if found {
-							// Send the operation
-							rpiServer.programmedActions[client] <- action
-						} else {
-							responsesChannel <- types.TelegramMessage{"This programmed action did not exist", action.ProgrammedAction.Action.ChatId}
+					found := -1
+					alreadyExisted := false
+					for index, v := range *slice {
+						if action.ProgrammedAction.Equals(v) {
+							found = index
+						} else if action.ProgrammedAction.SameSchedule(v) {
+							alreadyExisted = true
 						}
+					}
+					nodeName := rpiServer.clientsRegistered[client].NodeName
+					var previous *types.ProgrammedAction
+					if found != -1 {
+						copied := (*slice)[found]
+						previous = &copied
+					}
+					result := ""
+					if notAllowed := checkAllowedOperation(action, *slice, found, nodeName); notAllowed != "" {
+						channels.Responses <- types.TelegramMessage{notAllowed, action.ProgrammedAction.Action.ChatId}
+						result = notAllowed
+					} else if action.Operation == types.REMOVE {
+						result = "removed"
+						(*slice)[found] = (*slice)[len(*slice)-1]
+						*slice = (*slice)[:len(*slice)-1]
+						// Send the operation
+						rpiServer.programmedActions[client] <- action
 					} else if action.Operation == types.CREATE {
-						found := false
-						for _, v := range *slice {
-							if action.ProgrammedAction.Equals(v) {
-								found = true
-								break
+						issues := schedule_checker.CheckNew(action.ProgrammedAction, *slice)
+						if alreadyExisted {
+							channels.Responses <- types.TelegramMessage{"This programmed action already existed", action.ProgrammedAction.Action.ChatId}
+							result = "already existed"
+						} else if schedule_checker.HasErrors(issues) {
+							channels.Responses <- types.TelegramMessage{"Programmed action not created:\n" + schedule_checker.IssuesToString(issues), action.ProgrammedAction.Action.ChatId}
+							result = "not created: " + schedule_checker.IssuesToString(issues)
+						} else {
+							result = "created"
+							if len(issues) > 0 {
+								channels.Responses <- types.TelegramMessage{"Programmed action created with warnings:\n" + schedule_checker.IssuesToString(issues), action.ProgrammedAction.Action.ChatId}
+								result = "created with warnings: " + schedule_checker.IssuesToString(issues)
 							}
-						}
-						if !found {
+							action.ProgrammedAction.Id = newProgrammedActionId(rpiServer)
 							*slice = append(*slice, action.ProgrammedAction)
 							// Send the operation
 							rpiServer.programmedActions[client] <- action
+						}
+					} else if action.Operation == types.UPDATE {
+						others := append(append([]types.ProgrammedAction{}, (*slice)[:found]...), (*slice)[found+1:]...)
+						issues := schedule_checker.CheckNew(action.ProgrammedAction, others)
+						newClient, err := getClientAssociatedWithPin(action.ProgrammedAction.Action.Pin, rpiServer)
+						if action.ProgrammedAction.Type == types.REMINDER_ACTION {
+							// Reminders stay in the node that already runs them
+							newClient, err = client, nil
+						} else if action.ProgrammedAction.Type == types.SEQUENCE_ACTION {
+							newClient, err = getClientAssociatedWithSequence(action.ProgrammedAction.Sequence, rpiServer)
+						}
+						if err != nil || newClient != client {
+							channels.Responses <- types.TelegramMessage{"Programmed actions can only be updated with pins from the same node", action.ProgrammedAction.Action.ChatId}
+							result = "not updated: the pin is in another node"
+						} else if alreadyExisted {
+							channels.Responses <- types.TelegramMessage{"This programmed action already existed", action.ProgrammedAction.Action.ChatId}
+							result = "already existed"
+						} else if schedule_checker.HasErrors(issues) {
+							channels.Responses <- types.TelegramMessage{"Programmed action not updated:\n" + schedule_checker.IssuesToString(issues), action.ProgrammedAction.Action.ChatId}
+							result = "not updated: " + schedule_checker.IssuesToString(issues)
 						} else {
-							responsesChannel <- types.TelegramMessage{"This programmed action already existed", action.ProgrammedAction.Action.ChatId}
+							result = "updated"
+							if len(issues) > 0 {
+								channels.Responses <- types.TelegramMessage{"Programmed action updated with warnings:\n" + schedule_checker.IssuesToString(issues), action.ProgrammedAction.Action.ChatId}
+								result = "updated with warnings: " + schedule_checker.IssuesToString(issues)
+							}
+							(*slice)[found] = action.ProgrammedAction
+							// Send the operation
+							rpiServer.programmedActions[client] <- action
 						}
 					}
+					auditOperation(action, previous, nodeName, result)
 					// Return the cached programmed actions
-					response := "ProgrammedActions"
-					for _, client := range rpiServer.clientsRegistered {
-						for _, v := range *client.ProgrammedActions {
-							response += " " + types.ProgrammedActionToString(v)
-						}
+					channels.Responses <- types.TelegramMessage{getProgrammedActionsMessage(rpiServer, action.AllowedPins, action.AllowedNodes), action.ProgrammedAction.Action.ChatId}
+				}
+			}
+			rpiServer.mutex.Unlock()
+		case <-rpiServer.dailySummaryChannel(time.Now()):
+			rpiServer.sendDailySummary(time.Now())
+		case request := <-channels.AgendaRequests:
+			rpiServer.requestAgenda(request, nil)
+		case request := <-channels.SubscriptionRequests:
+			rpiServer.mutex.Lock()
+			response := rpiServer.subscriptions.handleRequest(request, time.Now())
+			rpiServer.mutex.Unlock()
+			if response != "" {
+				channels.Responses <- types.TelegramMessage{response, request.ChatId}
+			}
+		case request := <-channels.StatusRequests:
+			rpiServer.requestStatus(request)
+		case request := <-channels.HistoryRequests:
+			rpiServer.mutex.Lock()
+			response := getHistoryMessage(rpiServer.history, request)
+			rpiServer.mutex.Unlock()
+			channels.Responses <- types.TelegramMessage{response, request.ChatId}
+		case request := <-channels.VacationRequests:
+			rpiServer.mutex.Lock()
+			if len(rpiServer.vacationRequests) == 0 {
+				channels.Responses <- types.TelegramMessage{"There are not any nodes registered", request.ChatId}
+			}
+			// Every node answers with its own status
+			for _, channel := range rpiServer.vacationRequests {
+				go func(channel chan types.VacationModeRequest) {
+					select {
+					case channel <- request:
+					case <-time.After(timeWaitingForClientConnection):
+					}
+				}(channel)
+			}
+			rpiServer.mutex.Unlock()
+		case request := <-channels.ReplayRequests:
+			rpiServer.mutex.Lock()
+			if len(rpiServer.replayRequests) == 0 {
+				channels.Responses <- types.TelegramMessage{"There are not any nodes registered", request.ChatId}
+			}
+			// Every node replays its own pins and answers with its own status
+			for _, channel := range rpiServer.replayRequests {
+				go func(channel chan types.ReplayRequest) {
+					select {
+					case channel <- request:
+					case <-time.After(timeWaitingForClientConnection):
+					}
+				}(channel)
+			}
+			rpiServer.mutex.Unlock()
+		case request := <-channels.OverrideRequests:
+			rpiServer.mutex.Lock()
+			if len(rpiServer.overrideRequests) == 0 {
+				channels.Responses <- types.TelegramMessage{"There are not any nodes registered", request.ChatId}
+			}
+			// Overrides live in the node that owns the pin
+			for _, channel := range rpiServer.overrideRequests {
+				go func(channel chan types.OverrideRequest) {
+					select {
+					case channel <- request:
+					case <-time.After(timeWaitingForClientConnection):
+					}
+				}(channel)
+			}
+			rpiServer.mutex.Unlock()
+		case request := <-channels.SequenceRequests:
+			rpiServer.mutex.Lock()
+			var nodeChannels []chan types.SequenceRequest
+			if request.Operation == types.SEQUENCE_RUN {
+				// Only the node that defines the sequence runs it
+				if client, err := getClientAssociatedWithSequence(request.Name, rpiServer); err != nil {
+					channels.Responses <- types.TelegramMessage{err.Error(), request.ChatId}
+				} else {
+					nodeChannels = append(nodeChannels, rpiServer.sequenceRequests[client])
+				}
+			} else {
+				// Every node with sequences answers with its own status
+				for client, data := range rpiServer.clientsRegistered {
+					if len(data.Sequences) > 0 {
+						nodeChannels = append(nodeChannels, rpiServer.sequenceRequests[client])
 					}
-					responsesChannel <- types.TelegramMessage{response, action.ProgrammedAction.Action.ChatId}
 				}
+				if len(nodeChannels) == 0 {
+					channels.Responses <- types.TelegramMessage{"There are not any sequences configured", request.ChatId}
+				}
+			}
+			for _, channel := range nodeChannels {
+				go func(channel chan types.SequenceRequest) {
+					select {
+					case channel <- request:
+					case <-time.After(timeWaitingForClientConnection):
+					}
+				}(channel)
 			}
 			rpiServer.mutex.Unlock()
 		}
@@ -147,39 +323,289 @@ func getClientAssociatedWithPin(pinName string, rpiServer *rpiHomeServer) (net.A
 	return nil, errors.New("Pin does not exist: " + pinName)
 }
 
+// Users with allow lists can only change the programmed actions of their pins and nodes
+func checkAllowedOperation(operation types.ProgrammedActionOperation, programmedActions []types.ProgrammedAction, found int, nodeName string) string {
+	if len(operation.AllowedPins) == 0 && len(operation.AllowedNodes) == 0 {
+		return ""
+	}
+	var affected []types.ProgrammedAction
+	if operation.Operation != types.REMOVE {
+		affected = append(affected, operation.ProgrammedAction)
+	}
+	if operation.Operation != types.CREATE && found != -1 {
+		affected = append(affected, programmedActions[found])
+	}
+	for _, programmedAction := range affected {
+		if !programmedActionAllowed(programmedAction, nodeName, operation.AllowedPins, operation.AllowedNodes) {
+			return "You are not allowed to change " + programmedAction.Description()
+		}
+	}
+	return ""
+}
+
+// Edits of programmed actions are recorded with their result, previous is the programmed action before the edit
+func auditOperation(operation types.ProgrammedActionOperation, previous *types.ProgrammedAction, nodeName string, result string) {
+	if operation.UserId == 0 {
+		// Only the operations of users are recorded
+		return
+	}
+	entry := types.AuditEntry{Date: time.Now(), UserId: operation.UserId, ChatId: operation.ProgrammedAction.Action.ChatId, Pin: operation.ProgrammedAction.Action.Pin, Node: nodeName, Result: strings.Replace(result, "\n", "; ", -1)}
+	switch operation.Operation {
+	case types.CREATE:
+		entry.Command = "CreateProgrammedAction " + types.ProgrammedActionToString(operation.ProgrammedAction)
+	case types.UPDATE:
+		entry.Command = "UpdateProgrammedAction " + types.ProgrammedActionToString(operation.ProgrammedAction)
+	case types.REMOVE:
+		entry.Command = "RemoveProgrammedAction " + operation.ProgrammedAction.Id
+	}
+	if previous != nil {
+		entry.Command += " (was " + previous.Description() + ")"
+		if entry.Pin == "" {
+			entry.Pin = previous.Action.Pin
+		}
+	}
+	if err := audit_log.Record(entry); err != nil {
+		fmt.Println("There was an error recording an audit entry: ", err.Error())
+	}
+}
+
+// One-shot actions created with a command only have the time of the day, the node runs them the next time
+// it comes, the cache needs the same date to compare them with the one-shot actions registered by the nodes
+func oneShotDate(programmedTime types.MyTime, now time.Time) types.MyTime {
+	t := time.Time(programmedTime)
+	date := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+	if date.Before(now) {
+		date = date.AddDate(0, 0, 1)
+	}
+	return types.MyTime(date)
+}
+
+// Users without allow lists can see everything
+func programmedActionVisible(programmedAction types.ProgrammedAction, nodeName string, allowedPins []string, allowedNodes []string) bool {
+	return (len(allowedPins) == 0 && len(allowedNodes) == 0) || programmedActionAllowed(programmedAction, nodeName, allowedPins, allowedNodes)
+}
+
+// pinsVisible returns if any of the pins (or their node) is in the allow lists, everything is visible when both are empty
+func pinsVisible(pins []string, node string, allowedPins []string, allowedNodes []string) bool {
+	if len(allowedPins) == 0 && len(allowedNodes) == 0 {
+		return true
+	}
+	for _, allowed := range allowedNodes {
+		if node != "" && allowed == node {
+			return true
+		}
+	}
+	for _, pin := range pins {
+		for _, allowed := range allowedPins {
+			if pin == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func programmedActionAllowed(programmedAction types.ProgrammedAction, nodeName string, allowedPins []string, allowedNodes []string) bool {
+	for _, node := range allowedNodes {
+		if nodeName != "" && node == nodeName {
+			return true
+		}
+	}
+	if programmedAction.Type == types.REMINDER_ACTION {
+		return true
+	} else if programmedAction.Type == types.SEQUENCE_ACTION {
+		// Sequences change several pins, only users allowed in the whole node can change them
+		return false
+	}
+	for _, pin := range allowedPins {
+		if pin == programmedAction.Action.Pin {
+			return true
+		}
+	}
+	return false
+}
+
+func getClientAssociatedWithSequence(name string, rpiServer *rpiHomeServer) (net.Addr, error) {
+	for client, data := range rpiServer.clientsRegistered {
+		for _, sequence := range data.Sequences {
+			if sequence == name {
+				return client, nil
+			}
+		}
+	}
+	return nil, errors.New("Sequence does not exist: " + name)
+}
+
+// Reminders do not depend on any pin, so they run in the node next to the server if there is one
+func getClientForReminders(rpiServer *rpiHomeServer) (net.Addr, error) {
+	var result net.Addr
+	for client := range rpiServer.clientsRegistered {
+		if tcpAddr, ok := client.(*net.TCPAddr); ok && tcpAddr.IP.IsLoopback() {
+			return client, nil
+		}
+		// Otherwise always the same node
+		if result == nil || client.String() < result.String() {
+			result = client
+		}
+	}
+	if result == nil {
+		return nil, errors.New("There are not any nodes registered to run the reminder")
+	}
+	return result, nil
+}
+
+func getClientAssociatedWithProgrammedAction(id string, rpiServer *rpiHomeServer) (net.Addr, error) {
+	for client, data := range rpiServer.clientsRegistered {
+		for _, programmedAction := range *data.ProgrammedActions {
+			if programmedAction.Id == id {
+				return client, nil
+			}
+		}
+	}
+	return nil, errors.New("Programmed action does not exist: " + id)
+}
+
+func newProgrammedActionId(rpiServer *rpiHomeServer) string {
+	for {
+		id := types.NewProgrammedActionId()
+		if _, err := getClientAssociatedWithProgrammedAction(id, rpiServer); err != nil {
+			return id
+		}
+	}
+}
+
+// The messages only contain the programmed actions allowed by the lists of the user
+func getProgrammedActionsMessage(rpiServer *rpiHomeServer, allowedPins []string, allowedNodes []string) string {
+	removeExpiredTimers(rpiServer)
+	response := "ProgrammedActions"
+	for _, client := range rpiServer.clientsRegistered {
+		for _, v := range *client.ProgrammedActions {
+			if programmedActionVisible(v, client.NodeName, allowedPins, allowedNodes) {
+				response += " " + v.Id + "=" + types.ProgrammedActionToString(v)
+			}
+		}
+	}
+	return response
+}
+
+func getTimersMessage(rpiServer *rpiHomeServer, allowedPins []string, allowedNodes []string) string {
+	removeExpiredTimers(rpiServer)
+	response := "Timers"
+	for _, client := range rpiServer.clientsRegistered {
+		for _, v := range *client.ProgrammedActions {
+			if v.Timer && programmedActionVisible(v, client.NodeName, allowedPins, allowedNodes) {
+				response += " " + v.Id + "=" + types.ProgrammedActionToString(v)
+			}
+		}
+	}
+	return response
+}
+
+func getCheckMessage(rpiServer *rpiHomeServer, allowedPins []string, allowedNodes []string) string {
+	removeExpiredTimers(rpiServer)
+	var programmedActions []types.ProgrammedAction
+	for _, client := range rpiServer.clientsRegistered {
+		for _, v := range *client.ProgrammedActions {
+			if programmedActionVisible(v, client.NodeName, allowedPins, allowedNodes) {
+				programmedActions = append(programmedActions, v)
+			}
+		}
+	}
+	issues := schedule_checker.Check(programmedActions)
+	if len(issues) == 0 {
+		return "No conflicts found in the " + strconv.Itoa(len(programmedActions)) + " programmed actions"
+	}
+	return "Schedule check:\n" + schedule_checker.IssuesToString(issues)
+}
+
+// Timers are removed from the nodes once executed, so the cache should forget them too
+func removeExpiredTimers(rpiServer *rpiHomeServer) {
+	now := time.Now()
+	for _, client := range rpiServer.clientsRegistered {
+		var remaining []types.ProgrammedAction
+		for _, v := range *client.ProgrammedActions {
+			if !v.Timer || time.Time(v.Time).After(now) {
+				remaining = append(remaining, v)
+			}
+		}
+		*client.ProgrammedActions = remaining
+	}
+}
+
 type rpiHomeServer struct {
 	messages_protocol.RPIHomeServerServiceServer
 	clientsRegistered map[net.Addr]*clientRegisteredData
 	actionsToPerform  map[net.Addr]chan types.Action
 	programmedActions map[net.Addr]chan types.ProgrammedActionOperation
+	agendaRequests    map[net.Addr]chan types.AgendaRequest
+	pendingAgendas    map[int64]*pendingAgenda
+	pinStateRequests  map[net.Addr]chan types.PinStateRequest
+	vacationRequests  map[net.Addr]chan types.VacationModeRequest
+	replayRequests    map[net.Addr]chan types.ReplayRequest
+	overrideRequests  map[net.Addr]chan types.OverrideRequest
+	sequenceRequests  map[net.Addr]chan types.SequenceRequest
+	statusRequests    map[net.Addr]chan types.StatusRequest
+	pendingStatuses   map[int64]*pendingStatus
+	pendingPinStates  map[int64]chan *messages_protocol.PinStateResponse
+	history           []types.Execution
+	pinChanges        []types.PinChange
+	offlineNodes      []offlineNode
+	dailySummary      *configuration_loader.DailySummaryConfiguration
+	subscriptions     *subscriptions
+	lastRequestId     int64
 	responsesChannel  chan types.TelegramMessage
 	mutex             sync.Mutex
 }
 
+type pendingAgenda struct {
+	request        types.AgendaRequest
+	clientsPending map[net.Addr]bool
+	entries        []types.AgendaEntry
+	// Called instead of sending the agenda to the chat when set
+	onComplete func(pending *pendingAgenda)
+}
+
+type pendingStatus struct {
+	request types.StatusRequest
+	// Nodes that did not answer yet
+	clientsPending map[net.Addr]*clientRegisteredData
+	pins           []types.PinStatus
+}
+
 type clientRegisteredData struct {
 	LastTimeConnected time.Time
 	Pins              []string
+	Sequences         []string
+	NodeName          string
 	ProgrammedActions *[]types.ProgrammedAction
 }
 
 func (s *rpiHomeServer) getPinsAndUpdateMap() string {
+	s.removeStaleClients()
 	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	response := ""
+	for _, pins := range s.clientsRegistered {
+		for _, pin := range pins.Pins {
+			response += pin + " "
+		}
+	}
+	return response
+}
+
+// removeStaleClients removes the clients that stopped asking for actions, their subscribers are notified
+func (s *rpiHomeServer) removeStaleClients() {
+	s.mutex.Lock()
 	var clientsToRemove []net.Addr
 	for key, pins := range s.clientsRegistered {
 		if time.Now().Sub(pins.LastTimeConnected) > timeWaitingForClientConnection {
 			clientsToRemove = append(clientsToRemove, key)
-		} else {
-			for _, pin := range pins.Pins {
-				response += pin + " "
-			}
 		}
 	}
 	s.mutex.Unlock()
 	for _, client := range clientsToRemove {
-		s.removeClient(client)
+		s.removeClient(client, "stopped answering")
 	}
-	return response
 }
 
 func (s *rpiHomeServer) RegisterToServer(ctx context.Context, message *messages_protocol.RegistrationMessage) (*messages_protocol.RegistrationResult, error) {
@@ -200,28 +626,34 @@ func (s *rpiHomeServer) RegisterToServer(ctx context.Context, message *messages_
 		}
 		var programmedActions []types.ProgrammedAction
 		for _, programmedAction := range message.ProgrammedActions {
-			myTime := types.MyTime(time.Now())
-			err := myTime.UnmarshalJSON([]byte(programmedAction.Time))
+			converted, err := proto_conversion.ProgrammedActionFromProto(programmedAction)
 			if err != nil {
 				continue
 			}
-			programmedActions = append(programmedActions, types.ProgrammedAction{
-				Action: types.Action{
-					Pin:   programmedAction.Action.Pin,
-					State: programmedAction.Action.State,
-				},
-				Time:   myTime,
-				Repeat: true,
-			})
+			programmedActions = append(programmedActions, converted)
+		}
+		for _, execution := range message.History {
+			s.addExecution(executionFromProto(execution))
 		}
 		s.clientsRegistered[p.Addr] = &clientRegisteredData{
 			LastTimeConnected: time.Now(),
 			Pins:              message.PinsToHandle,
+			Sequences:         message.Sequences,
+			NodeName:          message.NodeName,
 			ProgrammedActions: &programmedActions,
 		}
 
 		s.actionsToPerform[p.Addr] = make(chan types.Action)
 		s.programmedActions[p.Addr] = make(chan types.ProgrammedActionOperation)
+		s.agendaRequests[p.Addr] = make(chan types.AgendaRequest)
+		s.pinStateRequests[p.Addr] = make(chan types.PinStateRequest)
+		s.vacationRequests[p.Addr] = make(chan types.VacationModeRequest)
+		s.replayRequests[p.Addr] = make(chan types.ReplayRequest)
+		s.overrideRequests[p.Addr] = make(chan types.OverrideRequest)
+		s.sequenceRequests[p.Addr] = make(chan types.SequenceRequest)
+		s.statusRequests[p.Addr] = make(chan types.StatusRequest)
+		setNodeOfPins(message.PinsToHandle, message.NodeName)
+		s.sendNotifications(s.subscriptions.notify(nodeNotification(s.clientsRegistered[p.Addr], true, ""), message.PinsToHandle, message.NodeName, 0, time.Now()))
 		code := messages_protocol.RegistrationStatusCodes_Ok
 		result.Result = code
 	} else {
@@ -236,16 +668,28 @@ func (s *rpiHomeServer) UnregisterToServer(ctx context.Context, empty *messages_
 	if !ok {
 		return nil, errors.New("Error while extracting the peer from context")
 	}
-	s.removeClient(p.Addr)
+	s.removeClient(p.Addr, "unregistered")
 	return &messages_protocol.Empty{}, nil
 }
 
-func (s *rpiHomeServer) removeClient(client net.Addr) {
+func (s *rpiHomeServer) removeClient(client net.Addr, reason string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	if data, ok := s.clientsRegistered[client]; ok {
+		s.addOfflineNode(offlineNode{Pins: data.Pins, Date: data.LastTimeConnected, Reason: reason})
+		setNodeOfPins(data.Pins, "")
+		s.sendNotifications(s.subscriptions.notify(nodeNotification(data, false, reason), data.Pins, data.NodeName, 0, time.Now()))
+	}
 	delete(s.clientsRegistered, client)
 	delete(s.actionsToPerform, client)
 	delete(s.programmedActions, client)
+	delete(s.agendaRequests, client)
+	delete(s.pinStateRequests, client)
+	delete(s.vacationRequests, client)
+	delete(s.replayRequests, client)
+	delete(s.overrideRequests, client)
+	delete(s.sequenceRequests, client)
+	delete(s.statusRequests, client)
 }
 
 func (s *rpiHomeServer) CheckForActions(ctx context.Context, empty *messages_protocol.Empty) (*messages_protocol.ActionsToPerform, error) {
@@ -270,18 +714,37 @@ func (s *rpiHomeServer) CheckForActions(ctx context.Context, empty *messages_pro
 		actions.Actions = []*messages_protocol.PinStatePair{&protoAction}
 	case action := <-s.programmedActions[p.Addr]:
 		programmedAction := messages_protocol.ProgrammedActionOperation{
-			Operation: action.Operation,
-			ProgrammedAction: &messages_protocol.ProgrammedAction{
-				Action: &messages_protocol.PinStatePair{
-					Pin:    action.ProgrammedAction.Action.Pin,
-					State:  action.ProgrammedAction.Action.State,
-					ChatId: action.ProgrammedAction.Action.ChatId,
-				},
-				Time:   action.ProgrammedAction.Time.Format("15:04:05"),
-				Repeat: action.ProgrammedAction.Repeat,
-			},
+			Operation:        action.Operation,
+			ProgrammedAction: proto_conversion.ProgrammedActionToProto(action.ProgrammedAction),
 		}
 		actions.ProgrammedActionOperations = []*messages_protocol.ProgrammedActionOperation{&programmedAction}
+	case request := <-s.agendaRequests[p.Addr]:
+		agendaRequest := messages_protocol.AgendaRequest{
+			RequestId:  request.Id,
+			Horizon:    int64(request.Horizon / time.Second),
+			Executions: int32(request.Executions),
+		}
+		actions.AgendaRequests = []*messages_protocol.AgendaRequest{&agendaRequest}
+	case request := <-s.pinStateRequests[p.Addr]:
+		pinStateRequest := messages_protocol.PinStateRequest{RequestId: request.Id, Pin: request.Pin}
+		actions.PinStateRequests = []*messages_protocol.PinStateRequest{&pinStateRequest}
+	case request := <-s.vacationRequests[p.Addr]:
+		vacationRequest := messages_protocol.VacationModeRequest{Enabled: request.Enabled, ChatId: request.ChatId}
+		actions.VacationModeRequests = []*messages_protocol.VacationModeRequest{&vacationRequest}
+	case request := <-s.replayRequests[p.Addr]:
+		replayRequest := messages_protocol.ReplayRequest{Enabled: request.Enabled, ChatId: request.ChatId}
+		if !request.From.IsZero() {
+			replayRequest.From = request.From.Unix()
+		}
+		actions.ReplayRequests = []*messages_protocol.ReplayRequest{&replayRequest}
+	case request := <-s.overrideRequests[p.Addr]:
+		overrideRequest := messages_protocol.OverrideRequest{Clear: request.Clear, Pin: request.Pin, ChatId: request.ChatId}
+		actions.OverrideRequests = []*messages_protocol.OverrideRequest{&overrideRequest}
+	case request := <-s.sequenceRequests[p.Addr]:
+		sequenceRequest := messages_protocol.SequenceRequest{Operation: request.Operation, Name: request.Name, Days: int32(request.Days), ChatId: request.ChatId}
+		actions.SequenceRequests = []*messages_protocol.SequenceRequest{&sequenceRequest}
+	case request := <-s.statusRequests[p.Addr]:
+		actions.StatusRequests = []*messages_protocol.StatusRequest{&messages_protocol.StatusRequest{RequestId: request.Id}}
 	case <-time.After(timeWaitingForNewActions):
 		break
 	}
@@ -295,3 +758,350 @@ func (s *rpiHomeServer) SendMessageToTelegram(ctx context.Context, message *mess
 	s.responsesChannel <- types.TelegramMessage{message.Message, message.ChatId}
 	return &messages_protocol.Empty{}, nil
 }
+
+func (s *rpiHomeServer) SendAgenda(ctx context.Context, agenda *messages_protocol.Agenda) (*messages_protocol.Empty, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("Error while extracting the peer from context")
+	}
+	s.mutex.Lock()
+	pending, ok := s.pendingAgendas[agenda.RequestId]
+	if !ok {
+		s.mutex.Unlock()
+		return nil, errors.New("Agenda request not pending: " + strconv.FormatInt(agenda.RequestId, 10))
+	}
+	nodeName := ""
+	if data, ok := s.clientsRegistered[p.Addr]; ok {
+		nodeName = data.NodeName
+	}
+	for _, entry := range agenda.Entries {
+		programmedAction, err := proto_conversion.ProgrammedActionFromProto(entry.ProgrammedAction)
+		if err != nil || !programmedActionVisible(programmedAction, nodeName, pending.request.AllowedPins, pending.request.AllowedNodes) {
+			continue
+		}
+		pending.entries = append(pending.entries, types.AgendaEntry{
+			ProgrammedAction: programmedAction,
+			Date:             time.Unix(entry.Timestamp, 0),
+		})
+	}
+	delete(pending.clientsPending, p.Addr)
+	completed := len(pending.clientsPending) == 0
+	s.mutex.Unlock()
+	if completed {
+		s.completeAgenda(agenda.RequestId)
+	}
+	return &messages_protocol.Empty{}, nil
+}
+
+func (s *rpiHomeServer) requestAgenda(request types.AgendaRequest, onComplete func(pending *pendingAgenda)) {
+	s.mutex.Lock()
+	s.lastRequestId++
+	request.Id = s.lastRequestId
+	pending := &pendingAgenda{request: request, clientsPending: make(map[net.Addr]bool), onComplete: onComplete}
+	s.pendingAgendas[request.Id] = pending
+	for client, channel := range s.agendaRequests {
+		pending.clientsPending[client] = true
+		go func(channel chan types.AgendaRequest) {
+			select {
+			case channel <- request:
+			case <-time.After(timeWaitingForAgendas):
+			}
+		}(channel)
+	}
+	s.mutex.Unlock()
+	time.AfterFunc(timeWaitingForAgendas, func() {
+		s.completeAgenda(request.Id)
+	})
+}
+
+func (s *rpiHomeServer) completeAgenda(requestId int64) {
+	s.mutex.Lock()
+	pending, ok := s.pendingAgendas[requestId]
+	if !ok {
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.pendingAgendas, requestId)
+	s.mutex.Unlock()
+	if pending.onComplete != nil {
+		pending.onComplete(pending)
+		return
+	}
+	s.responsesChannel <- types.TelegramMessage{Message: buildAgendaMessage(pending), ChatId: pending.request.ChatId}
+}
+
+func buildAgendaMessage(pending *pendingAgenda) string {
+	entries := pending.entries
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date.Before(entries[j].Date)
+	})
+	if pending.request.Executions > 0 && len(entries) > pending.request.Executions {
+		entries = entries[:pending.request.Executions]
+	}
+	response := "Agenda:"
+	if len(entries) == 0 {
+		response += "\nNothing scheduled"
+	}
+	for _, entry := range entries {
+		response += "\n" + types.AgendaEntryToString(entry)
+	}
+	if len(pending.clientsPending) > 0 {
+		response += "\n(" + strconv.Itoa(len(pending.clientsPending)) + " node(s) did not answer)"
+	}
+	return response
+}
+
+func (s *rpiHomeServer) SendStatus(ctx context.Context, status *messages_protocol.NodeStatus) (*messages_protocol.Empty, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("Error while extracting the peer from context")
+	}
+	s.mutex.Lock()
+	pending, ok := s.pendingStatuses[status.RequestId]
+	if !ok {
+		s.mutex.Unlock()
+		return nil, errors.New("Status request not pending: " + strconv.FormatInt(status.RequestId, 10))
+	}
+	node := ""
+	if data, ok := pending.clientsPending[p.Addr]; ok && data != nil {
+		node = data.NodeName
+	}
+	for _, pin := range status.Pins {
+		if !pinsVisible([]string{pin.Pin}, node, pending.request.AllowedPins, pending.request.AllowedNodes) {
+			continue
+		}
+		pinStatus := types.PinStatus{Pin: pin.Pin, State: pin.State, Node: node}
+		if pin.LastChange != 0 {
+			pinStatus.LastChange = &types.PinChange{Pin: pin.Pin, State: pin.State, Date: time.Unix(pin.LastChange, 0), Manual: pin.Manual, ChatId: pin.ChatId}
+		}
+		pending.pins = append(pending.pins, pinStatus)
+	}
+	delete(pending.clientsPending, p.Addr)
+	completed := len(pending.clientsPending) == 0
+	s.mutex.Unlock()
+	if completed {
+		s.completeStatus(status.RequestId)
+	}
+	return &messages_protocol.Empty{}, nil
+}
+
+// requestStatus asks every node for the state of its pins, the nodes that do not answer in time are flagged
+func (s *rpiHomeServer) requestStatus(request types.StatusRequest) {
+	s.mutex.Lock()
+	s.lastRequestId++
+	request.Id = s.lastRequestId
+	pending := &pendingStatus{request: request, clientsPending: make(map[net.Addr]*clientRegisteredData)}
+	s.pendingStatuses[request.Id] = pending
+	for client, channel := range s.statusRequests {
+		pending.clientsPending[client] = s.clientsRegistered[client]
+		go func(channel chan types.StatusRequest) {
+			select {
+			case channel <- request:
+			case <-time.After(timeWaitingForAgendas):
+			}
+		}(channel)
+	}
+	s.mutex.Unlock()
+	if len(pending.clientsPending) == 0 {
+		s.completeStatus(request.Id)
+		return
+	}
+	time.AfterFunc(timeWaitingForAgendas, func() {
+		s.completeStatus(request.Id)
+	})
+}
+
+func (s *rpiHomeServer) completeStatus(requestId int64) {
+	s.mutex.Lock()
+	pending, ok := s.pendingStatuses[requestId]
+	if !ok {
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.pendingStatuses, requestId)
+	s.mutex.Unlock()
+	s.responsesChannel <- types.TelegramMessage{Message: buildStatusMessage(pending), ChatId: pending.request.ChatId}
+}
+
+func buildStatusMessage(pending *pendingStatus) string {
+	pins := pending.pins
+	sort.SliceStable(pins, func(i, j int) bool {
+		return pins[i].Pin < pins[j].Pin
+	})
+	response := "Status:"
+	if len(pins) == 0 && len(pending.clientsPending) == 0 {
+		response += "\nThere are not any nodes connected"
+	}
+	for _, pin := range pins {
+		response += "\n" + types.PinStatusToString(pin)
+	}
+	var silentNodes []string
+	for client, data := range pending.clientsPending {
+		node := ""
+		var visiblePins []string
+		if data != nil {
+			for _, pin := range data.Pins {
+				if pinsVisible([]string{pin}, data.NodeName, pending.request.AllowedPins, pending.request.AllowedNodes) {
+					visiblePins = append(visiblePins, pin)
+				}
+			}
+			if len(visiblePins) == 0 && !pinsVisible(nil, data.NodeName, pending.request.AllowedPins, pending.request.AllowedNodes) {
+				// The user can not see anything of the node
+				continue
+			}
+		}
+		if data != nil && data.NodeName != "" {
+			node = data.NodeName
+		} else if client != nil {
+			node = client.String()
+		}
+		if len(visiblePins) > 0 {
+			node += " (" + strings.Join(visiblePins, ", ") + ")"
+		}
+		silentNodes = append(silentNodes, "Node "+node+" did not answer")
+	}
+	sort.Strings(silentNodes)
+	for _, node := range silentNodes {
+		response += "\n" + node
+	}
+	return response
+}
+
+func (s *rpiHomeServer) QueryPinState(ctx context.Context, pinState *messages_protocol.PinStatePair) (*messages_protocol.PinStatePair, error) {
+	s.mutex.Lock()
+	client, err := getClientAssociatedWithPin(pinState.Pin, s)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	s.lastRequestId++
+	request := types.PinStateRequest{Id: s.lastRequestId, Pin: pinState.Pin}
+	responseChannel := make(chan *messages_protocol.PinStateResponse, 1)
+	s.pendingPinStates[request.Id] = responseChannel
+	requestsChannel := s.pinStateRequests[client]
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pendingPinStates, request.Id)
+		s.mutex.Unlock()
+	}()
+	select {
+	case requestsChannel <- request:
+	case <-time.After(timeWaitingForPinStates):
+		return nil, errors.New("The node with pin " + pinState.Pin + " did not answer")
+	}
+	select {
+	case response := <-responseChannel:
+		if response.Error != "" {
+			return nil, errors.New(response.Error)
+		}
+		return response.PinState, nil
+	case <-time.After(timeWaitingForPinStates):
+		return nil, errors.New("The node with pin " + pinState.Pin + " did not answer")
+	}
+}
+
+func (s *rpiHomeServer) SendPinState(ctx context.Context, response *messages_protocol.PinStateResponse) (*messages_protocol.Empty, error) {
+	s.mutex.Lock()
+	responseChannel, ok := s.pendingPinStates[response.RequestId]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, errors.New("Pin state request not pending: " + strconv.FormatInt(response.RequestId, 10))
+	}
+	responseChannel <- response
+	return &messages_protocol.Empty{}, nil
+}
+
+func executionFromProto(execution *messages_protocol.Execution) types.Execution {
+	return types.Execution{
+		ProgrammedActionId: execution.ProgrammedActionId,
+		Action: types.Action{
+			Pin:    execution.Action.Pin,
+			State:  execution.Action.State,
+			ChatId: execution.Action.ChatId,
+		},
+		Date:        time.Unix(execution.Timestamp, 0),
+		Success:     execution.Success,
+		Skipped:     execution.Skipped,
+		Error:       execution.Error,
+		Description: execution.Description,
+	}
+}
+
+func (s *rpiHomeServer) SendPinChange(ctx context.Context, pinChange *messages_protocol.PinChange) (*messages_protocol.Empty, error) {
+	change := types.PinChange{
+		Pin:    pinChange.Pin,
+		State:  pinChange.State,
+		Date:   time.Unix(pinChange.Timestamp, 0),
+		Manual: pinChange.Manual,
+		ChatId: pinChange.ChatId,
+	}
+	s.mutex.Lock()
+	s.addPinChange(change)
+	node := ""
+	if p, ok := peer.FromContext(ctx); ok && s.clientsRegistered[p.Addr] != nil {
+		node = s.clientsRegistered[p.Addr].NodeName
+	}
+	// The chat that changed the pin already gets the result of its action
+	notifications := s.subscriptions.notify(pinChangeNotification(change, node), []string{change.Pin}, node, change.ChatId, time.Now())
+	s.mutex.Unlock()
+	s.sendNotifications(notifications)
+	return &messages_protocol.Empty{}, nil
+}
+
+func (s *rpiHomeServer) SendExecution(ctx context.Context, execution *messages_protocol.Execution) (*messages_protocol.Empty, error) {
+	s.mutex.Lock()
+	s.addExecution(executionFromProto(execution))
+	s.mutex.Unlock()
+	return &messages_protocol.Empty{}, nil
+}
+
+// Nodes send their whole history when registering, so executions already known are ignored
+func (s *rpiHomeServer) addExecution(execution types.Execution) {
+	index := len(s.history)
+	for index > 0 && s.history[index-1].Date.After(execution.Date) {
+		index--
+	}
+	for other := index - 1; other >= 0 && s.history[other].Date.Equal(execution.Date); other-- {
+		if s.history[other].ProgrammedActionId == execution.ProgrammedActionId && s.history[other].Action.Pin == execution.Action.Pin {
+			return
+		}
+	}
+	s.history = append(s.history, types.Execution{})
+	copy(s.history[index+1:], s.history[index:])
+	s.history[index] = execution
+	if len(s.history) > maxExecutionsInHistory {
+		s.history = s.history[len(s.history)-maxExecutionsInHistory:]
+	}
+}
+
+func getHistoryMessage(history []types.Execution, request types.HistoryRequest) string {
+	var executions []types.Execution
+	for _, execution := range history {
+		if request.Pin != "" && execution.Action.Pin != request.Pin {
+			continue
+		}
+		if !pinsVisible([]string{execution.Action.Pin}, NodeOfPin(execution.Action.Pin), request.AllowedPins, request.AllowedNodes) {
+			continue
+		}
+		if !request.Date.IsZero() {
+			year, month, day := execution.Date.Date()
+			requestYear, requestMonth, requestDay := request.Date.Date()
+			if year != requestYear || month != requestMonth || day != requestDay {
+				continue
+			}
+		}
+		executions = append(executions, execution)
+	}
+	response := "History:"
+	if len(executions) == 0 {
+		response += "\nNo executions recorded"
+	} else if len(executions) > maxExecutionsInHistoryMessage {
+		response += "\n(" + strconv.Itoa(len(executions)-maxExecutionsInHistoryMessage) + " older executions not shown)"
+		executions = executions[len(executions)-maxExecutionsInHistoryMessage:]
+	}
+	for _, execution := range executions {
+		response += "\n" + types.ExecutionToString(execution)
+	}
+	return response
+}
@@ -3,30 +3,34 @@ package grpc_server
 import (
 	"context"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/audit_log"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
 	messages_protocol "github.com/Alberto-Izquierdo/RPIHomeServer-go/messages"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/schedule_checker"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/peer"
 )
 
 func TestWrongConfig(t *testing.T) {
 	var config configuration_loader.InitialConfiguration
-	err := SetupAndRun(config, nil, nil, nil, nil)
+	err := SetupAndRun(config, types.BotChannels{}, nil)
 	exitChannel := make(chan bool)
 	assert.NotEqual(t, err, nil, "Empty config should return an error")
 	config.ServerConfiguration = &configuration_loader.ServerConfiguration{}
-	err = SetupAndRun(config, nil, nil, nil, exitChannel)
+	err = SetupAndRun(config, types.BotChannels{}, exitChannel)
 	assert.NotEqual(t, err, nil, "Empty server port config should return an error")
 	config.ServerConfiguration.GRPCServerPort = -8080
-	err = SetupAndRun(config, nil, nil, nil, exitChannel)
+	err = SetupAndRun(config, types.BotChannels{}, exitChannel)
 	assert.NotEqual(t, err, nil, "Negative server port config should return an error")
 	config.PinsActive = append(config.PinsActive, types.PairNamePin{"pin1", 90})
 	config.ServerConfiguration.GRPCServerPort = 8080
-	err = SetupAndRun(config, nil, nil, nil, exitChannel)
+	err = SetupAndRun(config, types.BotChannels{}, exitChannel)
 	assert.Equal(t, err, nil, "Correct server config should not return an error")
 	exitChannel <- true
 }
@@ -39,6 +43,14 @@ func TestRegisterToServer(t *testing.T) {
 		clientsRegistered: make(map[net.Addr]*clientRegisteredData),
 		actionsToPerform:  make(map[net.Addr]chan types.Action),
 		programmedActions: make(map[net.Addr]chan types.ProgrammedActionOperation),
+		agendaRequests:    make(map[net.Addr]chan types.AgendaRequest),
+		pinStateRequests:  make(map[net.Addr]chan types.PinStateRequest),
+		vacationRequests:  make(map[net.Addr]chan types.VacationModeRequest),
+		replayRequests:    make(map[net.Addr]chan types.ReplayRequest),
+		overrideRequests:  make(map[net.Addr]chan types.OverrideRequest),
+		sequenceRequests:  make(map[net.Addr]chan types.SequenceRequest),
+		statusRequests:    make(map[net.Addr]chan types.StatusRequest),
+		pendingStatuses:   make(map[int64]*pendingStatus),
 	}
 	message0 := messages_protocol.RegistrationMessage{}
 	message0.PinsToHandle = []string{"pin1"}
@@ -119,12 +131,12 @@ func TestGetProgrammedActions(t *testing.T) {
 	server.clientsRegistered[conn.LocalAddr()] = &clientRegisteredData{}
 	go func() {
 		server.programmedActions[conn.LocalAddr()] <- types.ProgrammedActionOperation{
-			types.ProgrammedAction{
+			ProgrammedAction: types.ProgrammedAction{
 				Action: types.Action{"pin1", false, 0},
 				Time:   types.MyTime(time.Now()),
 				Repeat: false,
 			},
-			types.CREATE,
+			Operation: types.CREATE,
 		}
 	}()
 	actions, err := server.CheckForActions(ctx, &messages_protocol.Empty{})
@@ -133,3 +145,284 @@ func TestGetProgrammedActions(t *testing.T) {
 	assert.Equal(t, len(actions.ProgrammedActionOperations), 1, "Check for actions should return 1 programmed action")
 	assert.Equal(t, len(server.programmedActions[conn.LocalAddr()]), 0, "After receiving the actions to perform, they should be removed")
 }
+
+func TestAgenda(t *testing.T) {
+	conn := net.TCPConn{}
+	p := peer.Peer{conn.LocalAddr(), nil}
+	ctx := peer.NewContext(context.TODO(), &p)
+	responsesChannel := make(chan types.TelegramMessage)
+	server := rpiHomeServer{
+		clientsRegistered: make(map[net.Addr]*clientRegisteredData),
+		actionsToPerform:  make(map[net.Addr]chan types.Action),
+		programmedActions: make(map[net.Addr]chan types.ProgrammedActionOperation),
+		agendaRequests:    make(map[net.Addr]chan types.AgendaRequest),
+		pendingAgendas:    make(map[int64]*pendingAgenda),
+		responsesChannel:  responsesChannel,
+	}
+	server.agendaRequests[conn.LocalAddr()] = make(chan types.AgendaRequest)
+	server.clientsRegistered[conn.LocalAddr()] = &clientRegisteredData{}
+	server.requestAgenda(types.AgendaRequest{ChatId: 123}, nil)
+	actions, err := server.CheckForActions(ctx, &messages_protocol.Empty{})
+	assert.Nil(t, err)
+	assert.Equal(t, len(actions.AgendaRequests), 1, "Check for actions should return 1 agenda request")
+	now := time.Now()
+	go server.SendAgenda(ctx, &messages_protocol.Agenda{
+		RequestId: actions.AgendaRequests[0].RequestId,
+		Entries: []*messages_protocol.AgendaEntry{
+			&messages_protocol.AgendaEntry{
+				ProgrammedAction: &messages_protocol.ProgrammedAction{Action: &messages_protocol.PinStatePair{Pin: "pin1", State: false}, Time: "20:00:00", Repeat: true},
+				Timestamp:        now.Add(time.Hour * 2).Unix(),
+			},
+			&messages_protocol.AgendaEntry{
+				ProgrammedAction: &messages_protocol.ProgrammedAction{Action: &messages_protocol.PinStatePair{Pin: "pin1", State: true}, Time: "19:00:00", Repeat: false},
+				Timestamp:        now.Add(time.Hour).Unix(),
+			},
+		},
+	})
+	select {
+	case response := <-responsesChannel:
+		assert.Equal(t, response.ChatId, int64(123))
+		lines := strings.Split(response.Message, "\n")
+		assert.Equal(t, len(lines), 3, "The agenda should contain a header and two entries")
+		assert.True(t, strings.HasSuffix(lines[1], "pin1 on (once)"), "The agenda should be sorted chronologically, instead it is \"%s\"", response.Message)
+		assert.True(t, strings.HasSuffix(lines[2], "pin1 off"), "The agenda should be sorted chronologically, instead it is \"%s\"", response.Message)
+	case <-time.After(timeWaitingForAgendas / 2):
+		t.Errorf("The agenda should be sent as soon as every node answers")
+	}
+	assert.Equal(t, len(server.pendingAgendas), 0, "The agenda request should not be pending after completion")
+}
+
+func TestStatus(t *testing.T) {
+	conn := net.TCPConn{}
+	p := peer.Peer{conn.LocalAddr(), nil}
+	ctx := peer.NewContext(context.TODO(), &p)
+	responsesChannel := make(chan types.TelegramMessage)
+	server := rpiHomeServer{
+		clientsRegistered: make(map[net.Addr]*clientRegisteredData),
+		statusRequests:    make(map[net.Addr]chan types.StatusRequest),
+		pendingStatuses:   make(map[int64]*pendingStatus),
+		responsesChannel:  responsesChannel,
+	}
+	go server.requestStatus(types.StatusRequest{ChatId: 123})
+	assert.Equal(t, <-responsesChannel, types.TelegramMessage{"Status:\nThere are not any nodes connected", 123})
+
+	server.statusRequests[conn.LocalAddr()] = make(chan types.StatusRequest)
+	server.clientsRegistered[conn.LocalAddr()] = &clientRegisteredData{Pins: []string{"pump", "light"}, NodeName: "garden"}
+	server.requestStatus(types.StatusRequest{ChatId: 123})
+	actions, err := server.CheckForActions(ctx, &messages_protocol.Empty{})
+	assert.Nil(t, err)
+	require.Equal(t, len(actions.StatusRequests), 1, "Check for actions should return 1 status request")
+	lastChange := time.Date(2020, 5, 3, 12, 30, 0, 0, time.Local)
+	go server.SendStatus(ctx, &messages_protocol.NodeStatus{
+		RequestId: actions.StatusRequests[0].RequestId,
+		Pins: []*messages_protocol.PinStatus{
+			&messages_protocol.PinStatus{Pin: "pump", State: true, LastChange: lastChange.Unix(), Manual: true, ChatId: 5},
+			&messages_protocol.PinStatus{Pin: "light"},
+		},
+	})
+	select {
+	case response := <-responsesChannel:
+		assert.Equal(t, response.Message, "Status:\nlight off (garden), no changes recorded\npump on (garden), since 03/05 12:30 by chat 5")
+	case <-time.After(timeWaitingForAgendas / 2):
+		t.Errorf("The status should be sent as soon as every node answers")
+	}
+	assert.Equal(t, len(server.pendingStatuses), 0, "The status request should not be pending after completion")
+
+	server.requestStatus(types.StatusRequest{ChatId: 123})
+	<-server.statusRequests[conn.LocalAddr()]
+	assert.Equal(t, (<-responsesChannel).Message, "Status:\nNode garden (pump, light) did not answer", "Nodes that do not answer should be flagged")
+	server.requestStatus(types.StatusRequest{ChatId: 123, AllowedPins: []string{"light"}})
+	actions, _ = server.CheckForActions(ctx, &messages_protocol.Empty{})
+	go server.SendStatus(ctx, &messages_protocol.NodeStatus{
+		RequestId: actions.StatusRequests[0].RequestId,
+		Pins:      []*messages_protocol.PinStatus{&messages_protocol.PinStatus{Pin: "pump", State: true}, &messages_protocol.PinStatus{Pin: "light"}},
+	})
+	assert.Equal(t, (<-responsesChannel).Message, "Status:\nlight off (garden), no changes recorded", "Users should only see the state of their pins")
+	_, err = server.SendStatus(ctx, &messages_protocol.NodeStatus{RequestId: 1234})
+	assert.NotNil(t, err, "Answering a request not pending should return an error")
+
+	pending := &pendingStatus{request: types.StatusRequest{AllowedPins: []string{"light"}}, clientsPending: map[net.Addr]*clientRegisteredData{conn.LocalAddr(): server.clientsRegistered[conn.LocalAddr()]}}
+	assert.Equal(t, buildStatusMessage(pending), "Status:\nNode garden (light) did not answer")
+	pending.request.AllowedPins = []string{"boiler"}
+	assert.Equal(t, buildStatusMessage(pending), "Status:", "Nodes without pins the user can see should not be shown")
+}
+
+func TestProgrammedActionsFilteredByAllowLists(t *testing.T) {
+	date := time.Now().Add(time.Hour)
+	server := rpiHomeServer{clientsRegistered: make(map[net.Addr]*clientRegisteredData)}
+	garden := []types.ProgrammedAction{
+		types.ProgrammedAction{Id: "a", Action: types.Action{"pump", true, 0}, Time: types.MyTime(date)},
+		types.ProgrammedAction{Id: "b", Action: types.Action{"boiler", true, 0}, Time: types.MyTime(date), Timer: true},
+	}
+	server.clientsRegistered[&net.TCPAddr{Port: 1}] = &clientRegisteredData{NodeName: "garden", ProgrammedActions: &garden}
+
+	assert.Contains(t, getProgrammedActionsMessage(&server, nil, nil), " b=", "Users without allow lists should see everything")
+	assert.Equal(t, getProgrammedActionsMessage(&server, []string{"pump"}, nil), "ProgrammedActions a="+types.ProgrammedActionToString(garden[0]))
+	assert.Equal(t, getTimersMessage(&server, []string{"pump"}, nil), "Timers")
+	assert.Contains(t, getTimersMessage(&server, nil, []string{"garden"}), " b=", "Users allowed in the node should see its timers")
+}
+
+func TestQueryPinState(t *testing.T) {
+	conn := net.TCPConn{}
+	p := peer.Peer{conn.LocalAddr(), nil}
+	ctx := peer.NewContext(context.TODO(), &p)
+	server := rpiHomeServer{
+		clientsRegistered: make(map[net.Addr]*clientRegisteredData),
+		actionsToPerform:  make(map[net.Addr]chan types.Action),
+		pinStateRequests:  make(map[net.Addr]chan types.PinStateRequest),
+		pendingPinStates:  make(map[int64]chan *messages_protocol.PinStateResponse),
+	}
+	server.pinStateRequests[conn.LocalAddr()] = make(chan types.PinStateRequest)
+	server.clientsRegistered[conn.LocalAddr()] = &clientRegisteredData{Pins: []string{"pin1"}}
+	go func() {
+		actions, err := server.CheckForActions(ctx, &messages_protocol.Empty{})
+		if assert.Nil(t, err) && assert.Equal(t, len(actions.PinStateRequests), 1) {
+			request := actions.PinStateRequests[0]
+			assert.Equal(t, request.Pin, "pin1")
+			server.SendPinState(ctx, &messages_protocol.PinStateResponse{
+				RequestId: request.RequestId,
+				PinState:  &messages_protocol.PinStatePair{Pin: request.Pin, State: true},
+			})
+		}
+	}()
+	pinState, err := server.QueryPinState(ctx, &messages_protocol.PinStatePair{Pin: "pin1"})
+	assert.Nil(t, err)
+	assert.True(t, pinState.State)
+	assert.Equal(t, len(server.pendingPinStates), 0, "The pin state request should not be pending after completion")
+
+	_, err = server.QueryPinState(ctx, &messages_protocol.PinStatePair{Pin: "pin2"})
+	assert.NotNil(t, err, "Querying a pin not registered should return an error")
+	_, err = server.SendPinState(ctx, &messages_protocol.PinStateResponse{RequestId: 1234})
+	assert.NotNil(t, err, "Answering a request not pending should return an error")
+}
+
+func TestHistory(t *testing.T) {
+	conn := net.TCPConn{}
+	p := peer.Peer{conn.LocalAddr(), nil}
+	ctx := peer.NewContext(context.TODO(), &p)
+	server := rpiHomeServer{
+		clientsRegistered: make(map[net.Addr]*clientRegisteredData),
+		actionsToPerform:  make(map[net.Addr]chan types.Action),
+		programmedActions: make(map[net.Addr]chan types.ProgrammedActionOperation),
+		agendaRequests:    make(map[net.Addr]chan types.AgendaRequest),
+		pinStateRequests:  make(map[net.Addr]chan types.PinStateRequest),
+		vacationRequests:  make(map[net.Addr]chan types.VacationModeRequest),
+		replayRequests:    make(map[net.Addr]chan types.ReplayRequest),
+		overrideRequests:  make(map[net.Addr]chan types.OverrideRequest),
+		sequenceRequests:  make(map[net.Addr]chan types.SequenceRequest),
+		statusRequests:    make(map[net.Addr]chan types.StatusRequest),
+		pendingStatuses:   make(map[int64]*pendingStatus),
+	}
+	yesterday := time.Now().AddDate(0, 0, -1)
+	execution := &messages_protocol.Execution{ProgrammedActionId: "a", Action: &messages_protocol.PinStatePair{Pin: "pin1", State: true}, Timestamp: yesterday.Unix(), Success: true}
+	server.SendExecution(ctx, execution)
+	server.SendExecution(ctx, &messages_protocol.Execution{ProgrammedActionId: "b", Action: &messages_protocol.PinStatePair{Pin: "pin2", State: false}, Timestamp: time.Now().Unix(), Error: "pin not set"})
+	// Registering sends the whole history of the node again
+	server.RegisterToServer(ctx, &messages_protocol.RegistrationMessage{PinsToHandle: []string{"pin1"}, History: []*messages_protocol.Execution{execution}})
+	assert.Equal(t, len(server.history), 2, "Executions already known should not be added again")
+
+	lines := strings.Split(getHistoryMessage(server.history, types.HistoryRequest{}), "\n")
+	assert.Equal(t, len(lines), 3, "The history should contain a header and two executions")
+	assert.True(t, strings.HasSuffix(lines[1], "pin1 on ok"), "The history should be sorted chronologically, instead it is \"%s\"", lines[1])
+	assert.True(t, strings.HasSuffix(lines[2], "pin2 off failed: pin not set"), "Failures should show the error, instead it is \"%s\"", lines[2])
+	lines = strings.Split(getHistoryMessage(server.history, types.HistoryRequest{Pin: "pin2"}), "\n")
+	assert.Equal(t, len(lines), 2, "The history should be filtered by pin")
+	lines = strings.Split(getHistoryMessage(server.history, types.HistoryRequest{Date: yesterday}), "\n")
+	assert.Equal(t, len(lines), 2, "The history should be filtered by date")
+	assert.True(t, strings.HasSuffix(lines[1], "pin1 on ok"))
+	assert.Equal(t, getHistoryMessage(server.history, types.HistoryRequest{Pin: "pin3"}), "History:\nNo executions recorded")
+
+	// Restricted users asking without a pin only see the executions of their pins
+	lines = strings.Split(getHistoryMessage(server.history, types.HistoryRequest{AllowedPins: []string{"pin2"}}), "\n")
+	assert.Equal(t, len(lines), 2, "The history should be filtered by the allowed pins")
+	assert.True(t, strings.HasSuffix(lines[1], "pin2 off failed: pin not set"))
+	setNodeOfPins([]string{"pin1"}, "living")
+	defer setNodeOfPins([]string{"pin1"}, "")
+	lines = strings.Split(getHistoryMessage(server.history, types.HistoryRequest{AllowedNodes: []string{"living"}}), "\n")
+	assert.Equal(t, len(lines), 2, "The history should be filtered by the allowed nodes")
+	assert.True(t, strings.HasSuffix(lines[1], "pin1 on ok"))
+	assert.Equal(t, getHistoryMessage(server.history, types.HistoryRequest{AllowedPins: []string{"pin3"}}), "History:\nNo executions recorded")
+}
+
+func TestCheckSchedule(t *testing.T) {
+	conn := net.TCPConn{}
+	server := rpiHomeServer{clientsRegistered: make(map[net.Addr]*clientRegisteredData)}
+	date := time.Now().Add(time.Hour)
+	programmedActions := []types.ProgrammedAction{
+		types.ProgrammedAction{Id: "a", Action: types.Action{"pin1", true, 0}, Time: types.MyTime(date), Repeat: true},
+	}
+	server.clientsRegistered[conn.LocalAddr()] = &clientRegisteredData{ProgrammedActions: &programmedActions}
+	assert.Equal(t, getCheckMessage(&server, nil, nil), "No conflicts found in the 1 programmed actions")
+	programmedActions = append(programmedActions, types.ProgrammedAction{Id: "b", Action: types.Action{"pin1", false, 0}, Time: types.MyTime(date), Repeat: true})
+	assert.True(t, strings.HasPrefix(getCheckMessage(&server, nil, nil), "Schedule check:\nError: "), "Contradictory actions should be reported")
+}
+
+func TestOneShotDate(t *testing.T) {
+	now := time.Date(2020, 6, 10, 12, 0, 0, 0, time.Local)
+	atTime := func(value string) types.MyTime {
+		myTime := types.MyTime{}
+		require.Nil(t, myTime.UnmarshalJSON([]byte(value)))
+		return myTime
+	}
+	assert.Equal(t, time.Time(oneShotDate(atTime("15:30:00"), now)), time.Date(2020, 6, 10, 15, 30, 0, 0, time.Local), "Times still to come should run today")
+	assert.Equal(t, time.Time(oneShotDate(atTime("07:00:00"), now)), time.Date(2020, 6, 11, 7, 0, 0, 0, time.Local), "Times already past should run tomorrow")
+
+	// The node registers its one-shot actions with their date
+	registered := []types.ProgrammedAction{types.ProgrammedAction{Id: "a", Action: types.Action{"pin1", true, 0}, Time: types.MyTime(time.Date(2020, 6, 12, 7, 0, 0, 0, time.Local))}}
+	created := types.ProgrammedAction{Action: types.Action{"pin1", false, 0}, Time: oneShotDate(atTime("07:00:00"), now)}
+	assert.Equal(t, len(schedule_checker.CheckNew(created, registered)), 0, "One-shot actions on different days should not be contradictory")
+}
+
+func TestCheckAllowedOperation(t *testing.T) {
+	date := time.Now().Add(time.Hour)
+	programmedActions := []types.ProgrammedAction{
+		types.ProgrammedAction{Id: "a", Action: types.Action{"lamp", true, 0}, Time: types.MyTime(date), Repeat: true},
+		types.ProgrammedAction{Id: "b", Action: types.Action{"boiler", true, 0}, Time: types.MyTime(date), Repeat: true},
+	}
+	lamp := types.ProgrammedAction{Action: types.Action{"lamp", false, 0}, Time: types.MyTime(date)}
+	boiler := types.ProgrammedAction{Action: types.Action{"boiler", false, 0}, Time: types.MyTime(date)}
+
+	operation := types.ProgrammedActionOperation{ProgrammedAction: boiler, Operation: types.CREATE}
+	assert.Equal(t, checkAllowedOperation(operation, programmedActions, -1, "living"), "", "Users without allow lists can change everything")
+	operation.AllowedPins = []string{"lamp"}
+	assert.Contains(t, checkAllowedOperation(operation, programmedActions, -1, "living"), "You are not allowed to change")
+	operation.ProgrammedAction = lamp
+	assert.Equal(t, checkAllowedOperation(operation, programmedActions, -1, "living"), "")
+
+	operation = types.ProgrammedActionOperation{ProgrammedAction: types.ProgrammedAction{Id: "b"}, Operation: types.REMOVE, AllowedPins: []string{"lamp"}}
+	assert.Contains(t, checkAllowedOperation(operation, programmedActions, 1, "living"), "boiler", "Removals should check the programmed action removed")
+	operation = types.ProgrammedActionOperation{ProgrammedAction: lamp, Operation: types.UPDATE, AllowedPins: []string{"lamp"}}
+	assert.Contains(t, checkAllowedOperation(operation, programmedActions, 1, "living"), "boiler", "Updates should check the programmed action replaced")
+	assert.Equal(t, checkAllowedOperation(operation, programmedActions, 0, "living"), "")
+
+	sequence := types.ProgrammedAction{Type: types.SEQUENCE_ACTION, Sequence: "garden", Time: types.MyTime(date)}
+	operation = types.ProgrammedActionOperation{ProgrammedAction: sequence, Operation: types.CREATE, AllowedPins: []string{"lamp"}}
+	assert.NotEqual(t, checkAllowedOperation(operation, programmedActions, -1, "garden"), "", "Sequences need the whole node")
+	operation.AllowedNodes = []string{"garden"}
+	assert.Equal(t, checkAllowedOperation(operation, programmedActions, -1, "garden"), "")
+	operation.ProgrammedAction = types.NewReminder("take the bins out", 1, date, false)
+	assert.Equal(t, checkAllowedOperation(operation, programmedActions, -1, "living"), "")
+}
+
+func TestAuditOperation(t *testing.T) {
+	require.Nil(t, audit_log.Setup("", 0))
+	date := time.Now().Add(time.Hour)
+	lamp := types.ProgrammedAction{Id: "a", Action: types.Action{"lamp", true, 1}, Time: types.MyTime(date)}
+
+	auditOperation(types.ProgrammedActionOperation{ProgrammedAction: lamp, Operation: types.CREATE}, nil, "living", "created")
+	auditOperation(types.ProgrammedActionOperation{ProgrammedAction: types.ProgrammedAction{Id: "a", Action: types.Action{"", false, 1}}, Operation: types.REMOVE, UserId: 1}, &lamp, "living", "removed")
+	auditOperation(types.ProgrammedActionOperation{ProgrammedAction: lamp, Operation: types.CREATE, UserId: 1}, nil, "living", "not created: Error: a\nWarning: b")
+	auditOperation(types.ProgrammedActionOperation{ProgrammedAction: types.NewTimer(types.Action{"lamp", false, 1}, time.Hour), Operation: types.CREATE, UserId: 2}, nil, "living", "created")
+
+	entries, err := audit_log.GetEntries(0, "", 10)
+	require.Nil(t, err)
+	require.Equal(t, len(entries), 3, "Operations without user should not be recorded")
+	assert.Equal(t, entries[0].Command, "RemoveProgrammedAction a (was lamp on)")
+	assert.Equal(t, entries[0].Pin, "lamp", "Removals should record the pin of the programmed action removed")
+	assert.Equal(t, entries[0].Node, "living")
+	assert.Equal(t, entries[0].Result, "removed")
+	assert.True(t, strings.HasPrefix(entries[1].Command, "CreateProgrammedAction "))
+	assert.Equal(t, entries[1].Result, "not created: Error: a; Warning: b", "Entries should fit in one line")
+	assert.Equal(t, entries[2].UserId, 2, "Timers should be recorded with their result")
+	assert.Equal(t, entries[2].Result, "created")
+}
@@ -0,0 +1,26 @@
+package grpc_server
+
+import "sync"
+
+// Node of every pin registered, the telegram bot reads it to check the allow lists of the users
+var nodesOfPins = make(map[string]string)
+var nodesOfPinsMutex sync.Mutex
+
+func setNodeOfPins(pins []string, nodeName string) {
+	nodesOfPinsMutex.Lock()
+	defer nodesOfPinsMutex.Unlock()
+	for _, pin := range pins {
+		if nodeName == "" {
+			delete(nodesOfPins, pin)
+		} else {
+			nodesOfPins[pin] = nodeName
+		}
+	}
+}
+
+// NodeOfPin returns the name of the node that handles the pin, empty if the pin is not registered or its node does not have name
+func NodeOfPin(pin string) string {
+	nodesOfPinsMutex.Lock()
+	defer nodesOfPinsMutex.Unlock()
+	return nodesOfPins[pin]
+}
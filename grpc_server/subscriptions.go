@@ -0,0 +1,226 @@
+package grpc_server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/file_storage"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+// subscription sends the events of a pin, of the pins of a node or of every pin to a chat
+type subscription struct {
+	ChatId int64
+	// User that subscribed, its subscriptions are removed when it is revoked
+	UserId int
+	// "pin", "node" or "all"
+	Kind   string
+	Target string
+	// Pins and nodes the user that subscribed can see, every one when both are empty
+	AllowedPins  []string
+	AllowedNodes []string
+}
+
+type subscriptions struct {
+	Subscriptions []subscription
+	// Chats that do not receive notifications until the date
+	MutedUntil map[int64]time.Time
+	path       string
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{MutedUntil: make(map[int64]time.Time)}
+}
+
+// load reads the subscriptions from path (if it exists) and stores them there from now on
+func (s *subscriptions) load(path string) error {
+	s.path = path
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("[grpc_server]: Could not read the subscriptions: " + err.Error())
+	}
+	var loaded subscriptions
+	err = json.Unmarshal(content, &loaded)
+	if err != nil {
+		return errors.New("[grpc_server]: Subscriptions file not valid: " + err.Error())
+	}
+	s.Subscriptions = loaded.Subscriptions
+	if loaded.MutedUntil != nil {
+		s.MutedUntil = loaded.MutedUntil
+	}
+	return nil
+}
+
+func (s *subscriptions) store() error {
+	if s.path == "" {
+		return nil
+	}
+	err := file_storage.StoreJSON(s.path, s)
+	if err != nil {
+		return errors.New("[grpc_server]: Could not store the subscriptions: " + err.Error())
+	}
+	return nil
+}
+
+// handleRequest returns the answer for the chat of the request
+func (s *subscriptions) handleRequest(request types.SubscriptionRequest, now time.Time) string {
+	response := ""
+	switch request.Operation {
+	case types.SUBSCRIPTION_ADD:
+		for _, current := range s.Subscriptions {
+			if current.ChatId == request.ChatId && current.Kind == request.Kind && current.Target == request.Target {
+				return "This chat was already subscribed to " + subscriptionDescription(request.Kind, request.Target)
+			}
+		}
+		s.Subscriptions = append(s.Subscriptions, subscription{request.ChatId, request.UserId, request.Kind, request.Target, request.AllowedPins, request.AllowedNodes})
+		response = "Subscribed to " + subscriptionDescription(request.Kind, request.Target)
+	case types.SUBSCRIPTION_REMOVE:
+		// "all" removes every subscription of the chat
+		var kept []subscription
+		for _, current := range s.Subscriptions {
+			if current.ChatId != request.ChatId || (request.Kind != "all" && (current.Kind != request.Kind || current.Target != request.Target)) {
+				kept = append(kept, current)
+			}
+		}
+		if len(kept) == len(s.Subscriptions) {
+			return "This chat is not subscribed to " + subscriptionDescription(request.Kind, request.Target)
+		}
+		s.Subscriptions = kept
+		response = "Unsubscribed from " + subscriptionDescription(request.Kind, request.Target)
+	case types.SUBSCRIPTION_REVOKE:
+		var kept []subscription
+		for _, current := range s.Subscriptions {
+			if current.UserId != request.UserId {
+				kept = append(kept, current)
+			}
+		}
+		if len(kept) == len(s.Subscriptions) {
+			// The admin already knows the user was revoked
+			return ""
+		}
+		response = strconv.Itoa(len(s.Subscriptions)-len(kept)) + " subscription(s) of user " + strconv.Itoa(request.UserId) + " removed"
+		s.Subscriptions = kept
+	case types.SUBSCRIPTION_MUTE:
+		if request.Duration <= 0 {
+			delete(s.MutedUntil, request.ChatId)
+			response = "Notifications unmuted"
+		} else {
+			s.MutedUntil[request.ChatId] = now.Add(request.Duration)
+			response = "Notifications muted until " + now.Add(request.Duration).Format("02/01 15:04")
+		}
+	default:
+		return s.description(request.ChatId, now)
+	}
+	if err := s.store(); err != nil {
+		response += " (it could not be stored: " + err.Error() + ")"
+	}
+	return response
+}
+
+func (s *subscriptions) description(chatId int64, now time.Time) string {
+	response := "Subscriptions:"
+	subscribed := false
+	for _, current := range s.Subscriptions {
+		if current.ChatId == chatId {
+			response += "\n- " + subscriptionDescription(current.Kind, current.Target)
+			subscribed = true
+		}
+	}
+	if !subscribed {
+		response += "\nNone, use \"/subscribe pin [pin]\", \"/subscribe node [node]\" or \"/subscribe all\""
+	}
+	if mutedUntil, ok := s.MutedUntil[chatId]; ok && now.Before(mutedUntil) {
+		response += "\nMuted until " + mutedUntil.Format("02/01 15:04")
+	}
+	return response
+}
+
+func subscriptionDescription(kind string, target string) string {
+	if kind == "all" {
+		return "every event"
+	}
+	return kind + " " + target
+}
+
+// notify returns the message for every chat subscribed to the pins or to the node that can see them,
+// muted chats and excludedChatId (the chat that caused the event) are skipped
+func (s *subscriptions) notify(message string, pins []string, node string, excludedChatId int64, now time.Time) []types.TelegramMessage {
+	// Servers created without subscriptions (e.g. in tests) do not notify anything
+	if s == nil {
+		return nil
+	}
+	var result []types.TelegramMessage
+	notified := make(map[int64]bool)
+	for _, current := range s.Subscriptions {
+		if notified[current.ChatId] || current.ChatId == excludedChatId || now.Before(s.MutedUntil[current.ChatId]) {
+			continue
+		} else if !current.matches(pins, node) || !current.allowed(pins, node) {
+			continue
+		}
+		notified[current.ChatId] = true
+		result = append(result, types.TelegramMessage{message, current.ChatId})
+	}
+	return result
+}
+
+func (s subscription) matches(pins []string, node string) bool {
+	switch s.Kind {
+	case "all":
+		return true
+	case "node":
+		return node != "" && node == s.Target
+	}
+	for _, pin := range pins {
+		if pin == s.Target {
+			return true
+		}
+	}
+	return false
+}
+
+func (s subscription) allowed(pins []string, node string) bool {
+	return pinsVisible(pins, node, s.AllowedPins, s.AllowedNodes)
+}
+
+func pinChangeNotification(pinChange types.PinChange, node string) string {
+	result := pinChange.Pin + " turned off"
+	if pinChange.State {
+		result = pinChange.Pin + " turned on"
+	}
+	if node != "" {
+		result += " (" + node + ")"
+	}
+	return result + " " + types.PinChangeAuthor(pinChange)
+}
+
+func nodeNotification(data *clientRegisteredData, online bool, reason string) string {
+	result := "A node"
+	if data.NodeName != "" {
+		result = "Node " + data.NodeName
+	}
+	if online {
+		result += " is online"
+	} else {
+		result += " went offline (" + reason + ")"
+	}
+	return result + ", pins: " + strings.Join(data.Pins, ", ")
+}
+
+// sendNotifications does not block the caller, the telegram bot could be waiting for the server
+func (s *rpiHomeServer) sendNotifications(notifications []types.TelegramMessage) {
+	if len(notifications) == 0 {
+		return
+	}
+	go func() {
+		for _, notification := range notifications {
+			s.responsesChannel <- notification
+		}
+	}()
+}
@@ -0,0 +1,115 @@
+package grpc_server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	messages_protocol "github.com/Alberto-Izquierdo/RPIHomeServer-go/messages"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+func TestSubscriptions(t *testing.T) {
+	directory, err := ioutil.TempDir("", "subscriptions")
+	require.Nil(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "subscriptions.json")
+	current := newSubscriptions()
+	require.Nil(t, current.load(path), "A missing file should not be an error")
+	now := time.Date(2020, 5, 3, 12, 0, 0, 0, time.Local)
+
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_LIST, ChatId: 1}, now), "Subscriptions:\nNone, use \"/subscribe pin [pin]\", \"/subscribe node [node]\" or \"/subscribe all\"")
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "pin", Target: "pump", ChatId: 1}, now), "Subscribed to pin pump")
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "pin", Target: "pump", ChatId: 1}, now), "This chat was already subscribed to pin pump")
+	current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "node", Target: "garden", ChatId: 1}, now)
+	current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "all", ChatId: 2, AllowedPins: []string{"light"}}, now)
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_MUTE, Duration: time.Hour, ChatId: 3}, now), "Notifications muted until 03/05 13:00")
+	current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "all", ChatId: 3}, now)
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_LIST, ChatId: 3}, now), "Subscriptions:\n- every event\nMuted until 03/05 13:00")
+
+	assert.Equal(t, current.notify("pump turned on", []string{"pump"}, "garden", 0, now), []types.TelegramMessage{types.TelegramMessage{"pump turned on", 1}}, "Chats should be notified once, muted chats and users that can not see the pin should not be notified")
+	assert.Equal(t, current.notify("pump turned on", []string{"pump"}, "garden", 1, now), []types.TelegramMessage(nil), "The chat that changed the pin should not be notified")
+	assert.Equal(t, current.notify("light turned off", []string{"light"}, "living", 0, now.Add(time.Hour)), []types.TelegramMessage{types.TelegramMessage{"light turned off", 2}, types.TelegramMessage{"light turned off", 3}}, "Chats should be notified again after the mute")
+
+	loaded := newSubscriptions()
+	require.Nil(t, loaded.load(path))
+	assert.Equal(t, loaded.description(1, now), "Subscriptions:\n- pin pump\n- node garden", "Subscriptions should be stored")
+	assert.Equal(t, loaded.description(3, now), "Subscriptions:\n- every event\nMuted until 03/05 13:00", "Mutes should be stored")
+
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_REMOVE, Kind: "pin", Target: "light", ChatId: 1}, now), "This chat is not subscribed to pin light")
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_REMOVE, Kind: "pin", Target: "pump", ChatId: 1}, now), "Unsubscribed from pin pump")
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_MUTE, ChatId: 3}, now), "Notifications unmuted")
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_REMOVE, Kind: "all", ChatId: 1}, now), "Unsubscribed from every event", "\"all\" should remove every subscription of the chat")
+	assert.Equal(t, current.description(1, now), "Subscriptions:\nNone, use \"/subscribe pin [pin]\", \"/subscribe node [node]\" or \"/subscribe all\"")
+
+	current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "pin", Target: "light", ChatId: 4, UserId: 10}, now)
+	current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "node", Target: "living", ChatId: 4, UserId: 10}, now)
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_REVOKE, ChatId: 1, UserId: 10}, now), "2 subscription(s) of user 10 removed")
+	assert.Equal(t, current.description(4, now), "Subscriptions:\nNone, use \"/subscribe pin [pin]\", \"/subscribe node [node]\" or \"/subscribe all\"", "Revoked users should not be notified anymore")
+	assert.Equal(t, current.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_REVOKE, ChatId: 1, UserId: 10}, now), "", "Users without subscriptions should not answer anything")
+	loaded = newSubscriptions()
+	require.Nil(t, loaded.load(path))
+	assert.Equal(t, len(loaded.Subscriptions), 2, "The subscriptions removed should be stored")
+}
+
+func TestSubscriptionNotifications(t *testing.T) {
+	conn := net.TCPConn{}
+	p := peer.Peer{conn.LocalAddr(), nil}
+	ctx := peer.NewContext(context.TODO(), &p)
+	responsesChannel := make(chan types.TelegramMessage)
+	server := rpiHomeServer{
+		clientsRegistered: make(map[net.Addr]*clientRegisteredData),
+		actionsToPerform:  make(map[net.Addr]chan types.Action),
+		programmedActions: make(map[net.Addr]chan types.ProgrammedActionOperation),
+		agendaRequests:    make(map[net.Addr]chan types.AgendaRequest),
+		pinStateRequests:  make(map[net.Addr]chan types.PinStateRequest),
+		vacationRequests:  make(map[net.Addr]chan types.VacationModeRequest),
+		replayRequests:    make(map[net.Addr]chan types.ReplayRequest),
+		overrideRequests:  make(map[net.Addr]chan types.OverrideRequest),
+		sequenceRequests:  make(map[net.Addr]chan types.SequenceRequest),
+		statusRequests:    make(map[net.Addr]chan types.StatusRequest),
+		subscriptions:     newSubscriptions(),
+		responsesChannel:  responsesChannel,
+	}
+	server.subscriptions.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "node", Target: "garden", ChatId: 1}, time.Now())
+
+	go server.RegisterToServer(ctx, &messages_protocol.RegistrationMessage{PinsToHandle: []string{"pump", "valve"}, NodeName: "garden"})
+	assert.Equal(t, <-responsesChannel, types.TelegramMessage{"Node garden is online, pins: pump, valve", 1})
+	go server.SendPinChange(ctx, &messages_protocol.PinChange{Pin: "pump", State: true, Timestamp: time.Now().Unix()})
+	assert.Equal(t, <-responsesChannel, types.TelegramMessage{"pump turned on (garden) by a programmed action", 1})
+	go server.removeClient(conn.LocalAddr(), "stopped answering")
+	assert.Equal(t, <-responsesChannel, types.TelegramMessage{"Node garden went offline (stopped answering), pins: pump, valve", 1})
+}
+
+func TestSubscribersAreNotifiedOfStaleNodes(t *testing.T) {
+	conn := net.TCPConn{}
+	responsesChannel := make(chan types.TelegramMessage)
+	server := rpiHomeServer{
+		clientsRegistered: make(map[net.Addr]*clientRegisteredData),
+		subscriptions:     newSubscriptions(),
+		responsesChannel:  responsesChannel,
+	}
+	server.subscriptions.handleRequest(types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "node", Target: "garden", ChatId: 1}, time.Now())
+	server.clientsRegistered[conn.LocalAddr()] = &clientRegisteredData{LastTimeConnected: time.Now().Add(-2 * timeWaitingForClientConnection), Pins: []string{"pump"}, NodeName: "garden"}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	exitChannel := make(chan bool)
+	go run(grpc.NewServer(), &server, &listener, exitChannel, types.BotChannels{})
+	select {
+	case notification := <-responsesChannel:
+		assert.Equal(t, notification.Message, "Node garden went offline (stopped answering), pins: pump", "Stale nodes should be removed without a /start")
+	case <-time.After(timeWaitingForClientConnection + 2*time.Second):
+		t.Errorf("Subscribers should be notified when a node stops answering")
+	}
+	exitChannel <- true
+	<-exitChannel
+}
@@ -0,0 +1,142 @@
+package history_manager
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/file_storage"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+const DefaultHistorySize int = 200
+const DefaultPinChangesSize int = 2000
+
+// Setup loads the executions stored in path (if any). An empty path keeps the history only in memory
+func Setup(path string, size int) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if size < 0 {
+		return errors.New("History size should not be negative")
+	}
+	manager.path = path
+	manager.size = size
+	manager.executions = nil
+	err := load(path, &manager.executions)
+	if err != nil {
+		manager.executions = nil
+		return err
+	}
+	manager.trim()
+	return nil
+}
+
+// SetupPinChanges loads the pin changes stored in path (if any). An empty path keeps them only in memory
+func SetupPinChanges(path string, size int) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if size < 0 {
+		return errors.New("Pin changes size should not be negative")
+	}
+	manager.pinChangesPath = path
+	manager.pinChangesSize = size
+	manager.pinChanges = nil
+	err := load(path, &manager.pinChanges)
+	if err != nil {
+		manager.pinChanges = nil
+		return err
+	}
+	manager.trim()
+	return nil
+}
+
+func Record(execution types.Execution) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.executions = append(manager.executions, execution)
+	manager.trim()
+	return store(manager.path, manager.executions)
+}
+
+func RecordPinChange(pinChange types.PinChange) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.pinChanges = append(manager.pinChanges, pinChange)
+	manager.trim()
+	return store(manager.pinChangesPath, manager.pinChanges)
+}
+
+func GetExecutions() []types.Execution {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	executions := make([]types.Execution, len(manager.executions))
+	copy(executions, manager.executions)
+	return executions
+}
+
+func GetPinChanges() []types.PinChange {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	pinChanges := make([]types.PinChange, len(manager.pinChanges))
+	copy(pinChanges, manager.pinChanges)
+	return pinChanges
+}
+
+var manager historyManager
+
+type historyManager struct {
+	path           string
+	size           int
+	executions     []types.Execution
+	pinChangesPath string
+	pinChangesSize int
+	pinChanges     []types.PinChange
+	mutex          sync.Mutex
+}
+
+func (m *historyManager) trim() {
+	size := m.size
+	if size == 0 {
+		size = DefaultHistorySize
+	}
+	if len(m.executions) > size {
+		m.executions = m.executions[len(m.executions)-size:]
+	}
+	size = m.pinChangesSize
+	if size == 0 {
+		size = DefaultPinChangesSize
+	}
+	if len(m.pinChanges) > size {
+		m.pinChanges = m.pinChanges[len(m.pinChanges)-size:]
+	}
+}
+
+func load(path string, value interface{}) error {
+	if path == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("[history_manager]: Could not read the history: " + err.Error())
+	}
+	err = json.Unmarshal(content, value)
+	if err != nil {
+		return errors.New("[history_manager]: History file not valid: " + err.Error())
+	}
+	return nil
+}
+
+func store(path string, value interface{}) error {
+	if path == "" {
+		return nil
+	}
+	err := file_storage.StoreJSON(path, value)
+	if err != nil {
+		return errors.New("[history_manager]: Could not store the history: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package history_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryIsBounded(t *testing.T) {
+	err := Setup("", 2)
+	assert.Nil(t, err)
+	for index := 0; index < 3; index++ {
+		Record(types.Execution{ProgrammedActionId: string(rune('a' + index)), Success: true})
+	}
+	executions := GetExecutions()
+	assert.Equal(t, len(executions), 2, "The history should not grow over its size")
+	assert.Equal(t, executions[0].ProgrammedActionId, "b", "The oldest executions should be discarded")
+	assert.Equal(t, executions[1].ProgrammedActionId, "c")
+	assert.NotNil(t, Setup("", -1), "Negative sizes should return an error")
+}
+
+func TestHistoryIsPersisted(t *testing.T) {
+	directory, err := ioutil.TempDir("", "history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "history.json")
+
+	err = Setup(path, 0)
+	assert.Nil(t, err, "A history file that does not exist yet should not return an error")
+	date := time.Date(2020, 5, 3, 7, 0, 0, 0, time.Local)
+	err = Record(types.Execution{ProgrammedActionId: "a", Action: types.Action{Pin: "light", State: true}, Date: date, Success: false, Error: "pin not set"})
+	assert.Nil(t, err)
+
+	err = Setup(path, 0)
+	assert.Nil(t, err)
+	executions := GetExecutions()
+	assert.Equal(t, len(executions), 1, "The history should be loaded from disk")
+	assert.Equal(t, executions[0].Action.Pin, "light")
+	assert.True(t, executions[0].Date.Equal(date))
+	assert.Equal(t, executions[0].Error, "pin not set")
+
+	ioutil.WriteFile(path, []byte("not json"), 0644)
+	assert.NotNil(t, Setup(path, 0), "Corrupted history files should return an error")
+	assert.Equal(t, len(GetExecutions()), 0)
+}
+
+func TestPinChangesArePersisted(t *testing.T) {
+	directory, err := ioutil.TempDir("", "history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "pin_changes.json")
+
+	err = SetupPinChanges(path, 2)
+	assert.Nil(t, err)
+	date := time.Date(2020, 5, 3, 7, 0, 0, 0, time.Local)
+	for index := 0; index < 3; index++ {
+		err = RecordPinChange(types.PinChange{Pin: "light", State: index%2 == 0, Date: date.Add(time.Duration(index) * time.Minute), Manual: true})
+		assert.Nil(t, err)
+	}
+
+	err = SetupPinChanges(path, 2)
+	assert.Nil(t, err)
+	pinChanges := GetPinChanges()
+	assert.Equal(t, len(pinChanges), 2, "The pin changes should be loaded from disk without growing over their size")
+	assert.True(t, pinChanges[0].Date.Equal(date.Add(time.Minute)), "The oldest pin changes should be discarded")
+	assert.True(t, pinChanges[1].Manual)
+	assert.Equal(t, len(GetExecutions()), 0, "Pin changes should not be mixed with the executions")
+}
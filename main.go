@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/audit_log"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/grpc_server"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/rpi_client"
@@ -28,17 +30,30 @@ func main() {
 
 	// gRPC server and telegram bot
 	if config.ServerConfiguration != nil {
-		tgGrpcActionsChannel := make(chan types.Action)
-		tgGrpcOperationsChannel := make(chan types.ProgrammedActionOperation)
-		tgGrpcResponsesChannel := make(chan types.TelegramMessage)
+		// The telegram bot and the gRPC server record the commands of the users
+		auditLogPath := ""
+		if config.DataDirectory != "" {
+			auditLogPath = filepath.Join(config.DataDirectory, "audit.log")
+		}
+		err = audit_log.Setup(auditLogPath, config.ServerConfiguration.AuditLogSize)
+		if err != nil {
+			fmt.Println("Error while setting up the audit log: " + err.Error())
+			return
+		}
+		botChannels := types.NewBotChannels()
+		frontend, err := telegram_bot.NewTelegramFrontend(config.ServerConfiguration.TelegramBotToken, config.ServerConfiguration.TelegramApiUrl, config.ServerConfiguration.TelegramWebhook)
+		if err != nil {
+			fmt.Println("Error while connecting to telegram: " + err.Error())
+			return
+		}
 		exitChannels = append(exitChannels, make(chan bool))
-		err = telegram_bot.LaunchTelegramBot(config, tgGrpcActionsChannel, tgGrpcOperationsChannel, tgGrpcResponsesChannel, exitChannels[len(exitChannels)-1])
+		err = telegram_bot.LaunchTelegramBot(config, frontend, grpc_server.NodeOfPin, botChannels, exitChannels[len(exitChannels)-1])
 		if err != nil {
 			fmt.Println("Error while setting up telegram bot: " + err.Error())
 			return
 		}
 		exitChannels = append(exitChannels, make(chan bool))
-		err = grpc_server.SetupAndRun(config, tgGrpcActionsChannel, tgGrpcOperationsChannel, tgGrpcResponsesChannel, exitChannels[len(exitChannels)-1])
+		err = grpc_server.SetupAndRun(config, botChannels, exitChannels[len(exitChannels)-1])
 		if err != nil {
 			fmt.Println("Error while setting up gRPC server: " + err.Error())
 			return
@@ -3,15 +3,22 @@ package message_generator
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	ordered_queue "github.com/Alberto-Izquierdo/GoOrderedQueue"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/gpio_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/history_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/sensor_manager"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
 )
 
-func Run(actions []types.ProgrammedAction, inputChannel chan types.ProgrammedActionOperation, outputChannel chan types.TelegramMessage, exitChannel chan bool) error {
+func Run(actions []types.ProgrammedAction, channels types.NodeChannels, remotePinStateGetter func(pin string) (bool, error), vacationConfiguration types.VacationConfiguration, overrideConfigurations []types.OverrideConfiguration, sequences []types.Sequence, exitChannel chan bool) error {
 	queue := ordered_queue.OrderedQueue{}
+	vacation := newVacationMode(vacationConfiguration)
+	replay := &replayMode{}
+	overrides := newOverrideMode(overrideConfigurations)
+	sequenceRunner := newSequenceRunner(sequences)
 	err := initQueue(actions, &queue)
 	if err != nil {
 		fmt.Println("Error while creating the module: " + err.Error())
@@ -36,14 +43,54 @@ func Run(actions []types.ProgrammedAction, inputChannel chan types.ProgrammedAct
 			case _ = <-exitChannel:
 				fmt.Println("[message_generator] Exit signal received, exiting...")
 				return
-			case operation := <-inputChannel:
+			case operation := <-channels.ProgrammedActions:
 				response, addPreviousAction := handleOperation(operation, &queue, nextAction, nextActionValid)
-				outputChannel <- response
+				channels.Responses <- response
 				if nextActionValid == true && addPreviousAction == true {
 					queue.Push(nextAction)
 				}
+			case request := <-channels.AgendaRequests:
+				channels.Agendas <- getAgenda(request, &queue, nextAction, nextActionValid, time.Now())
+				if nextActionValid == true {
+					queue.Push(nextAction)
+				}
+			case request := <-channels.VacationRequests:
+				if nextActionValid == true {
+					queue.Push(nextAction)
+				}
+				channels.Responses <- vacation.handleRequest(request, &queue, time.Now())
+			case request := <-channels.ReplayRequests:
+				if nextActionValid == true {
+					queue.Push(nextAction)
+				}
+				channels.Responses <- replay.handleRequest(request, history_manager.GetPinChanges(), gpio_manager.GetPinsAvailable(), &queue, time.Now())
+			case pinChange := <-channels.ManualChanges:
+				if nextActionValid == true {
+					queue.Push(nextAction)
+				}
+				overrides.handlePinChange(pinChange)
+			case request := <-channels.OverrideRequests:
+				if nextActionValid == true {
+					queue.Push(nextAction)
+				}
+				channels.Responses <- overrides.handleRequest(request, time.Now())
+			case request := <-channels.SequenceRequests:
+				if nextActionValid == true {
+					queue.Push(nextAction)
+				}
+				sendMessages(sequenceRunner.handleRequest(request, time.Now()), channels.Responses)
+			case <-sequenceRunner.timerChannel(now):
+				if nextActionValid == true {
+					queue.Push(nextAction)
+				}
+				sendMessages(sequenceRunner.advance(time.Now()), channels.Responses)
+			case <-vacation.planningChannel(now):
+				if nextActionValid == true {
+					queue.Push(nextAction)
+				}
+				vacation.handlePlanning(&queue, time.Now())
 			case <-time.After(t.Sub(now)):
-				handleNextAction(&nextAction, &queue, exitChannel)
+				handleNextAction(&nextAction, &queue, channels.Responses, remotePinStateGetter, channels.Executions, vacation, replay, overrides, sequenceRunner, exitChannel)
 			}
 		}
 	}()
@@ -55,14 +102,11 @@ func initQueue(actions []types.ProgrammedAction, queue *ordered_queue.OrderedQue
 		return errors.New("No actions to launch")
 	}
 	for _, programmedAction := range actions {
-		currTime := time.Time(programmedAction.Time)
-		now := time.Now()
-		date := time.Date(now.Year(), now.Month(), now.Day(), currTime.Hour(), currTime.Minute(), currTime.Second(), 0, now.Location())
-		for date.Before(now) {
-			date = date.Add(time.Hour * 24)
-		}
-		programmedAction.Time = types.MyTime(date)
-		err := queue.Push(types.ProgrammedAction{Action: programmedAction.Action, Repeat: programmedAction.Repeat, Time: types.MyTime(date)})
+		if !programmedAction.Timer {
+			programmedAction.Time = nextOccurrence(programmedAction.Time)
+			programmedAction.Time = nextRunDay(programmedAction)
+		}
+		err := queue.Push(programmedAction)
 		if err != nil {
 			return errors.New("[message_generator]: Could not push elements into the queue: " + err.Error())
 		}
@@ -70,16 +114,81 @@ func initQueue(actions []types.ProgrammedAction, queue *ordered_queue.OrderedQue
 	return nil
 }
 
-func handleNextAction(nextAction *types.ProgrammedAction, queue *ordered_queue.OrderedQueue, exitChannel chan bool) {
-	// Enqueue the action to the gpio manager
-	gpio_manager.HandleAction(nextAction.Action)
-	// Push the action again but with the time increased 24 hours
-	if nextAction.Repeat == true {
-		newAction := types.ProgrammedAction{
-			Action: nextAction.Action,
-			Repeat: true,
-			Time:   types.MyTime(time.Time(nextAction.Time).Add(time.Hour * 24)),
+func handleNextAction(nextAction *types.ProgrammedAction, queue *ordered_queue.OrderedQueue, outputChannel chan types.TelegramMessage, remotePinStateGetter func(pin string) (bool, error), executionsChannel chan types.Execution, vacation *vacationMode, replay *replayMode, overrides *overrideMode, sequenceRunner *sequenceRunner, exitChannel chan bool) {
+	execution := types.Execution{ProgrammedActionId: nextAction.Id, Action: nextAction.Action, Date: time.Now()}
+	if nextAction.Type != types.PIN_ACTION {
+		execution.Description = nextAction.Description()
+	}
+	message := "Programmed action " + types.ProgrammedActionToCompactString(*nextAction)
+	notify := nextAction.Notify
+	conditionsMet, reason := true, ""
+	if overridden, overrideReason := overrides.check(*nextAction, execution.Date); overridden {
+		conditionsMet, reason = false, overrideReason
+	} else if delayed, delayReason := sequenceRunner.checkRainDelay(execution.Date); delayed && nextAction.Type == types.SEQUENCE_ACTION {
+		conditionsMet, reason = false, delayReason
+	} else {
+		conditionsMet, reason = checkConditions(nextAction.Conditions, remotePinStateGetter)
+	}
+	if conditionsMet && nextAction.Type == types.REMINDER_ACTION {
+		go func(reminder types.TelegramMessage) {
+			outputChannel <- reminder
+		}(types.TelegramMessage{Message: "Reminder: " + nextAction.Message, ChatId: nextAction.Action.ChatId})
+		execution.Success = true
+		message += " executed"
+	} else if conditionsMet && nextAction.Type == types.SEQUENCE_ACTION {
+		if sequenceRunner == nil {
+			execution.Error = "sequences not available"
+			message += " failed: " + execution.Error
+			notify = notify || nextAction.AlertOnFailure
+		} else {
+			go sendMessages(sequenceRunner.start(nextAction.Sequence, nextAction.NotificationChatId(), execution.Date), outputChannel)
+			execution.Success = true
+			message += " executed"
 		}
+	} else if conditionsMet {
+		// Enqueue the action to the gpio manager
+		_, err := gpio_manager.HandleAction(nextAction.Action)
+		if err != nil {
+			execution.Error = err.Error()
+			message += " failed: " + err.Error()
+			notify = notify || nextAction.AlertOnFailure
+		} else {
+			execution.Success = true
+			message += " executed"
+		}
+	} else {
+		execution.Skipped = true
+		execution.Error = reason
+		message += " skipped: " + reason
+		notify = notify || nextAction.NotifySkipped
+	}
+	if !execution.Success {
+		fmt.Println("[message_generator]: " + message)
+	}
+	if notify && nextAction.NotificationChatId() != 0 {
+		go func(response types.TelegramMessage) {
+			outputChannel <- response
+		}(types.TelegramMessage{Message: message, ChatId: nextAction.NotificationChatId()})
+	}
+	err := history_manager.Record(execution)
+	if err != nil {
+		fmt.Println("[message_generator]: Could not record the execution: ", err.Error())
+	}
+	if executionsChannel != nil {
+		go func() {
+			executionsChannel <- execution
+		}()
+	}
+	err = replay.reschedule(*nextAction, queue)
+	if err != nil {
+		fmt.Println("[message_generator]: Could not push elements into the queue: ", err.Error())
+	}
+	// Push the action again but with the time increased 24 hours (or until the next day it runs)
+	if nextAction.Repeat == true {
+		newAction := *nextAction
+		newAction.Time = types.MyTime(time.Time(nextAction.Time).Add(time.Hour * 24))
+		newAction.Time = nextRunDay(newAction)
+		newAction = vacation.applyJitter(newAction, time.Now())
 		err := queue.Push(newAction)
 		if err != nil {
 			fmt.Println("[message_generator]: Could not push elements into the queue: ", err.Error())
@@ -89,15 +198,62 @@ func handleNextAction(nextAction *types.ProgrammedAction, queue *ordered_queue.O
 	}
 }
 
+// Messages without chat (e.g. progress of sequences programmed in the configuration) are not sent
+func sendMessages(messages []types.TelegramMessage, outputChannel chan types.TelegramMessage) {
+	for _, message := range messages {
+		if message.ChatId != 0 {
+			outputChannel <- message
+		}
+	}
+}
+
+func checkConditions(conditions []types.Condition, remotePinStateGetter func(pin string) (bool, error)) (bool, string) {
+	for _, condition := range conditions {
+		if condition.Sensor != "" {
+			value, err := sensor_manager.GetSensorValue(condition.Sensor)
+			if err != nil {
+				return false, err.Error()
+			}
+			met, err := condition.Compare(value)
+			if err != nil {
+				return false, err.Error()
+			} else if !met {
+				return false, condition.Sensor + " is " + strconv.FormatFloat(value, 'f', -1, 64) + ", condition " + types.ConditionToString(condition) + " not met"
+			}
+			continue
+		}
+		local := false
+		for _, pin := range gpio_manager.GetPinsAvailable() {
+			if pin == condition.Pin {
+				local = true
+				break
+			}
+		}
+		var state bool
+		if local {
+			state = gpio_manager.GetPinState(condition.Pin)
+		} else if remotePinStateGetter == nil {
+			return false, "pin " + condition.Pin + " is not available in this node"
+		} else {
+			var err error
+			state, err = remotePinStateGetter(condition.Pin)
+			if err != nil {
+				return false, "could not get the state of pin " + condition.Pin + ": " + err.Error()
+			}
+		}
+		if state != condition.State {
+			return false, "condition " + types.ConditionToString(condition) + " not met"
+		}
+	}
+	return true, ""
+}
+
 func handleOperation(operation types.ProgrammedActionOperation, queue *ordered_queue.OrderedQueue, nextAction types.ProgrammedAction, nextActionValid bool) (response types.TelegramMessage, addPreviousAction bool) {
 	addPreviousAction = true
 	programmedAction := operation.ProgrammedAction
-	currTime := time.Time(programmedAction.Time)
-	now := time.Now()
-	date := time.Date(now.Year(), now.Month(), now.Day(), currTime.Hour(), currTime.Minute(), currTime.Second(), 0, now.Location())
-	programmedAction.Time = types.MyTime(date)
-	for time.Time(programmedAction.Time).Before(time.Now()) {
-		programmedAction.Time = types.MyTime(time.Time(programmedAction.Time).Add(24 * time.Hour))
+	if !programmedAction.Timer {
+		programmedAction.Time = nextOccurrence(programmedAction.Time)
+		programmedAction.Time = nextRunDay(programmedAction)
 	}
 	switch operation.Operation {
 	case types.CREATE:
@@ -112,15 +268,115 @@ func handleOperation(operation types.ProgrammedActionOperation, queue *ordered_q
 			response = types.TelegramMessage{Message: "Programmed action removed", ChatId: programmedAction.Action.ChatId}
 			addPreviousAction = false
 		} else {
-			removed, err := queue.RemoveElement(programmedAction)
-			if removed {
+			_, err := removeProgrammedAction(queue, programmedAction.Id)
+			if err == nil {
 				response = types.TelegramMessage{Message: "Programmed action removed", ChatId: programmedAction.Action.ChatId}
 			} else {
 				response = types.TelegramMessage{Message: "Error while trying to remove the new programmed action: " + err.Error(), ChatId: programmedAction.Action.ChatId}
 			}
 		}
+	case types.UPDATE:
+		if nextActionValid == true && programmedAction.Equals(nextAction) {
+			err := queue.Push(programmedAction)
+			if err == nil {
+				response = types.TelegramMessage{Message: "Programmed action updated", ChatId: programmedAction.Action.ChatId}
+				addPreviousAction = false
+			} else {
+				response = types.TelegramMessage{Message: "Error while trying to update the programmed action: " + err.Error(), ChatId: programmedAction.Action.ChatId}
+			}
+		} else {
+			previousProgrammedAction, err := removeProgrammedAction(queue, programmedAction.Id)
+			if err != nil {
+				response = types.TelegramMessage{Message: "Error while trying to update the programmed action: " + err.Error(), ChatId: programmedAction.Action.ChatId}
+			} else if err = queue.Push(programmedAction); err != nil {
+				queue.Push(previousProgrammedAction)
+				response = types.TelegramMessage{Message: "Error while trying to update the programmed action: " + err.Error(), ChatId: programmedAction.Action.ChatId}
+			} else {
+				response = types.TelegramMessage{Message: "Programmed action updated", ChatId: programmedAction.Action.ChatId}
+			}
+		}
 	default:
 		response = types.TelegramMessage{Message: "Operation not known", ChatId: programmedAction.Action.ChatId}
 	}
 	return response, addPreviousAction
 }
+
+func removeProgrammedAction(queue *ordered_queue.OrderedQueue, id string) (types.ProgrammedAction, error) {
+	var removed types.ProgrammedAction
+	var remaining []types.ProgrammedAction
+	found := false
+	for _, element := range queue.GetCurrentElements() {
+		programmedAction := element.(types.ProgrammedAction)
+		if !found && programmedAction.Id == id {
+			removed = programmedAction
+			found = true
+		} else {
+			remaining = append(remaining, programmedAction)
+		}
+	}
+	if !found {
+		return removed, errors.New("Programmed action " + id + " not found")
+	}
+	queue.ClearAllElements()
+	for _, programmedAction := range remaining {
+		queue.Push(programmedAction)
+	}
+	return removed, nil
+}
+
+// Moves repeated programmed actions limited to some weekdays to the next day they run
+func nextRunDay(programmedAction types.ProgrammedAction) types.MyTime {
+	date := time.Time(programmedAction.Time)
+	for programmedAction.Repeat && !programmedAction.RunsOn(date) {
+		date = date.Add(time.Hour * 24)
+	}
+	return types.MyTime(date)
+}
+
+func nextOccurrence(programmedTime types.MyTime) types.MyTime {
+	currTime := time.Time(programmedTime)
+	now := time.Now()
+	date := time.Date(now.Year(), now.Month(), now.Day(), currTime.Hour(), currTime.Minute(), currTime.Second(), 0, now.Location())
+	for date.Before(now) {
+		date = date.Add(24 * time.Hour)
+	}
+	return types.MyTime(date)
+}
+
+func getAgenda(request types.AgendaRequest, queue *ordered_queue.OrderedQueue, nextAction types.ProgrammedAction, nextActionValid bool, now time.Time) types.Agenda {
+	agenda := types.Agenda{RequestId: request.Id}
+	horizon := request.Horizon
+	if horizon == 0 && request.Executions == 0 {
+		horizon = types.DefaultAgendaHorizon
+	}
+	var pending []types.ProgrammedAction
+	if nextActionValid == true {
+		pending = append(pending, nextAction)
+	}
+	for _, element := range queue.GetCurrentElements() {
+		pending = append(pending, element.(types.ProgrammedAction))
+	}
+	for len(pending) > 0 {
+		if request.Executions > 0 && len(agenda.Entries) >= request.Executions {
+			break
+		}
+		earliest := 0
+		for index, programmedAction := range pending {
+			if time.Time(programmedAction.Time).Before(time.Time(pending[earliest].Time)) {
+				earliest = index
+			}
+		}
+		date := time.Time(pending[earliest].Time)
+		if horizon != 0 && date.After(now.Add(horizon)) {
+			break
+		}
+		agenda.Entries = append(agenda.Entries, types.AgendaEntry{ProgrammedAction: pending[earliest], Date: date})
+		if pending[earliest].Repeat == true {
+			pending[earliest].Time = types.MyTime(date.Add(time.Hour * 24))
+			pending[earliest].Time = nextRunDay(pending[earliest])
+		} else {
+			pending = append(pending[:earliest], pending[earliest+1:]...)
+		}
+	}
+	return agenda
+}
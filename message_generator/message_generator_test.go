@@ -1,10 +1,17 @@
 package message_generator
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	ordered_queue "github.com/Alberto-Izquierdo/GoOrderedQueue"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/gpio_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/history_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/sensor_manager"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -20,7 +27,7 @@ func TestActionTwoSecondsDelay(t *testing.T) {
 	exitChan := make(chan bool)
 	telegramChannel := make(chan types.TelegramMessage)
 	programmedActionOperationsChannel := make(chan types.ProgrammedActionOperation)
-	err := Run(programmedActions, programmedActionOperationsChannel, telegramChannel, exitChan)
+	err := Run(programmedActions, types.NodeChannels{ProgrammedActions: programmedActionOperationsChannel, Responses: telegramChannel}, nil, types.VacationConfiguration{}, nil, nil, exitChan)
 	require.Nil(t, err)
 	select {
 	case _ = <-exitChan:
@@ -39,7 +46,7 @@ func TestCreateProgrammedAction(t *testing.T) {
 	exitChan := make(chan bool)
 	telegramChannel := make(chan types.TelegramMessage)
 	programmedActionOperationsChannel := make(chan types.ProgrammedActionOperation)
-	err := Run(programmedActions, programmedActionOperationsChannel, telegramChannel, exitChan)
+	err := Run(programmedActions, types.NodeChannels{ProgrammedActions: programmedActionOperationsChannel, Responses: telegramChannel}, nil, types.VacationConfiguration{}, nil, nil, exitChan)
 	assert.Nil(t, err)
 	actionTime := types.MyTime(time.Now().Add(time.Second * 2))
 	programmedActionOperationsChannel <- types.ProgrammedActionOperation{
@@ -109,3 +116,259 @@ func TestCreateProgrammedAction(t *testing.T) {
 	exitChan <- true
 	time.Sleep(100 * time.Millisecond)
 }
+
+func TestGetAgenda(t *testing.T) {
+	now := time.Now()
+	queue := ordered_queue.OrderedQueue{}
+	queue.Push(types.ProgrammedAction{Action: types.Action{Pin: "light", State: false}, Time: types.MyTime(now.Add(time.Hour * 3)), Repeat: false})
+	nextAction := types.ProgrammedAction{Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(now.Add(time.Hour)), Repeat: true}
+
+	agenda := getAgenda(types.AgendaRequest{Id: 7}, &queue, nextAction, true, now)
+	assert.Equal(t, agenda.RequestId, int64(7))
+	require.Equal(t, len(agenda.Entries), 2, "The default agenda should contain the executions of the next 24 hours")
+	assert.True(t, agenda.Entries[0].ProgrammedAction.Action.State)
+	assert.Equal(t, agenda.Entries[0].Date, time.Time(nextAction.Time))
+	assert.False(t, agenda.Entries[1].ProgrammedAction.Action.State)
+
+	agenda = getAgenda(types.AgendaRequest{Horizon: time.Hour * 48}, &queue, nextAction, true, now)
+	require.Equal(t, len(agenda.Entries), 3, "Repeated actions should appear once per day")
+	assert.Equal(t, agenda.Entries[2].Date, time.Time(nextAction.Time).Add(time.Hour*24))
+
+	agenda = getAgenda(types.AgendaRequest{Executions: 5}, &queue, nextAction, true, now)
+	require.Equal(t, len(agenda.Entries), 5, "The agenda should contain the number of executions requested")
+	for index := 1; index < len(agenda.Entries); index++ {
+		assert.False(t, agenda.Entries[index].Date.Before(agenda.Entries[index-1].Date), "The agenda should be sorted chronologically")
+	}
+
+	agenda = getAgenda(types.AgendaRequest{Horizon: time.Hour * 2}, &queue, nextAction, false, now)
+	assert.Equal(t, len(agenda.Entries), 0, "There should not be executions in the next two hours")
+	assert.Equal(t, queue.Size(), 1, "Building the agenda should not modify the queue")
+}
+
+func TestUpdateProgrammedAction(t *testing.T) {
+	now := time.Now()
+	queue := ordered_queue.OrderedQueue{}
+	previous := types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true, ChatId: 123}, Time: types.MyTime(now.Add(time.Hour * 2)), Repeat: true}
+	updated := types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true, ChatId: 123}, Time: types.MyTime(now.Add(time.Hour * 3)), Repeat: false}
+	nextAction := types.ProgrammedAction{Id: "b", Action: types.Action{Pin: "light", State: false}, Time: types.MyTime(now.Add(time.Hour)), Repeat: true}
+	queue.Push(previous)
+
+	response, addPreviousAction := handleOperation(types.ProgrammedActionOperation{Operation: types.UPDATE, ProgrammedAction: updated}, &queue, nextAction, true)
+	assert.Equal(t, response.Message, "Programmed action updated")
+	assert.Equal(t, response.ChatId, int64(123))
+	assert.True(t, addPreviousAction, "The next action should be kept when it is not the one updated")
+	require.Equal(t, queue.Size(), 1)
+	element, _ := queue.Pop()
+	assert.Equal(t, element.(types.ProgrammedAction).Id, "a")
+	assert.Equal(t, element.(types.ProgrammedAction).Time.Format("15:04:05"), updated.Time.Format("15:04:05"))
+	assert.False(t, element.(types.ProgrammedAction).Repeat)
+
+	updated.Id = "b"
+	response, addPreviousAction = handleOperation(types.ProgrammedActionOperation{Operation: types.UPDATE, ProgrammedAction: updated}, &queue, nextAction, true)
+	assert.Equal(t, response.Message, "Programmed action updated")
+	assert.False(t, addPreviousAction, "The next action should be discarded when it is the one updated")
+	assert.Equal(t, queue.Size(), 1)
+
+	updated.Id = "c"
+	response, _ = handleOperation(types.ProgrammedActionOperation{Operation: types.UPDATE, ProgrammedAction: updated}, &queue, nextAction, false)
+	assert.NotEqual(t, response.Message, "Programmed action updated", "Updating a non existing programmed action should fail")
+	assert.Equal(t, queue.Size(), 1, "A failed update should not modify the queue")
+}
+
+func TestRemoveProgrammedActionById(t *testing.T) {
+	now := time.Now()
+	queue := ordered_queue.OrderedQueue{}
+	queue.Push(types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(now.Add(time.Hour))})
+	queue.Push(types.ProgrammedAction{Id: "b", Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(now.Add(time.Hour)), Repeat: true})
+	queue.Push(types.ProgrammedAction{Id: "c", Action: types.Action{Pin: "light", State: false}, Time: types.MyTime(now.Add(time.Hour * 2))})
+
+	response, _ := handleOperation(types.ProgrammedActionOperation{Operation: types.REMOVE, ProgrammedAction: types.ProgrammedAction{Id: "b"}}, &queue, types.ProgrammedAction{}, false)
+	assert.Equal(t, response.Message, "Programmed action removed")
+	require.Equal(t, queue.Size(), 2, "Actions with the same schedule but different ids should not be removed")
+	for _, element := range queue.GetCurrentElements() {
+		assert.NotEqual(t, element.(types.ProgrammedAction).Id, "b")
+	}
+	response, _ = handleOperation(types.ProgrammedActionOperation{Operation: types.REMOVE, ProgrammedAction: types.ProgrammedAction{Id: "d"}}, &queue, types.ProgrammedAction{}, false)
+	assert.NotEqual(t, response.Message, "Programmed action removed", "Removing a non existing id should fail")
+	assert.Equal(t, queue.Size(), 2)
+}
+
+func TestCheckConditions(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sensors")
+	require.Nil(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "temperature")
+	require.Nil(t, ioutil.WriteFile(path, []byte("17.5"), 0644))
+	require.Nil(t, sensor_manager.Setup([]types.Sensor{types.Sensor{Name: "temperature", Path: path}}))
+	defer sensor_manager.Setup(nil)
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "window", Pin: 3}})
+	defer gpio_manager.ClearAllPins()
+
+	remotePins := map[string]bool{"door": true}
+	remotePinStateGetter := func(pin string) (bool, error) {
+		state, ok := remotePins[pin]
+		if !ok {
+			return false, errors.New("pin not registered")
+		}
+		return state, nil
+	}
+
+	met, _ := checkConditions(nil, nil)
+	assert.True(t, met, "Actions without conditions should always be executed")
+	met, _ = checkConditions([]types.Condition{types.Condition{Pin: "window", State: false}, types.Condition{Sensor: "temperature", Operator: "<", Value: 18}}, nil)
+	assert.True(t, met)
+	met, reason := checkConditions([]types.Condition{types.Condition{Sensor: "temperature", Operator: ">", Value: 18}}, nil)
+	assert.False(t, met)
+	assert.Contains(t, reason, "17.5")
+	met, _ = checkConditions([]types.Condition{types.Condition{Pin: "window", State: true}}, nil)
+	assert.False(t, met)
+	met, _ = checkConditions([]types.Condition{types.Condition{Pin: "door", State: true}}, nil)
+	assert.False(t, met, "Remote pins should not be met without a way of querying them")
+	met, _ = checkConditions([]types.Condition{types.Condition{Pin: "door", State: true}}, remotePinStateGetter)
+	assert.True(t, met)
+	met, _ = checkConditions([]types.Condition{types.Condition{Pin: "garage", State: true}}, remotePinStateGetter)
+	assert.False(t, met, "Remote pins that can not be queried should not be met")
+	met, _ = checkConditions([]types.Condition{types.Condition{Sensor: "humidity", Operator: "<", Value: 50}}, nil)
+	assert.False(t, met, "Sensors not configured should not be met")
+}
+
+func TestTimer(t *testing.T) {
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "light", Pin: 2}})
+	defer gpio_manager.ClearAllPins()
+	gpio_manager.HandleAction(types.Action{Pin: "light", State: true})
+	programmedActionOperationsChannel := make(chan types.ProgrammedActionOperation)
+	telegramChannel := make(chan types.TelegramMessage)
+	exitChan := make(chan bool)
+	err := Run(nil, types.NodeChannels{ProgrammedActions: programmedActionOperationsChannel, Responses: telegramChannel}, nil, types.VacationConfiguration{}, nil, nil, exitChan)
+	assert.Nil(t, err)
+
+	// Timers more than a day away should not be moved to the next occurrence of their time of the day
+	longTimer := types.NewTimer(types.Action{Pin: "light", State: false}, 36*time.Hour)
+	longTimer.Id = "a"
+	programmedActionOperationsChannel <- types.ProgrammedActionOperation{ProgrammedAction: longTimer, Operation: types.CREATE}
+	<-telegramChannel
+	timer := types.NewTimer(types.Action{Pin: "light", State: false}, time.Second)
+	timer.Id = "b"
+	programmedActionOperationsChannel <- types.ProgrammedActionOperation{ProgrammedAction: timer, Operation: types.CREATE}
+	<-telegramChannel
+	time.Sleep(2 * time.Second)
+	assert.False(t, gpio_manager.GetPinState("light"), "The timer should have turned the pin off")
+
+	programmedActionOperationsChannel <- types.ProgrammedActionOperation{ProgrammedAction: types.ProgrammedAction{Id: "a"}, Operation: types.REMOVE}
+	response := <-telegramChannel
+	assert.Equal(t, response.Message, "Programmed action removed", "Timers should be cancellable")
+	exitChan <- true
+}
+
+func TestExecutionHistory(t *testing.T) {
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "light", Pin: 2}})
+	defer gpio_manager.ClearAllPins()
+	history_manager.Setup("", 0)
+	queue := ordered_queue.OrderedQueue{}
+	telegramChannel := make(chan types.TelegramMessage)
+	executionsChannel := make(chan types.Execution)
+
+	programmedAction := types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true, ChatId: 123}, Time: types.MyTime(time.Now()), AlertOnFailure: true}
+	handleNextAction(&programmedAction, &queue, telegramChannel, nil, executionsChannel, nil, nil, nil, nil, nil)
+	execution := <-executionsChannel
+	assert.True(t, execution.Success)
+	assert.Equal(t, execution.ProgrammedActionId, "a")
+
+	programmedAction = types.ProgrammedAction{Id: "b", Action: types.Action{Pin: "water", State: true, ChatId: 123}, Time: types.MyTime(time.Now()), AlertOnFailure: true}
+	handleNextAction(&programmedAction, &queue, telegramChannel, nil, executionsChannel, nil, nil, nil, nil, nil)
+	select {
+	case response := <-telegramChannel:
+		assert.Equal(t, response.ChatId, int64(123), "Failures should be alerted to the creator")
+		assert.Contains(t, response.Message, "failed")
+	case <-time.After(time.Second):
+		t.Errorf("Failures should be alerted when the programmed action asks for it")
+	}
+	execution = <-executionsChannel
+	assert.False(t, execution.Success)
+	assert.NotEqual(t, execution.Error, "")
+
+	executions := history_manager.GetExecutions()
+	require.Equal(t, len(executions), 2, "Every execution should be recorded in the node")
+	assert.Equal(t, executions[1].ProgrammedActionId, "b")
+}
+
+func TestExecutionNotifications(t *testing.T) {
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "light", Pin: 2}})
+	defer gpio_manager.ClearAllPins()
+	queue := ordered_queue.OrderedQueue{}
+	telegramChannel := make(chan types.TelegramMessage)
+
+	programmedAction := types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true, ChatId: 123}, Time: types.MyTime(time.Now()), Notify: true}
+	handleNextAction(&programmedAction, &queue, telegramChannel, nil, nil, nil, nil, nil, nil, nil)
+	select {
+	case response := <-telegramChannel:
+		assert.Equal(t, response.ChatId, int64(123), "The creator should be notified")
+		assert.Contains(t, response.Message, "executed")
+	case <-time.After(time.Second):
+		t.Errorf("Programmed actions with notifications should notify every execution")
+	}
+
+	programmedAction = types.ProgrammedAction{Id: "b", Action: types.Action{Pin: "water", State: true, ChatId: 123}, Time: types.MyTime(time.Now()), Notify: true, NotifyChatId: 456}
+	handleNextAction(&programmedAction, &queue, telegramChannel, nil, nil, nil, nil, nil, nil, nil)
+	select {
+	case response := <-telegramChannel:
+		assert.Equal(t, response.ChatId, int64(456), "The chat configured should be notified")
+		assert.Contains(t, response.Message, "failed")
+	case <-time.After(time.Second):
+		t.Errorf("Programmed actions with notifications should notify failures")
+	}
+
+	programmedAction = types.ProgrammedAction{Id: "c", Action: types.Action{Pin: "light", State: false, ChatId: 123}, Time: types.MyTime(time.Now())}
+	handleNextAction(&programmedAction, &queue, telegramChannel, nil, nil, nil, nil, nil, nil, nil)
+	select {
+	case response := <-telegramChannel:
+		t.Errorf("Programmed actions without notifications should not notify, received \"%s\"", response.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReminder(t *testing.T) {
+	history_manager.Setup("", 0)
+	queue := ordered_queue.OrderedQueue{}
+	telegramChannel := make(chan types.TelegramMessage)
+	executionsChannel := make(chan types.Execution, 1)
+
+	reminder := types.NewReminder("take the bins out", 123, time.Now(), true)
+	reminder.Id = "a"
+	handleNextAction(&reminder, &queue, telegramChannel, nil, executionsChannel, nil, nil, nil, nil, nil)
+	select {
+	case response := <-telegramChannel:
+		assert.Equal(t, response, types.TelegramMessage{Message: "Reminder: take the bins out", ChatId: 123})
+	case <-time.After(time.Second):
+		t.Errorf("Reminders should send their message")
+	}
+	assert.Equal(t, queue.Size(), 1, "Daily reminders should be scheduled again")
+	executions := history_manager.GetExecutions()
+	assert.Equal(t, len(executions), 1)
+	assert.Equal(t, executions[0].Description, "remind \"take the bins out\"")
+	assert.True(t, executions[0].Success)
+	select {
+	case execution := <-executionsChannel:
+		assert.Equal(t, types.ExecutionDescription(execution), "remind \"take the bins out\"")
+	case <-time.After(time.Second):
+		t.Errorf("Reminders should be sent to the server history")
+	}
+}
+
+func TestWeekdays(t *testing.T) {
+	now := time.Date(2020, 5, 3, 12, 0, 0, 0, time.Local)
+	// 2020-05-03 is a sunday
+	nextAction := types.ProgrammedAction{Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(now.Add(time.Hour)), Repeat: true, Weekdays: []time.Weekday{time.Sunday, time.Tuesday}}
+	assert.Equal(t, time.Time(nextRunDay(nextAction)), now.Add(time.Hour))
+	nextAction.Time = types.MyTime(now.Add(25 * time.Hour))
+	assert.Equal(t, time.Time(nextRunDay(nextAction)), now.Add(49*time.Hour), "Days not selected should be skipped")
+	nextAction.Repeat = false
+	assert.Equal(t, time.Time(nextRunDay(nextAction)), now.Add(25*time.Hour), "One-off actions should not be moved")
+
+	nextAction.Repeat = true
+	nextAction.Time = types.MyTime(now.Add(time.Hour))
+	queue := ordered_queue.OrderedQueue{}
+	agenda := getAgenda(types.AgendaRequest{Horizon: 7 * 24 * time.Hour}, &queue, nextAction, true, now)
+	require.Equal(t, len(agenda.Entries), 2, "The agenda should only contain the selected days")
+	assert.Equal(t, agenda.Entries[1].Date, now.Add(49*time.Hour))
+}
@@ -0,0 +1,81 @@
+package message_generator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+type overrideMode struct {
+	configurations map[string]types.OverrideConfiguration
+	// Programmed actions on the pin are skipped until that date
+	until map[string]time.Time
+}
+
+func newOverrideMode(configurations []types.OverrideConfiguration) *overrideMode {
+	overrides := overrideMode{configurations: make(map[string]types.OverrideConfiguration), until: make(map[string]time.Time)}
+	for _, configuration := range configurations {
+		overrides.configurations[configuration.Pin] = configuration
+	}
+	return &overrides
+}
+
+// Manual changes on pins with an override configured suspend their programmed actions
+func (o *overrideMode) handlePinChange(pinChange types.PinChange) {
+	configuration, ok := o.configurations[pinChange.Pin]
+	if !ok || !pinChange.Manual {
+		return
+	}
+	if configuration.UntilTomorrow {
+		year, month, day := pinChange.Date.AddDate(0, 0, 1).Date()
+		o.until[pinChange.Pin] = time.Date(year, month, day, 0, 0, 0, 0, pinChange.Date.Location())
+	} else {
+		o.until[pinChange.Pin] = pinChange.Date.Add(time.Duration(configuration.Minutes) * time.Minute)
+	}
+}
+
+// Returns the reason to skip the programmed action if its pin is overridden. Timers are never skipped,
+// they were requested explicitly (e.g. the "off" of "LightOnAndOff 30m", which overrides the pin with its "on")
+func (o *overrideMode) check(programmedAction types.ProgrammedAction, now time.Time) (bool, string) {
+	if o == nil || programmedAction.Type != types.PIN_ACTION || programmedAction.Timer {
+		return false, ""
+	}
+	until, ok := o.until[programmedAction.Action.Pin]
+	if !ok {
+		return false, ""
+	}
+	if !until.After(now) {
+		delete(o.until, programmedAction.Action.Pin)
+		return false, ""
+	}
+	return true, "manual override until " + until.Format("Mon 02/01 15:04")
+}
+
+func (o *overrideMode) handleRequest(request types.OverrideRequest, now time.Time) types.TelegramMessage {
+	if request.Clear {
+		for pin := range o.until {
+			if request.Pin == "" || request.Pin == pin {
+				delete(o.until, pin)
+			}
+		}
+	}
+	var pins []string
+	for pin, until := range o.until {
+		if until.After(now) {
+			pins = append(pins, pin)
+		}
+	}
+	if len(pins) == 0 {
+		if request.Clear {
+			return types.TelegramMessage{Message: "Overrides cleared", ChatId: request.ChatId}
+		}
+		return types.TelegramMessage{Message: "There are not any overrides active", ChatId: request.ChatId}
+	}
+	sort.Strings(pins)
+	message := "Overrides active:"
+	for _, pin := range pins {
+		message += "\n" + pin + " until " + o.until[pin].Format("Mon 02/01 15:04")
+	}
+	return types.TelegramMessage{Message: message, ChatId: request.ChatId}
+}
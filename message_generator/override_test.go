@@ -0,0 +1,104 @@
+package message_generator
+
+import (
+	"testing"
+	"time"
+
+	ordered_queue "github.com/Alberto-Izquierdo/GoOrderedQueue"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/gpio_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/history_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverridePinChanges(t *testing.T) {
+	overrides := newOverrideMode([]types.OverrideConfiguration{
+		types.OverrideConfiguration{Pin: "light", Minutes: 90},
+		types.OverrideConfiguration{Pin: "heater", UntilTomorrow: true},
+	})
+	now := time.Date(2020, 5, 3, 19, 0, 0, 0, time.Local)
+	light := types.ProgrammedAction{Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(now.Add(30 * time.Minute))}
+	heater := types.ProgrammedAction{Action: types.Action{Pin: "heater", State: true}}
+	water := types.ProgrammedAction{Action: types.Action{Pin: "water", State: true}}
+
+	overrides.handlePinChange(types.PinChange{Pin: "light", State: false, Date: now})
+	overridden, _ := overrides.check(light, now.Add(30*time.Minute))
+	assert.False(t, overridden, "Changes made by programmed actions should not override them")
+
+	overrides.handlePinChange(types.PinChange{Pin: "light", State: false, Date: now, Manual: true})
+	overrides.handlePinChange(types.PinChange{Pin: "heater", State: false, Date: now, Manual: true})
+	overrides.handlePinChange(types.PinChange{Pin: "water", State: false, Date: now, Manual: true})
+	overridden, reason := overrides.check(light, now.Add(30*time.Minute))
+	assert.True(t, overridden, "Manual changes should suspend the programmed actions of the pin")
+	assert.Equal(t, reason, "manual override until Sun 03/05 20:30")
+	overridden, _ = overrides.check(light, now.Add(90*time.Minute))
+	assert.False(t, overridden, "Overrides should finish after the configured minutes")
+	overridden, _ = overrides.check(heater, now.Add(4*time.Hour+59*time.Minute))
+	assert.True(t, overridden)
+	overridden, _ = overrides.check(heater, now.Add(5*time.Hour))
+	assert.False(t, overridden, "Overrides until tomorrow should finish at midnight")
+	overridden, _ = overrides.check(water, now.Add(time.Minute))
+	assert.False(t, overridden, "Pins without override configured should not be overridden")
+	reminder := types.NewReminder("take the bins out", 1, now, false)
+	overridden, _ = overrides.check(reminder, now)
+	assert.False(t, overridden)
+}
+
+func TestOverrideRequest(t *testing.T) {
+	overrides := newOverrideMode([]types.OverrideConfiguration{
+		types.OverrideConfiguration{Pin: "light", Minutes: 90},
+		types.OverrideConfiguration{Pin: "heater", Minutes: 30},
+	})
+	now := time.Date(2020, 5, 3, 19, 0, 0, 0, time.Local)
+	response := overrides.handleRequest(types.OverrideRequest{ChatId: 1}, now)
+	assert.Equal(t, response, types.TelegramMessage{Message: "There are not any overrides active", ChatId: 1})
+
+	overrides.handlePinChange(types.PinChange{Pin: "light", Date: now, Manual: true})
+	overrides.handlePinChange(types.PinChange{Pin: "heater", Date: now, Manual: true})
+	response = overrides.handleRequest(types.OverrideRequest{ChatId: 1}, now)
+	assert.Equal(t, response.Message, "Overrides active:\nheater until Sun 03/05 19:30\nlight until Sun 03/05 20:30")
+	response = overrides.handleRequest(types.OverrideRequest{Clear: true, Pin: "light", ChatId: 1}, now)
+	assert.Equal(t, response.Message, "Overrides active:\nheater until Sun 03/05 19:30", "Only the override of the pin should be cleared")
+	response = overrides.handleRequest(types.OverrideRequest{Clear: true, ChatId: 1}, now)
+	assert.Equal(t, response.Message, "Overrides cleared")
+}
+
+func TestOverriddenProgrammedActionIsSkipped(t *testing.T) {
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "light", Pin: 2}})
+	defer gpio_manager.ClearAllPins()
+	history_manager.Setup("", 0)
+	queue := ordered_queue.OrderedQueue{}
+	overrides := newOverrideMode([]types.OverrideConfiguration{types.OverrideConfiguration{Pin: "light", Minutes: 60}})
+	overrides.handlePinChange(types.PinChange{Pin: "light", Date: time.Now(), Manual: true})
+
+	programmedAction := types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(time.Now())}
+	handleNextAction(&programmedAction, &queue, nil, nil, nil, nil, nil, overrides, nil, nil)
+	assert.False(t, gpio_manager.GetPinState("light"), "Overridden programmed actions should not change the pin")
+	executions := history_manager.GetExecutions()
+	require.Equal(t, len(executions), 1)
+	assert.True(t, executions[0].Skipped)
+	assert.Contains(t, executions[0].Error, "manual override")
+}
+
+func TestTimersAreNotOverridden(t *testing.T) {
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "light", Pin: 2}})
+	defer gpio_manager.ClearAllPins()
+	history_manager.Setup("", 0)
+	queue := ordered_queue.OrderedQueue{}
+	overrides := newOverrideMode([]types.OverrideConfiguration{types.OverrideConfiguration{Pin: "light", Minutes: 60}})
+	gpio_manager.SetPinChangesListener(overrides.handlePinChange)
+	defer gpio_manager.SetPinChangesListener(nil)
+
+	// "lightOnAndOff 30m" turns the pin on manually and programs a timer to turn it off
+	_, err := gpio_manager.HandleManualAction(types.Action{Pin: "light", State: true})
+	require.Nil(t, err)
+	overridden, _ := overrides.check(types.ProgrammedAction{Action: types.Action{Pin: "light", State: false}}, time.Now())
+	require.True(t, overridden, "The manual change should override the pin")
+	timer := types.NewTimer(types.Action{Pin: "light", State: false}, 0)
+	handleNextAction(&timer, &queue, nil, nil, nil, nil, nil, overrides, nil, nil)
+	assert.False(t, gpio_manager.GetPinState("light"), "Timers should run even if the pin is overridden")
+	executions := history_manager.GetExecutions()
+	require.Equal(t, len(executions), 1)
+	assert.False(t, executions[0].Skipped)
+}
@@ -0,0 +1,88 @@
+package message_generator
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	ordered_queue "github.com/Alberto-Izquierdo/GoOrderedQueue"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+const replayIdPrefix string = "replay-"
+const replayDays int = 7
+
+type replayMode struct {
+	enabled bool
+	lastId  int
+}
+
+// Schedules the manual pin changes recorded in the week starting at from on the same day of the week and time
+func (r *replayMode) buildReplay(pinChanges []types.PinChange, from time.Time, pins []string, now time.Time) []types.ProgrammedAction {
+	var replay []types.ProgrammedAction
+	to := from.AddDate(0, 0, replayDays)
+	for _, pinChange := range pinChanges {
+		if !pinChange.Manual || pinChange.Date.Before(from) || !pinChange.Date.Before(to) {
+			continue
+		}
+		found := false
+		for _, pin := range pins {
+			found = found || pin == pinChange.Pin
+		}
+		if !found {
+			continue
+		}
+		date := pinChange.Date
+		for !date.After(now) {
+			date = date.AddDate(0, 0, replayDays)
+		}
+		r.lastId++
+		replay = append(replay, types.ProgrammedAction{
+			Id:     replayIdPrefix + strconv.Itoa(r.lastId),
+			Action: types.Action{Pin: pinChange.Pin, State: pinChange.State},
+			Time:   types.MyTime(date),
+			Timer:  true,
+		})
+	}
+	return replay
+}
+
+func (r *replayMode) handleRequest(request types.ReplayRequest, pinChanges []types.PinChange, pins []string, queue *ordered_queue.OrderedQueue, now time.Time) types.TelegramMessage {
+	var remaining []types.ProgrammedAction
+	for _, element := range queue.GetCurrentElements() {
+		programmedAction := element.(types.ProgrammedAction)
+		if !strings.HasPrefix(programmedAction.Id, replayIdPrefix) {
+			remaining = append(remaining, programmedAction)
+		}
+	}
+	r.enabled = false
+	response := types.TelegramMessage{Message: "Replay disabled", ChatId: request.ChatId}
+	if request.Enabled {
+		from := request.From
+		if from.IsZero() {
+			from = now.AddDate(0, 0, -replayDays)
+		}
+		replay := r.buildReplay(pinChanges, from, pins, now)
+		if len(replay) == 0 {
+			response.Message = "There are not any manual pin changes recorded in the week starting " + from.Format("Mon 02/01") + ", replay not enabled"
+		} else {
+			r.enabled = true
+			remaining = append(remaining, replay...)
+			response.Message = "Replaying " + strconv.Itoa(len(replay)) + " manual pin changes from the week starting " + from.Format("Mon 02/01") + " every week"
+		}
+	}
+	queue.ClearAllElements()
+	for _, programmedAction := range remaining {
+		queue.Push(programmedAction)
+	}
+	return response
+}
+
+// Replayed pin changes are repeated every week until the replay is disabled
+func (r *replayMode) reschedule(programmedAction types.ProgrammedAction, queue *ordered_queue.OrderedQueue) error {
+	if r == nil || !r.enabled || !strings.HasPrefix(programmedAction.Id, replayIdPrefix) {
+		return nil
+	}
+	programmedAction.Time = types.MyTime(time.Time(programmedAction.Time).AddDate(0, 0, replayDays))
+	return queue.Push(programmedAction)
+}
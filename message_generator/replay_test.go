@@ -0,0 +1,75 @@
+package message_generator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ordered_queue "github.com/Alberto-Izquierdo/GoOrderedQueue"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildReplay(t *testing.T) {
+	from := time.Date(2020, 5, 4, 0, 0, 0, 0, time.Local)
+	now := time.Date(2020, 5, 13, 12, 0, 0, 0, time.Local)
+	pinChanges := []types.PinChange{
+		types.PinChange{Pin: "light", State: true, Date: from.Add(-time.Hour), Manual: true},
+		types.PinChange{Pin: "light", State: true, Date: from.Add(20 * time.Hour), Manual: true},
+		types.PinChange{Pin: "light", State: false, Date: from.Add(21 * time.Hour), Manual: false},
+		types.PinChange{Pin: "remote", State: true, Date: from.Add(22 * time.Hour), Manual: true},
+		types.PinChange{Pin: "light", State: false, Date: from.AddDate(0, 0, 3).Add(23 * time.Hour), Manual: true},
+		types.PinChange{Pin: "light", State: true, Date: from.AddDate(0, 0, 7), Manual: true},
+	}
+	replay := &replayMode{}
+	actions := replay.buildReplay(pinChanges, from, []string{"light"}, now)
+	require.Equal(t, len(actions), 2, "Only the manual changes of the local pins in that week should be replayed")
+	for _, action := range actions {
+		assert.True(t, action.Timer)
+		assert.True(t, strings.HasPrefix(action.Id, replayIdPrefix))
+		assert.True(t, time.Time(action.Time).After(now), "Replayed changes should be scheduled in the future")
+	}
+	assert.Equal(t, time.Time(actions[0].Time), from.AddDate(0, 0, 14).Add(20*time.Hour), "Changes should be replayed on the same day of the week")
+	assert.True(t, actions[0].Action.State)
+	assert.Equal(t, time.Time(actions[1].Time), from.AddDate(0, 0, 7).AddDate(0, 0, 3).Add(23*time.Hour))
+	assert.False(t, actions[1].Action.State)
+	assert.NotEqual(t, actions[0].Id, actions[1].Id)
+}
+
+func TestReplayRequest(t *testing.T) {
+	now := time.Now()
+	queue := ordered_queue.OrderedQueue{}
+	queue.Push(types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(now.Add(time.Hour)), Repeat: true})
+	pinChanges := []types.PinChange{types.PinChange{Pin: "light", State: true, Date: now.AddDate(0, 0, -2), Manual: true}}
+	replay := &replayMode{}
+
+	response := replay.handleRequest(types.ReplayRequest{Enabled: true, From: now.AddDate(0, 0, -30), ChatId: 123}, pinChanges, []string{"light"}, &queue, now)
+	assert.Equal(t, response.ChatId, int64(123))
+	assert.True(t, strings.HasPrefix(response.Message, "There are not any manual pin changes"), "Weeks without manual changes should not be replayed")
+	assert.False(t, replay.enabled)
+	assert.Equal(t, queue.Size(), 1)
+
+	response = replay.handleRequest(types.ReplayRequest{Enabled: true, ChatId: 123}, pinChanges, []string{"light"}, &queue, now)
+	assert.True(t, strings.HasPrefix(response.Message, "Replaying 1 manual pin changes"), "By default the last week should be replayed")
+	assert.True(t, replay.enabled)
+	require.Equal(t, queue.Size(), 2)
+	var replayed types.ProgrammedAction
+	for _, element := range queue.GetCurrentElements() {
+		if programmedAction := element.(types.ProgrammedAction); programmedAction.Id != "a" {
+			replayed = programmedAction
+		}
+	}
+	assert.Equal(t, time.Time(replayed.Time), now.AddDate(0, 0, 5))
+
+	replay.reschedule(replayed, &queue)
+	assert.Equal(t, queue.Size(), 3, "Replayed changes should be repeated the next week")
+	replay.reschedule(types.ProgrammedAction{Id: "a"}, &queue)
+	assert.Equal(t, queue.Size(), 3, "Only replayed changes should be rescheduled")
+
+	response = replay.handleRequest(types.ReplayRequest{Enabled: false, ChatId: 123}, pinChanges, []string{"light"}, &queue, now)
+	assert.Equal(t, response.Message, "Replay disabled")
+	assert.Equal(t, queue.Size(), 1, "Disabling the replay should remove the replayed changes")
+	replay.reschedule(replayed, &queue)
+	assert.Equal(t, queue.Size(), 1, "Replayed changes should not be rescheduled once the replay is disabled")
+}
@@ -0,0 +1,175 @@
+package message_generator
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/gpio_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+type sequenceRun struct {
+	sequence types.Sequence
+	step     int
+	// The pin of the step is on, otherwise it is waiting for the gap before the step
+	running bool
+	next    time.Time
+	chatId  int64
+}
+
+// Only one sequence runs at a time, the rest wait for it to finish
+type sequenceRunner struct {
+	sequences map[string]types.Sequence
+	current   *sequenceRun
+	pending   []*sequenceRun
+	// Programmed sequences are skipped until that date
+	rainDelay time.Time
+}
+
+func newSequenceRunner(sequences []types.Sequence) *sequenceRunner {
+	runner := sequenceRunner{sequences: make(map[string]types.Sequence)}
+	for _, sequence := range sequences {
+		runner.sequences[sequence.Name] = sequence
+	}
+	return &runner
+}
+
+// Nil (it blocks forever) when there is not any sequence running
+func (r *sequenceRunner) timerChannel(now time.Time) <-chan time.Time {
+	if r == nil || r.current == nil {
+		return nil
+	}
+	return time.After(r.current.next.Sub(now))
+}
+
+// Returns the reason to skip a programmed sequence while the rain delay is active
+func (r *sequenceRunner) checkRainDelay(now time.Time) (bool, string) {
+	if r == nil || !r.rainDelay.After(now) {
+		return false, ""
+	}
+	return true, "rain delay until " + r.rainDelay.Format("Mon 02/01 15:04")
+}
+
+func (r *sequenceRunner) start(name string, chatId int64, now time.Time) []types.TelegramMessage {
+	sequence, ok := r.sequences[name]
+	if !ok {
+		return []types.TelegramMessage{types.TelegramMessage{Message: "Sequence " + name + " not found", ChatId: chatId}}
+	}
+	run := &sequenceRun{sequence: sequence, next: now, chatId: chatId}
+	if r.current != nil {
+		r.pending = append(r.pending, run)
+		return []types.TelegramMessage{types.TelegramMessage{Message: "Sequence " + name + " will start after " + r.current.sequence.Name, ChatId: chatId}}
+	}
+	r.current = run
+	return r.advance(now)
+}
+
+// Runs every step due, returns the progress messages
+func (r *sequenceRunner) advance(now time.Time) []types.TelegramMessage {
+	var messages []types.TelegramMessage
+	for r.current != nil && !r.current.next.After(now) {
+		run := r.current
+		step := run.sequence.Steps[run.step]
+		progress := "Sequence " + run.sequence.Name + " (" + strconv.Itoa(run.step+1) + "/" + strconv.Itoa(len(run.sequence.Steps)) + "): "
+		if run.running {
+			run.running = false
+			_, err := gpio_manager.HandleAction(types.Action{Pin: step.Pin, State: false})
+			if err != nil {
+				messages = append(messages, types.TelegramMessage{Message: progress + "could not turn " + step.Pin + " off: " + err.Error(), ChatId: run.chatId})
+			}
+			run.step++
+			run.next = now.Add(time.Duration(step.GapMinutes) * time.Minute)
+			if run.step == len(run.sequence.Steps) {
+				messages = append(messages, types.TelegramMessage{Message: "Sequence " + run.sequence.Name + " finished", ChatId: run.chatId})
+				r.startPending(now)
+			}
+			continue
+		}
+		run.running = true
+		duration := time.Duration(step.Minutes) * time.Minute
+		run.next = now.Add(duration)
+		_, err := gpio_manager.HandleAction(types.Action{Pin: step.Pin, State: true})
+		if err != nil {
+			messages = append(messages, types.TelegramMessage{Message: progress + "could not turn " + step.Pin + " on: " + err.Error(), ChatId: run.chatId})
+			// Go on with the next step
+			run.next = now
+		} else {
+			messages = append(messages, types.TelegramMessage{Message: progress + step.Pin + " on for " + duration.String(), ChatId: run.chatId})
+		}
+	}
+	return messages
+}
+
+func (r *sequenceRunner) startPending(now time.Time) {
+	r.current = nil
+	if len(r.pending) > 0 {
+		r.current = r.pending[0]
+		r.current.next = now
+		r.pending = r.pending[1:]
+	}
+}
+
+func (r *sequenceRunner) handleRequest(request types.SequenceRequest, now time.Time) []types.TelegramMessage {
+	response := types.TelegramMessage{ChatId: request.ChatId}
+	switch request.Operation {
+	case types.SEQUENCE_RUN:
+		return r.start(request.Name, request.ChatId, now)
+	case types.SEQUENCE_SKIP:
+		if r.current == nil {
+			response.Message = "There are not any sequences running"
+			break
+		}
+		// Finish the current step (or the wait before it) now
+		response.Message = "Step " + strconv.Itoa(r.current.step+1) + " of sequence " + r.current.sequence.Name + " skipped"
+		if !r.current.running {
+			response.Message = "Waiting before step " + strconv.Itoa(r.current.step+1) + " of sequence " + r.current.sequence.Name + " skipped"
+		}
+		r.current.next = now
+		return append([]types.TelegramMessage{response}, r.advance(now)...)
+	case types.SEQUENCE_ABORT:
+		if r.current == nil {
+			response.Message = "There are not any sequences running"
+			break
+		}
+		if r.current.running {
+			gpio_manager.HandleAction(types.Action{Pin: r.current.sequence.Steps[r.current.step].Pin, State: false})
+		}
+		response.Message = "Sequence " + r.current.sequence.Name + " aborted"
+		if len(r.pending) > 0 {
+			response.Message += " (" + strconv.Itoa(len(r.pending)) + " waiting sequence(s) cancelled)"
+		}
+		r.current = nil
+		r.pending = nil
+	case types.SEQUENCE_RAIN_DELAY:
+		if request.Days <= 0 {
+			r.rainDelay = time.Time{}
+			response.Message = "Rain delay cancelled"
+		} else {
+			r.rainDelay = now.AddDate(0, 0, request.Days)
+			response.Message = "Programmed sequences postponed until " + r.rainDelay.Format("Mon 02/01 15:04")
+		}
+	default:
+		response.Message = r.status(now)
+	}
+	return []types.TelegramMessage{response}
+}
+
+func (r *sequenceRunner) status(now time.Time) string {
+	message := "There are not any sequences running"
+	if r.current != nil {
+		run := r.current
+		message = "Sequence " + run.sequence.Name + " (" + strconv.Itoa(run.step+1) + "/" + strconv.Itoa(len(run.sequence.Steps)) + "): "
+		if run.running {
+			message += run.sequence.Steps[run.step].Pin + " on, " + run.next.Sub(now).Round(time.Second).String() + " left"
+		} else {
+			message += run.sequence.Steps[run.step].Pin + " starts in " + run.next.Sub(now).Round(time.Second).String()
+		}
+	}
+	for _, run := range r.pending {
+		message += "\nSequence " + run.sequence.Name + " waiting"
+	}
+	if r.rainDelay.After(now) {
+		message += "\nRain delay until " + r.rainDelay.Format("Mon 02/01 15:04")
+	}
+	return message
+}
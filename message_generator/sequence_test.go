@@ -0,0 +1,113 @@
+package message_generator
+
+import (
+	"testing"
+	"time"
+
+	ordered_queue "github.com/Alberto-Izquierdo/GoOrderedQueue"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/gpio_manager"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSequences() []types.Sequence {
+	return []types.Sequence{
+		types.Sequence{Name: "garden", Steps: []types.SequenceStep{
+			types.SequenceStep{Pin: "zone1", Minutes: 10, GapMinutes: 2},
+			types.SequenceStep{Pin: "zone2", Minutes: 15},
+		}},
+		types.Sequence{Name: "lawn", Steps: []types.SequenceStep{
+			types.SequenceStep{Pin: "zone2", Minutes: 5},
+		}},
+	}
+}
+
+func TestSequenceRun(t *testing.T) {
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "zone1", Pin: 2}, types.PairNamePin{Name: "zone2", Pin: 3}})
+	defer gpio_manager.ClearAllPins()
+	runner := newSequenceRunner(testSequences())
+	now := time.Date(2020, 5, 3, 6, 0, 0, 0, time.Local)
+	assert.Nil(t, runner.timerChannel(now), "The timer should block while there are not any sequences running")
+
+	messages := runner.start("orchard", 1, now)
+	assert.Equal(t, messages, []types.TelegramMessage{types.TelegramMessage{Message: "Sequence orchard not found", ChatId: 1}})
+
+	messages = runner.start("garden", 1, now)
+	assert.Equal(t, messages, []types.TelegramMessage{types.TelegramMessage{Message: "Sequence garden (1/2): zone1 on for 10m0s", ChatId: 1}})
+	assert.True(t, gpio_manager.GetPinState("zone1"))
+	assert.NotNil(t, runner.timerChannel(now))
+	messages = runner.start("lawn", 1, now)
+	assert.Equal(t, messages[0].Message, "Sequence lawn will start after garden")
+	assert.Equal(t, runner.status(now.Add(time.Minute)), "Sequence garden (1/2): zone1 on, 9m0s left\nSequence lawn waiting")
+
+	assert.Equal(t, len(runner.advance(now.Add(5*time.Minute))), 0, "Nothing should change before the step finishes")
+	messages = runner.advance(now.Add(10 * time.Minute))
+	assert.Equal(t, len(messages), 0)
+	assert.False(t, gpio_manager.GetPinState("zone1"))
+	assert.False(t, gpio_manager.GetPinState("zone2"), "The next step should wait for the gap")
+	assert.Equal(t, runner.status(now.Add(11*time.Minute)), "Sequence garden (2/2): zone2 starts in 1m0s\nSequence lawn waiting")
+
+	messages = runner.advance(now.Add(12 * time.Minute))
+	assert.Equal(t, messages[0].Message, "Sequence garden (2/2): zone2 on for 15m0s")
+	assert.True(t, gpio_manager.GetPinState("zone2"))
+
+	messages = runner.advance(now.Add(27 * time.Minute))
+	require.Equal(t, len(messages), 2)
+	assert.Equal(t, messages[0].Message, "Sequence garden finished")
+	assert.Equal(t, messages[1].Message, "Sequence lawn (1/1): zone2 on for 5m0s", "Waiting sequences should start when the current one finishes")
+	assert.True(t, gpio_manager.GetPinState("zone2"))
+
+	messages = runner.advance(now.Add(32 * time.Minute))
+	assert.Equal(t, messages[0].Message, "Sequence lawn finished")
+	assert.False(t, gpio_manager.GetPinState("zone2"))
+	assert.Nil(t, runner.timerChannel(now))
+	assert.Equal(t, runner.status(now), "There are not any sequences running")
+}
+
+func TestSequenceSkipAndAbort(t *testing.T) {
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "zone1", Pin: 2}, types.PairNamePin{Name: "zone2", Pin: 3}})
+	defer gpio_manager.ClearAllPins()
+	runner := newSequenceRunner(testSequences())
+	now := time.Date(2020, 5, 3, 6, 0, 0, 0, time.Local)
+
+	messages := runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_SKIP, ChatId: 1}, now)
+	assert.Equal(t, messages[0].Message, "There are not any sequences running")
+
+	runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_RUN, Name: "garden", ChatId: 1}, now)
+	messages = runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_SKIP, ChatId: 1}, now.Add(time.Minute))
+	assert.Equal(t, len(messages), 1)
+	assert.Equal(t, messages[0].Message, "Step 1 of sequence garden skipped")
+	assert.False(t, gpio_manager.GetPinState("zone1"))
+	messages = runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_SKIP, ChatId: 1}, now.Add(2*time.Minute))
+	require.Equal(t, len(messages), 2)
+	assert.Equal(t, messages[0].Message, "Waiting before step 2 of sequence garden skipped")
+	assert.Equal(t, messages[1].Message, "Sequence garden (2/2): zone2 on for 15m0s")
+
+	runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_RUN, Name: "lawn", ChatId: 1}, now)
+	messages = runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_ABORT, ChatId: 1}, now.Add(3*time.Minute))
+	assert.Equal(t, messages[0].Message, "Sequence garden aborted (1 waiting sequence(s) cancelled)")
+	assert.False(t, gpio_manager.GetPinState("zone2"), "Aborting a sequence should turn its pin off")
+	assert.Nil(t, runner.timerChannel(now))
+}
+
+func TestSequenceRainDelay(t *testing.T) {
+	gpio_manager.Setup([]types.PairNamePin{types.PairNamePin{Name: "zone1", Pin: 2}, types.PairNamePin{Name: "zone2", Pin: 3}})
+	defer gpio_manager.ClearAllPins()
+	runner := newSequenceRunner(testSequences())
+	now := time.Now()
+	messages := runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_RAIN_DELAY, Days: 2, ChatId: 1}, now)
+	assert.Contains(t, messages[0].Message, "Programmed sequences postponed until")
+	assert.Contains(t, runner.status(now), "Rain delay until")
+
+	queue := ordered_queue.OrderedQueue{}
+	programmedAction := types.ProgrammedAction{Id: "a", Type: types.SEQUENCE_ACTION, Sequence: "garden", Time: types.MyTime(now)}
+	handleNextAction(&programmedAction, &queue, nil, nil, nil, nil, nil, nil, runner, nil)
+	assert.False(t, gpio_manager.GetPinState("zone1"), "Programmed sequences should be skipped during the rain delay")
+	assert.Nil(t, runner.timerChannel(now))
+
+	messages = runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_RAIN_DELAY, ChatId: 1}, now)
+	assert.Equal(t, messages[0].Message, "Rain delay cancelled")
+	messages = runner.handleRequest(types.SequenceRequest{Operation: types.SEQUENCE_RUN, Name: "garden", ChatId: 1}, now)
+	assert.Equal(t, messages[0].Message, "Sequence garden (1/2): zone1 on for 10m0s", "Sequences run manually should not be affected by the rain delay")
+}
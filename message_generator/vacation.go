@@ -0,0 +1,179 @@
+package message_generator
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	ordered_queue "github.com/Alberto-Izquierdo/GoOrderedQueue"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+const defaultVacationJitter time.Duration = 15 * time.Minute
+const defaultVacationEpisodes int = 2
+const defaultVacationEpisodeMaxDuration time.Duration = 30 * time.Minute
+const vacationEpisodeIdPrefix string = "vacation-"
+
+type vacationMode struct {
+	enabled            bool
+	jitter             time.Duration
+	episodePins        []string
+	episodes           int
+	episodeMaxDuration time.Duration
+	eveningStart       time.Time
+	eveningEnd         time.Time
+	random             *rand.Rand
+	nextPlanning       time.Time
+	lastEpisodeId      int
+}
+
+func newVacationMode(configuration types.VacationConfiguration) *vacationMode {
+	vacation := vacationMode{
+		jitter:             time.Duration(configuration.JitterMinutes) * time.Minute,
+		episodePins:        configuration.EpisodePins,
+		episodes:           configuration.Episodes,
+		episodeMaxDuration: time.Duration(configuration.EpisodeMaxMinutes) * time.Minute,
+		eveningStart:       time.Time(configuration.EveningStart),
+		eveningEnd:         time.Time(configuration.EveningEnd),
+	}
+	if vacation.jitter == 0 {
+		vacation.jitter = defaultVacationJitter
+	}
+	if vacation.episodes == 0 {
+		vacation.episodes = defaultVacationEpisodes
+	}
+	if vacation.episodeMaxDuration == 0 {
+		vacation.episodeMaxDuration = defaultVacationEpisodeMaxDuration
+	}
+	if vacation.eveningStart.IsZero() && vacation.eveningEnd.IsZero() {
+		vacation.eveningStart = time.Date(0, 1, 1, 19, 0, 0, 0, time.UTC)
+		vacation.eveningEnd = time.Date(0, 1, 1, 23, 0, 0, 0, time.UTC)
+	}
+	seed := configuration.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	vacation.random = rand.New(rand.NewSource(seed))
+	return &vacation
+}
+
+// Returns the programmed action with a new random jitter (or without jitter if the vacation mode is disabled)
+func (v *vacationMode) applyJitter(programmedAction types.ProgrammedAction, now time.Time) types.ProgrammedAction {
+	if v == nil || !programmedAction.Vacation {
+		return programmedAction
+	}
+	nominal := time.Time(programmedAction.Time).Add(-programmedAction.Jitter)
+	jitter := time.Duration(0)
+	if v.enabled {
+		jitter = (time.Duration(v.random.Int63n(int64(2*v.jitter)+1)) - v.jitter).Round(time.Second)
+		if nominal.Add(jitter).Before(now) {
+			jitter = 0
+		}
+	}
+	if nominal.Add(jitter).Before(now) {
+		// Its programmed time passed while it was moved later, it would run at once otherwise
+		return programmedAction
+	}
+	programmedAction.Jitter = jitter
+	programmedAction.Time = types.MyTime(nominal.Add(jitter))
+	return programmedAction
+}
+
+// Returns the evening window that has not finished yet
+func (v *vacationMode) nextEvening(now time.Time) (start time.Time, end time.Time) {
+	start = time.Date(now.Year(), now.Month(), now.Day(), v.eveningStart.Hour(), v.eveningStart.Minute(), v.eveningStart.Second(), 0, now.Location())
+	end = time.Date(now.Year(), now.Month(), now.Day(), v.eveningEnd.Hour(), v.eveningEnd.Minute(), v.eveningEnd.Second(), 0, now.Location())
+	if !end.After(start) {
+		end = end.Add(24 * time.Hour)
+	}
+	// The window that started yesterday could still be running
+	if start.Add(-24*time.Hour).Before(now) && end.Add(-24*time.Hour).After(now) {
+		return start.Add(-24 * time.Hour), end.Add(-24 * time.Hour)
+	}
+	if !end.After(now) {
+		start = start.Add(24 * time.Hour)
+		end = end.Add(24 * time.Hour)
+	}
+	return start, end
+}
+
+// Random on and off timers in the next evening window
+func (v *vacationMode) planEpisodes(now time.Time) []types.ProgrammedAction {
+	var episodes []types.ProgrammedAction
+	start, end := v.nextEvening(now)
+	v.nextPlanning = end
+	if start.Before(now) {
+		start = now
+	}
+	if len(v.episodePins) == 0 {
+		return episodes
+	}
+	for index := 0; index < v.episodes; index++ {
+		duration := time.Minute + time.Duration(v.random.Int63n(int64(v.episodeMaxDuration-time.Minute)+1)).Round(time.Second)
+		if end.Sub(start) <= duration {
+			break
+		}
+		episodeStart := start.Add(time.Duration(v.random.Int63n(int64(end.Sub(start) - duration)))).Round(time.Second)
+		pin := v.episodePins[v.random.Intn(len(v.episodePins))]
+		v.lastEpisodeId++
+		// Programmed actions are identified by their id, so the on and the off of the episode need their own
+		id := vacationEpisodeIdPrefix + strconv.Itoa(v.lastEpisodeId)
+		episodes = append(episodes,
+			types.ProgrammedAction{Id: id + "-on", Action: types.Action{Pin: pin, State: true}, Time: types.MyTime(episodeStart), Timer: true},
+			types.ProgrammedAction{Id: id + "-off", Action: types.Action{Pin: pin, State: false}, Time: types.MyTime(episodeStart.Add(duration)), Timer: true},
+		)
+	}
+	return episodes
+}
+
+// Nil (it blocks forever) when there is nothing to plan
+func (v *vacationMode) planningChannel(now time.Time) <-chan time.Time {
+	if v == nil || !v.enabled || len(v.episodePins) == 0 {
+		return nil
+	}
+	return time.After(v.nextPlanning.Sub(now))
+}
+
+func (v *vacationMode) handleRequest(request types.VacationModeRequest, queue *ordered_queue.OrderedQueue, now time.Time) types.TelegramMessage {
+	v.enabled = request.Enabled
+	elements := queue.GetCurrentElements()
+	// Episodes whose on is still in the queue have not started
+	pendingEpisodes := make(map[string]bool)
+	for _, element := range elements {
+		if id := element.(types.ProgrammedAction).Id; strings.HasPrefix(id, vacationEpisodeIdPrefix) && strings.HasSuffix(id, "-on") {
+			pendingEpisodes[strings.TrimSuffix(id, "-on")] = true
+		}
+	}
+	var remaining []types.ProgrammedAction
+	for _, element := range elements {
+		programmedAction := element.(types.ProgrammedAction)
+		if !strings.HasPrefix(programmedAction.Id, vacationEpisodeIdPrefix) {
+			remaining = append(remaining, v.applyJitter(programmedAction, now))
+		} else if strings.HasSuffix(programmedAction.Id, "-off") && !pendingEpisodes[strings.TrimSuffix(programmedAction.Id, "-off")] {
+			// The episode already turned its pin on, it would stay on otherwise
+			remaining = append(remaining, programmedAction)
+		}
+	}
+	if v.enabled {
+		remaining = append(remaining, v.planEpisodes(now)...)
+	}
+	queue.ClearAllElements()
+	for _, programmedAction := range remaining {
+		queue.Push(programmedAction)
+	}
+	if !v.enabled {
+		return types.TelegramMessage{Message: "Vacation mode disabled", ChatId: request.ChatId}
+	}
+	message := "Vacation mode enabled (programmed actions moved up to " + v.jitter.String()
+	if len(v.episodePins) > 0 {
+		message += ", " + strconv.Itoa(v.episodes) + " random episodes every evening with " + strings.Join(v.episodePins, ", ")
+	}
+	return types.TelegramMessage{Message: message + ")", ChatId: request.ChatId}
+}
+
+func (v *vacationMode) handlePlanning(queue *ordered_queue.OrderedQueue, now time.Time) {
+	for _, episode := range v.planEpisodes(now) {
+		queue.Push(episode)
+	}
+}
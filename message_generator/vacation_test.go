@@ -0,0 +1,124 @@
+package message_generator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ordered_queue "github.com/Alberto-Izquierdo/GoOrderedQueue"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVacationJitter(t *testing.T) {
+	now := time.Date(2020, 5, 3, 12, 0, 0, 0, time.Local)
+	nominal := now.Add(2 * time.Hour)
+	vacation := newVacationMode(types.VacationConfiguration{JitterMinutes: 20, Seed: 42})
+	programmedAction := types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(nominal), Repeat: true, Vacation: true}
+
+	jittered := vacation.applyJitter(programmedAction, now)
+	assert.Equal(t, jittered, programmedAction, "Programmed actions should not be moved when the vacation mode is disabled")
+
+	vacation.enabled = true
+	jittered = vacation.applyJitter(programmedAction, now)
+	assert.True(t, jittered.Jitter <= 20*time.Minute && jittered.Jitter >= -20*time.Minute, "The jitter should be inside the window, instead it is %s", jittered.Jitter)
+	assert.Equal(t, time.Time(jittered.Time), nominal.Add(jittered.Jitter))
+	other := newVacationMode(types.VacationConfiguration{JitterMinutes: 20, Seed: 42})
+	other.enabled = true
+	assert.Equal(t, other.applyJitter(programmedAction, now), jittered, "The same seed should produce the same jitter")
+
+	jitteredAgain := vacation.applyJitter(jittered, now)
+	assert.Equal(t, time.Time(jitteredAgain.Time), nominal.Add(jitteredAgain.Jitter), "The jitter should always be relative to the programmed time")
+	vacation.enabled = false
+	assert.Equal(t, time.Time(vacation.applyJitter(jitteredAgain, now).Time), nominal, "Disabling the vacation mode should restore the programmed time")
+
+	late := programmedAction
+	late.Jitter = 10 * time.Minute
+	late.Time = types.MyTime(now.Add(5 * time.Minute))
+	assert.Equal(t, vacation.applyJitter(late, now), late, "Programmed actions whose programmed time already passed should keep their time")
+	restored := vacation.applyJitter(types.ProgrammedAction{Id: "a", Action: late.Action, Time: types.MyTime(time.Time(late.Time).Add(24 * time.Hour)), Repeat: true, Vacation: true, Jitter: late.Jitter}, now)
+	assert.Equal(t, time.Time(restored.Time), now.Add(-5*time.Minute+24*time.Hour), "The next day they should run at the programmed time")
+
+	vacation.enabled = true
+	programmedAction.Vacation = false
+	assert.Equal(t, vacation.applyJitter(programmedAction, now), programmedAction, "Only programmed actions with the vacation option should be moved")
+}
+
+func TestVacationEpisodes(t *testing.T) {
+	configuration := types.VacationConfiguration{EpisodePins: []string{"light", "lamp"}, Episodes: 3, EpisodeMaxMinutes: 10, Seed: 7}
+	configuration.EveningStart.UnmarshalJSON([]byte("20:00:00"))
+	configuration.EveningEnd.UnmarshalJSON([]byte("23:00:00"))
+	vacation := newVacationMode(configuration)
+	now := time.Date(2020, 5, 3, 12, 0, 0, 0, time.Local)
+	eveningStart := time.Date(2020, 5, 3, 20, 0, 0, 0, time.Local)
+	eveningEnd := time.Date(2020, 5, 3, 23, 0, 0, 0, time.Local)
+
+	episodes := vacation.planEpisodes(now)
+	require.Equal(t, len(episodes), 6, "Every episode should turn a pin on and off")
+	for index := 0; index < len(episodes); index += 2 {
+		on, off := episodes[index], episodes[index+1]
+		assert.True(t, on.Action.State)
+		assert.False(t, off.Action.State)
+		assert.Equal(t, on.Action.Pin, off.Action.Pin)
+		assert.False(t, on.Equals(off), "The on and the off of an episode should be different programmed actions")
+		assert.True(t, strings.HasPrefix(on.Id, vacationEpisodeIdPrefix))
+		assert.Contains(t, configuration.EpisodePins, on.Action.Pin)
+		assert.False(t, time.Time(on.Time).Before(eveningStart), "Episodes should start in the evening")
+		assert.False(t, time.Time(off.Time).After(eveningEnd), "Episodes should finish in the evening")
+		duration := time.Time(off.Time).Sub(time.Time(on.Time))
+		assert.True(t, duration >= time.Minute && duration <= 10*time.Minute, "Episodes should not last more than configured, instead it is %s", duration)
+	}
+	assert.Equal(t, vacation.nextPlanning, eveningEnd, "The next evening should be planned when this one finishes")
+	assert.Equal(t, newVacationMode(configuration).planEpisodes(now), episodes, "The same seed should produce the same episodes")
+
+	episodes = vacation.planEpisodes(eveningEnd)
+	require.Equal(t, len(episodes), 6)
+	assert.False(t, time.Time(episodes[0].Time).Before(eveningStart.Add(24*time.Hour)), "After the evening, the next day should be planned")
+
+	episodes = vacation.planEpisodes(eveningStart.Add(150 * time.Minute))
+	for _, episode := range episodes {
+		assert.False(t, time.Time(episode.Time).Before(eveningStart.Add(150*time.Minute)), "Episodes should not be planned in the past")
+	}
+}
+
+func TestVacationRequest(t *testing.T) {
+	now := time.Now()
+	queue := ordered_queue.OrderedQueue{}
+	nominal := now.Add(2 * time.Hour)
+	queue.Push(types.ProgrammedAction{Id: "a", Action: types.Action{Pin: "light", State: true}, Time: types.MyTime(nominal), Repeat: true, Vacation: true})
+	queue.Push(types.ProgrammedAction{Id: "b", Action: types.Action{Pin: "light", State: false}, Time: types.MyTime(now.Add(3 * time.Hour)), Repeat: true})
+	vacation := newVacationMode(types.VacationConfiguration{EpisodePins: []string{"light"}, Seed: 1})
+
+	response := vacation.handleRequest(types.VacationModeRequest{Enabled: true, ChatId: 123}, &queue, now)
+	assert.Equal(t, response.ChatId, int64(123))
+	assert.True(t, strings.HasPrefix(response.Message, "Vacation mode enabled"))
+	assert.Equal(t, queue.Size(), 2+2*defaultVacationEpisodes, "Enabling the vacation mode should add the evening episodes")
+	assert.NotNil(t, vacation.planningChannel(now))
+
+	response = vacation.handleRequest(types.VacationModeRequest{Enabled: false, ChatId: 123}, &queue, now)
+	assert.Equal(t, response.Message, "Vacation mode disabled")
+	require.Equal(t, queue.Size(), 2, "Disabling the vacation mode should remove the evening episodes")
+	for _, element := range queue.GetCurrentElements() {
+		programmedAction := element.(types.ProgrammedAction)
+		if programmedAction.Id == "a" {
+			assert.Equal(t, time.Time(programmedAction.Time), nominal, "Disabling the vacation mode should restore the programmed time")
+		}
+	}
+	assert.Nil(t, vacation.planningChannel(now))
+}
+
+func TestVacationRequestKeepsStartedEpisodes(t *testing.T) {
+	now := time.Now()
+	queue := ordered_queue.OrderedQueue{}
+	// The on of the first episode already ran
+	queue.Push(types.ProgrammedAction{Id: vacationEpisodeIdPrefix + "1-off", Action: types.Action{Pin: "light", State: false}, Time: types.MyTime(now.Add(5 * time.Minute)), Timer: true})
+	queue.Push(types.ProgrammedAction{Id: vacationEpisodeIdPrefix + "2-on", Action: types.Action{Pin: "lamp", State: true}, Time: types.MyTime(now.Add(time.Hour)), Timer: true})
+	queue.Push(types.ProgrammedAction{Id: vacationEpisodeIdPrefix + "2-off", Action: types.Action{Pin: "lamp", State: false}, Time: types.MyTime(now.Add(2 * time.Hour)), Timer: true})
+	vacation := newVacationMode(types.VacationConfiguration{EpisodePins: []string{"light", "lamp"}, Seed: 1})
+	vacation.enabled = true
+
+	vacation.handleRequest(types.VacationModeRequest{Enabled: false, ChatId: 123}, &queue, now)
+	require.Equal(t, queue.Size(), 1, "Episodes that did not start should be removed")
+	assert.Equal(t, queue.GetCurrentElements()[0].(types.ProgrammedAction).Id, vacationEpisodeIdPrefix+"1-off", "Episodes that started should still turn their pin off")
+}
@@ -0,0 +1,96 @@
+package proto_conversion
+
+import (
+	"time"
+
+	messages_protocol "github.com/Alberto-Izquierdo/RPIHomeServer-go/messages"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+// ProgrammedActionToProto converts the programmed action to the message sent between the server and the nodes
+func ProgrammedActionToProto(programmedAction types.ProgrammedAction) *messages_protocol.ProgrammedAction {
+	result := &messages_protocol.ProgrammedAction{
+		Id: programmedAction.Id,
+		Action: &messages_protocol.PinStatePair{
+			Pin:    programmedAction.Action.Pin,
+			State:  programmedAction.Action.State,
+			ChatId: programmedAction.Action.ChatId,
+		},
+		Time:           programmedAction.Time.Format("15:04:05"),
+		Repeat:         programmedAction.Repeat,
+		NotifySkipped:  programmedAction.NotifySkipped,
+		AlertOnFailure: programmedAction.AlertOnFailure,
+		Notify:         programmedAction.Notify,
+		NotifyChatId:   programmedAction.NotifyChatId,
+		Vacation:       programmedAction.Vacation,
+		Type:           programmedAction.Type,
+		Message:        programmedAction.Message,
+		Sequence:       programmedAction.Sequence,
+	}
+	// Timers run at an absolute date instead of at a time of the day
+	if programmedAction.Timer {
+		result.Deadline = time.Time(programmedAction.Time).Unix()
+	} else if !programmedAction.Repeat {
+		// One-shot actions run a single day, the time alone would make them look daily
+		result.Date = time.Time(programmedAction.Time).Unix()
+	}
+	for _, weekday := range programmedAction.Weekdays {
+		result.Weekdays = append(result.Weekdays, int32(weekday))
+	}
+	for _, condition := range programmedAction.Conditions {
+		result.Conditions = append(result.Conditions, &messages_protocol.Condition{
+			Pin:      condition.Pin,
+			State:    condition.State,
+			Sensor:   condition.Sensor,
+			Operator: condition.Operator,
+			Value:    condition.Value,
+		})
+	}
+	return result
+}
+
+// ProgrammedActionFromProto returns an error if the time of the message is not valid
+func ProgrammedActionFromProto(programmedAction *messages_protocol.ProgrammedAction) (types.ProgrammedAction, error) {
+	myTime := types.MyTime(time.Now())
+	err := myTime.UnmarshalJSON([]byte(programmedAction.Time))
+	if err != nil {
+		return types.ProgrammedAction{}, err
+	}
+	result := types.ProgrammedAction{
+		Id: programmedAction.Id,
+		Action: types.Action{
+			Pin:    programmedAction.Action.Pin,
+			State:  programmedAction.Action.State,
+			ChatId: programmedAction.Action.ChatId,
+		},
+		Time:           myTime,
+		Repeat:         programmedAction.Repeat,
+		NotifySkipped:  programmedAction.NotifySkipped,
+		AlertOnFailure: programmedAction.AlertOnFailure,
+		Notify:         programmedAction.Notify,
+		NotifyChatId:   programmedAction.NotifyChatId,
+		Vacation:       programmedAction.Vacation,
+		Type:           programmedAction.Type,
+		Message:        programmedAction.Message,
+		Sequence:       programmedAction.Sequence,
+	}
+	if programmedAction.Deadline != 0 {
+		result.Timer = true
+		result.Time = types.MyTime(time.Unix(programmedAction.Deadline, 0))
+	} else if programmedAction.Date != 0 && !programmedAction.Repeat {
+		result.Time = types.MyTime(time.Unix(programmedAction.Date, 0))
+	}
+	for _, weekday := range programmedAction.Weekdays {
+		result.Weekdays = append(result.Weekdays, time.Weekday(weekday))
+	}
+	for _, condition := range programmedAction.Conditions {
+		result.Conditions = append(result.Conditions, types.Condition{
+			Pin:      condition.Pin,
+			State:    condition.State,
+			Sensor:   condition.Sensor,
+			Operator: condition.Operator,
+			Value:    condition.Value,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package proto_conversion
+
+import (
+	"testing"
+	"time"
+
+	messages_protocol "github.com/Alberto-Izquierdo/RPIHomeServer-go/messages"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgrammedActionConversion(t *testing.T) {
+	at, err := time.Parse("15:04:05", "07:30:00")
+	require.Nil(t, err)
+	programmedAction := types.ProgrammedAction{
+		Id:             "1a2b3c",
+		Action:         types.Action{"light", true, 5},
+		Repeat:         true,
+		Time:           types.MyTime(at),
+		Weekdays:       []time.Weekday{time.Monday, time.Friday},
+		Conditions:     []types.Condition{types.Condition{Sensor: "temperature", Operator: "<", Value: 18}},
+		AlertOnFailure: true,
+		Notify:         true,
+		NotifyChatId:   7,
+	}
+	converted, err := ProgrammedActionFromProto(ProgrammedActionToProto(programmedAction))
+	assert.Nil(t, err)
+	assert.Equal(t, converted.Id, programmedAction.Id)
+	assert.Equal(t, converted.Action, programmedAction.Action)
+	assert.Equal(t, converted.Time.Format("15:04:05"), "07:30:00")
+	assert.Equal(t, converted.Weekdays, programmedAction.Weekdays)
+	assert.Equal(t, converted.Conditions, programmedAction.Conditions)
+	assert.True(t, converted.Repeat && converted.AlertOnFailure && converted.Notify)
+	assert.Equal(t, converted.NotifyChatId, int64(7))
+
+	timer := types.NewTimer(types.Action{"light", false, 5}, time.Hour)
+	converted, err = ProgrammedActionFromProto(ProgrammedActionToProto(timer))
+	assert.Nil(t, err)
+	assert.True(t, converted.Timer, "Timers should keep their deadline")
+	assert.Equal(t, time.Time(converted.Time).Unix(), time.Time(timer.Time).Unix())
+
+	once := programmedAction
+	once.Repeat = false
+	once.Time = types.MyTime(time.Date(2020, 6, 10, 7, 30, 0, 0, time.Local))
+	converted, err = ProgrammedActionFromProto(ProgrammedActionToProto(once))
+	assert.Nil(t, err)
+	assert.False(t, converted.Repeat || converted.Timer)
+	assert.True(t, time.Time(converted.Time).Equal(time.Time(once.Time)), "One-shot actions should keep their date")
+	reminder := types.NewReminder("take the bins out", 5, time.Now(), false)
+	converted, _ = ProgrammedActionFromProto(ProgrammedActionToProto(reminder))
+	assert.Equal(t, converted.Type, int32(types.REMINDER_ACTION))
+	assert.Equal(t, converted.Message, "take the bins out")
+
+	_, err = ProgrammedActionFromProto(&messages_protocol.ProgrammedAction{Action: &messages_protocol.PinStatePair{}, Time: "soon"})
+	assert.NotNil(t, err, "Wrong times should return an error")
+}
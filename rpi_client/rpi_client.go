@@ -3,13 +3,16 @@ package rpi_client
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/gpio_manager"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/grpc_client"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/history_manager"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/message_generator"
 	messages_protocol "github.com/Alberto-Izquierdo/RPIHomeServer-go/messages"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/sensor_manager"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
 	"google.golang.org/grpc"
 )
@@ -31,6 +34,30 @@ func SetupAndRun(config configuration_loader.InitialConfiguration, exitChannel c
 		return err
 	}
 
+	// sensor manager config
+	err = sensor_manager.Setup(config.Sensors)
+	if err != nil {
+		return err
+	}
+
+	// history manager config
+	historyPath := ""
+	if config.DataDirectory != "" {
+		historyPath = filepath.Join(config.DataDirectory, "history.json")
+	}
+	err = history_manager.Setup(historyPath, config.HistorySize)
+	if err != nil {
+		fmt.Println("The execution history will start empty: " + err.Error())
+	}
+	pinChangesPath := ""
+	if config.DataDirectory != "" {
+		pinChangesPath = filepath.Join(config.DataDirectory, "pin_changes.json")
+	}
+	err = history_manager.SetupPinChanges(pinChangesPath, 0)
+	if err != nil {
+		fmt.Println("The pin changes history will start empty: " + err.Error())
+	}
+
 	// gRPC client config
 	client, connection, err := connectToGrpcServer(config)
 	if err != nil {
@@ -47,12 +74,36 @@ func SetupAndRun(config configuration_loader.InitialConfiguration, exitChannel c
 }
 
 func run(exitChannel chan bool, client messages_protocol.RPIHomeServerServiceClient, connection *grpc.ClientConn, config configuration_loader.InitialConfiguration) {
-	telegramResponsesChannel := make(chan types.TelegramMessage)
-	programmedActionOperationsChannel := make(chan types.ProgrammedActionOperation)
+	channels := types.NewNodeChannels()
+	gpio_manager.SetPinChangesListener(func(pinChange types.PinChange) {
+		err := history_manager.RecordPinChange(pinChange)
+		if err != nil {
+			fmt.Println("Could not record the pin change: " + err.Error())
+		}
+		// The server uses them for the daily summary
+		go func() {
+			select {
+			case channels.PinChanges <- pinChange:
+			case <-time.After(time.Second):
+			}
+		}()
+	})
+	// Manual actions can suspend the programmed actions of the pin, even if they do not change its state
+	gpio_manager.SetManualActionsListener(func(pinChange types.PinChange) {
+		go func() {
+			channels.ManualChanges <- pinChange
+		}()
+	})
+	var vacationConfiguration types.VacationConfiguration
+	if config.Vacation != nil {
+		vacationConfiguration = *config.Vacation
+	}
 	messageGeneratorExitChannel := make(chan bool)
-	message_generator.Run(config.AutomaticMessages, programmedActionOperationsChannel, telegramResponsesChannel, messageGeneratorExitChannel)
+	message_generator.Run(config.AutomaticMessages, channels, func(pin string) (bool, error) {
+		return grpc_client.GetPinState(client, pin)
+	}, vacationConfiguration, config.Overrides, config.Sequences, messageGeneratorExitChannel)
 	grpcClientExitChannel := make(chan bool)
-	go grpc_client.Run(programmedActionOperationsChannel, telegramResponsesChannel, grpcClientExitChannel, client, connection, config)
+	go grpc_client.Run(channels, grpcClientExitChannel, client, connection, config)
 	<-exitChannel
 	fmt.Println("Exit signal received in RPI client")
 	grpcClientExitChannel <- true
@@ -18,7 +18,7 @@ func createServer(t *testing.T) (chan bool, chan types.Action, chan types.Telegr
 	serverExitChannel := make(chan bool)
 	outputChannel := make(chan types.Action)
 	responsesChannel := make(chan types.TelegramMessage)
-	err := grpc_server.SetupAndRun(serverConfig, outputChannel, nil, responsesChannel, serverExitChannel)
+	err := grpc_server.SetupAndRun(serverConfig, types.BotChannels{Actions: outputChannel, Responses: responsesChannel}, serverExitChannel)
 	assert.Nil(t, err)
 	return serverExitChannel, outputChannel, responsesChannel
 }
@@ -84,7 +84,8 @@ func TestCheckForActions(t *testing.T) {
 		serverInputChannel <- types.Action{"pin2", true, 0}
 		<-serverOutputChannel
 	}()
-	actions, _, err := grpc_client.CheckForActions(client)
+	actionsToPerform, err := grpc_client.CheckForActions(client)
+	actions := actionsToPerform.Actions
 	assert.Equal(t, len(actions), 1, "Actions received should only contain one element, instead it contains %d", len(actions))
 	assert.Equal(t, actions[0].Pin, "pin2", "Action received should be \"pin2\", instead it is %s", actions[0].Pin)
 	assert.Equal(t, actions[0].State, true, "Action state received should be \"true\"")
@@ -122,7 +123,7 @@ func TestRun(t *testing.T) {
 	programmedActionOperationsChannel := make(chan types.ProgrammedActionOperation)
 	go func() {
 		time.Sleep(1 * time.Second)
-		grpc_client.Run(programmedActionOperationsChannel, telegramChannel, clientExitChannel, client, connection, configuration_loader.InitialConfiguration{})
+		grpc_client.Run(types.NodeChannels{ProgrammedActions: programmedActionOperationsChannel, Responses: telegramChannel}, clientExitChannel, client, connection, configuration_loader.InitialConfiguration{})
 	}()
 	clientExitChannel <- true
 	serverExitChannel <- true
@@ -0,0 +1,198 @@
+package schedule_checker
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+type Issue struct {
+	// Errors are schedules that can not work (e.g. turning a pin on and off at the same time), warnings are probably mistakes
+	Error   bool
+	Message string
+	// Indexes of the programmed actions involved
+	involved []int
+}
+
+// Check analyses the programmed actions looking for contradictory or redundant actions on the same pin
+func Check(programmedActions []types.ProgrammedAction) []Issue {
+	var issues []Issue
+	pins := make(map[string][]int)
+	var pinNames []string
+	for index, programmedAction := range programmedActions {
+		if programmedAction.Type != types.PIN_ACTION {
+			continue
+		}
+		if _, ok := pins[programmedAction.Action.Pin]; !ok {
+			pinNames = append(pinNames, programmedAction.Action.Pin)
+		}
+		pins[programmedAction.Action.Pin] = append(pins[programmedAction.Action.Pin], index)
+	}
+	sort.Strings(pinNames)
+	for _, pin := range pinNames {
+		issues = append(issues, checkSameMoment(programmedActions, pins[pin])...)
+		issues = append(issues, checkDailySequence(programmedActions, pins[pin])...)
+	}
+	return issues
+}
+
+// CheckNew returns the issues the new programmed action would introduce in the schedule
+func CheckNew(programmedAction types.ProgrammedAction, programmedActions []types.ProgrammedAction) []Issue {
+	all := append(append([]types.ProgrammedAction{}, programmedActions...), programmedAction)
+	var issues []Issue
+	for _, issue := range Check(all) {
+		for _, index := range issue.involved {
+			if index == len(all)-1 {
+				issues = append(issues, issue)
+				break
+			}
+		}
+	}
+	return issues
+}
+
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if issue.Error {
+			return true
+		}
+	}
+	return false
+}
+
+func IssuesToString(issues []Issue) string {
+	var lines []string
+	for _, issue := range issues {
+		if issue.Error {
+			lines = append(lines, "Error: "+issue.Message)
+		} else {
+			lines = append(lines, "Warning: "+issue.Message)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Two programmed actions on the same pin that run at the same moment
+func checkSameMoment(programmedActions []types.ProgrammedAction, indexes []int) []Issue {
+	var issues []Issue
+	for i := 0; i < len(indexes); i++ {
+		for j := i + 1; j < len(indexes); j++ {
+			a, b := programmedActions[indexes[i]], programmedActions[indexes[j]]
+			if !sameMoment(a, b) {
+				continue
+			}
+			involved := []int{indexes[i], indexes[j]}
+			conditional := len(a.Conditions) > 0 || len(b.Conditions) > 0
+			if a.Action.State != b.Action.State {
+				message := describe(a) + " and " + describe(b) + " run at the same time"
+				if conditional {
+					issues = append(issues, Issue{Message: message + ", the final state depends on their conditions", involved: involved})
+				} else {
+					issues = append(issues, Issue{Error: true, Message: message + ", the final state of " + a.Action.Pin + " would be random", involved: involved})
+				}
+			} else if !conditional {
+				issues = append(issues, Issue{Message: describe(a) + " and " + describe(b) + " are redundant, one of them can be removed", involved: involved})
+			}
+		}
+	}
+	return issues
+}
+
+func sameMoment(a types.ProgrammedAction, b types.ProgrammedAction) bool {
+	if !a.Repeat && !b.Repeat {
+		// The dates sent between the server and the nodes do not keep fractions of a second
+		return time.Time(a.Time).Unix() == time.Time(b.Time).Unix()
+	}
+	if secondOfDay(a) != secondOfDay(b) {
+		return false
+	} else if !a.Repeat {
+		return b.RunsOn(time.Time(a.Time))
+	} else if !b.Repeat {
+		return a.RunsOn(time.Time(b.Time))
+	}
+	// A daily action also runs the days of an action limited to some weekdays
+	if len(a.Weekdays) == 0 || len(b.Weekdays) == 0 {
+		return true
+	}
+	for _, weekday := range a.Weekdays {
+		for _, other := range b.Weekdays {
+			if weekday == other {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Daily unconditional actions on the same pin should alternate its state (actions limited to some weekdays are not checked)
+func checkDailySequence(programmedActions []types.ProgrammedAction, indexes []int) []Issue {
+	var issues []Issue
+	var daily []int
+	for _, index := range indexes {
+		programmedAction := programmedActions[index]
+		if programmedAction.Repeat && !programmedAction.Timer && len(programmedAction.Conditions) == 0 && len(programmedAction.Weekdays) == 0 {
+			daily = append(daily, index)
+		}
+	}
+	if len(daily) < 2 {
+		return issues
+	}
+	sort.SliceStable(daily, func(i, j int) bool {
+		return secondOfDay(programmedActions[daily[i]]) < secondOfDay(programmedActions[daily[j]])
+	})
+	allEqual := true
+	for _, index := range daily {
+		allEqual = allEqual && programmedActions[index].Action.State == programmedActions[daily[0]].Action.State
+	}
+	for position, index := range daily {
+		// The last action of the day precedes the first one of the next day
+		if position == 0 && allEqual {
+			continue
+		}
+		previous := programmedActions[daily[(position+len(daily)-1)%len(daily)]]
+		current := programmedActions[index]
+		if secondOfDay(previous) == secondOfDay(current) {
+			continue
+		}
+		if previous.Action.State == current.Action.State {
+			issues = append(issues, Issue{
+				Message:  describe(current) + " is redundant, " + current.Action.Pin + " is already " + stateToString(current.Action.State) + " since " + previous.Time.Format("15:04:05"),
+				involved: []int{daily[(position+len(daily)-1)%len(daily)], index},
+			})
+		} else if position == 0 && previous.Action.State {
+			// The pin is turned off before being turned on every day, so it stays on overnight
+			duration := time.Duration(secondOfDay(current)-secondOfDay(previous)+24*60*60) * time.Second
+			issues = append(issues, Issue{
+				Message:  describe(current) + " precedes " + describe(previous) + ", so " + current.Action.Pin + " stays on overnight for " + duration.String(),
+				involved: []int{daily[len(daily)-1], index},
+			})
+		}
+	}
+	return issues
+}
+
+func secondOfDay(programmedAction types.ProgrammedAction) int {
+	t := time.Time(programmedAction.Time)
+	return t.Hour()*60*60 + t.Minute()*60 + t.Second()
+}
+
+func stateToString(state bool) string {
+	if state {
+		return "on"
+	}
+	return "off"
+}
+
+func describe(programmedAction types.ProgrammedAction) string {
+	result := "\"" + programmedAction.Action.Pin + " " + stateToString(programmedAction.Action.State) + " at " + programmedAction.Time.Format("15:04:05")
+	if programmedAction.Timer {
+		result += " (timer)"
+	} else if programmedAction.Repeat {
+		result += " daily"
+	} else {
+		result += " " + programmedAction.Time.Format("02/01")
+	}
+	return result + "\""
+}
@@ -0,0 +1,100 @@
+package schedule_checker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func daily(pin string, state bool, hour int, minute int) types.ProgrammedAction {
+	return types.ProgrammedAction{Action: types.Action{Pin: pin, State: state}, Time: types.MyTime(time.Date(2020, 5, 3, hour, minute, 0, 0, time.Local)), Repeat: true}
+}
+
+func TestContradictoryActions(t *testing.T) {
+	issues := Check([]types.ProgrammedAction{daily("light", true, 7, 0), daily("light", false, 7, 0), daily("heater", true, 7, 0)})
+	require.Equal(t, len(issues), 1, "Only actions on the same pin should be compared")
+	assert.True(t, issues[0].Error, "Turning a pin on and off at the same time should be an error")
+	assert.True(t, HasErrors(issues))
+
+	once := daily("light", false, 7, 0)
+	once.Repeat = false
+	once.Time = types.MyTime(time.Date(2020, 6, 10, 7, 0, 0, 0, time.Local))
+	issues = Check([]types.ProgrammedAction{daily("light", true, 7, 0), once})
+	require.Equal(t, len(issues), 1, "One-off actions also run at the same time as the daily ones")
+	assert.True(t, issues[0].Error)
+
+	otherDay := once
+	otherDay.Action.State = true
+	otherDay.Time = types.MyTime(time.Date(2020, 6, 11, 7, 0, 0, 0, time.Local))
+	assert.Equal(t, len(Check([]types.ProgrammedAction{once, otherDay})), 0, "One-off actions on different days should not be compared")
+	sameDay := otherDay
+	sameDay.Time = types.MyTime(time.Time(once.Time).Add(time.Millisecond))
+	issues = Check([]types.ProgrammedAction{once, sameDay})
+	require.Equal(t, len(issues), 1, "One-off actions on the same second should be compared")
+	assert.True(t, strings.Contains(issues[0].Message, "10/06"), "The issue should show their date, instead it is \"%s\"", issues[0].Message)
+
+	conditional := daily("light", false, 7, 0)
+	conditional.Conditions = []types.Condition{types.Condition{Pin: "window", State: true}}
+	issues = Check([]types.ProgrammedAction{daily("light", true, 7, 0), conditional})
+	require.Equal(t, len(issues), 1)
+	assert.False(t, issues[0].Error, "Conditional actions could never run at the same time, so they should only be warned")
+}
+
+func TestRedundantActions(t *testing.T) {
+	issues := Check([]types.ProgrammedAction{daily("light", true, 7, 0), daily("light", true, 7, 0)})
+	require.Equal(t, len(issues), 1)
+	assert.False(t, issues[0].Error, "Duplicated actions should be a warning")
+	assert.True(t, strings.Contains(issues[0].Message, "redundant"))
+
+	issues = Check([]types.ProgrammedAction{daily("light", true, 7, 0), daily("light", true, 9, 0), daily("light", false, 12, 0), daily("light", false, 23, 0)})
+	require.Equal(t, len(issues), 2, "Turning a pin on when it is already on should be warned")
+	assert.Equal(t, IssuesToString(issues), "Warning: \"light on at 09:00:00 daily\" is redundant, light is already on since 07:00:00\n"+
+		"Warning: \"light off at 23:00:00 daily\" is redundant, light is already off since 12:00:00")
+
+	issues = Check([]types.ProgrammedAction{daily("light", true, 7, 0), daily("light", false, 12, 0)})
+	assert.Equal(t, len(issues), 0, "Alternating actions should not be warned")
+}
+
+func TestOffPrecedesOn(t *testing.T) {
+	issues := Check([]types.ProgrammedAction{daily("light", true, 8, 0), daily("light", false, 7, 0)})
+	require.Equal(t, len(issues), 1)
+	assert.False(t, issues[0].Error)
+	assert.Equal(t, issues[0].Message, "\"light off at 07:00:00 daily\" precedes \"light on at 08:00:00 daily\", so light stays on overnight for 23h0m0s")
+}
+
+func TestCheckNew(t *testing.T) {
+	schedule := []types.ProgrammedAction{daily("light", true, 7, 0), daily("light", true, 7, 0), daily("light", false, 12, 0)}
+	assert.Equal(t, len(CheckNew(daily("heater", true, 7, 0), schedule)), 0, "Issues already present in the schedule should not be returned")
+	issues := CheckNew(daily("light", false, 7, 0), schedule)
+	assert.True(t, HasErrors(issues))
+	reminder := types.NewReminder("take the bins out", 1, time.Time(schedule[0].Time), true)
+	assert.Equal(t, len(CheckNew(reminder, append(schedule, reminder))), 0, "Reminders do not change any pin")
+}
+
+func TestWeekdays(t *testing.T) {
+	weekdays := daily("light", false, 7, 0)
+	weekdays.Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	weekends := daily("light", true, 7, 0)
+	weekends.Weekdays = []time.Weekday{time.Saturday, time.Sunday}
+	issues := Check([]types.ProgrammedAction{weekdays, weekends})
+	assert.Equal(t, len(issues), 0, "Actions that never run the same day should not be compared")
+
+	issues = Check([]types.ProgrammedAction{weekdays, daily("light", true, 7, 0)})
+	require.Equal(t, len(issues), 1, "Daily actions also run the days of the ones limited to some weekdays")
+	assert.True(t, issues[0].Error)
+
+	once := daily("light", true, 7, 0)
+	once.Repeat = false
+	issues = Check([]types.ProgrammedAction{weekdays, once})
+	assert.Equal(t, len(issues), 0, "2020-05-03 is a sunday")
+	once.Time = types.MyTime(time.Date(2020, 5, 4, 7, 0, 0, 0, time.Local))
+	issues = Check([]types.ProgrammedAction{weekdays, once})
+	assert.Equal(t, len(issues), 1, "2020-05-04 is a monday")
+
+	issues = Check([]types.ProgrammedAction{weekdays, daily("light", false, 23, 0)})
+	assert.Equal(t, len(issues), 0, "Actions limited to some weekdays should not be checked as daily sequences")
+}
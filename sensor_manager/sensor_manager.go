@@ -0,0 +1,67 @@
+package sensor_manager
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+func Setup(sensors []types.Sensor) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.sensors = make(map[string]types.Sensor)
+	for _, sensor := range sensors {
+		if sensor.Name == "" || sensor.Path == "" {
+			manager.sensors = make(map[string]types.Sensor)
+			return errors.New("Sensors should have a name and a path")
+		}
+		if _, ok := manager.sensors[sensor.Name]; ok {
+			manager.sensors = make(map[string]types.Sensor)
+			return errors.New("Sensor " + sensor.Name + " defined twice")
+		}
+		if sensor.Scale == 0 {
+			sensor.Scale = 1
+		}
+		manager.sensors[sensor.Name] = sensor
+	}
+	return nil
+}
+
+func GetSensorValue(name string) (float64, error) {
+	manager.mutex.Lock()
+	sensor, ok := manager.sensors[name]
+	manager.mutex.Unlock()
+	if !ok {
+		return 0, errors.New("[sensor_manager]: Sensor " + name + " not set in the initial configuration")
+	}
+	content, err := ioutil.ReadFile(sensor.Path)
+	if err != nil {
+		return 0, errors.New("[sensor_manager]: Could not read sensor " + name + ": " + err.Error())
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+	if err != nil {
+		return 0, errors.New("[sensor_manager]: Sensor " + name + " value not valid: " + err.Error())
+	}
+	return value * sensor.Scale, nil
+}
+
+func GetSensorsAvailable() []string {
+	sensors := make([]string, 0)
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	for name := range manager.sensors {
+		sensors = append(sensors, name)
+	}
+	return sensors
+}
+
+var manager sensorManager
+
+type sensorManager struct {
+	sensors map[string]types.Sensor
+	mutex   sync.Mutex
+}
@@ -0,0 +1,45 @@
+package sensor_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSensorManager(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sensors")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "temperature")
+	err = ioutil.WriteFile(path, []byte("17500\n"), 0644)
+	assert.Nil(t, err)
+
+	err = Setup([]types.Sensor{types.Sensor{Name: "temperature", Path: path, Scale: 0.001}, types.Sensor{Name: "raw", Path: path}})
+	assert.Nil(t, err, "Setup error: %s", err)
+	assert.Equal(t, len(GetSensorsAvailable()), 2)
+	value, err := GetSensorValue("temperature")
+	assert.Nil(t, err)
+	assert.InDelta(t, value, 17.5, 0.0001)
+	value, err = GetSensorValue("raw")
+	assert.Nil(t, err)
+	assert.Equal(t, value, 17500.0, "Sensors without scale should return the raw value")
+	_, err = GetSensorValue("humidity")
+	assert.NotNil(t, err, "Sensors not configured should return an error")
+
+	err = ioutil.WriteFile(path, []byte("not a number"), 0644)
+	assert.Nil(t, err)
+	_, err = GetSensorValue("temperature")
+	assert.NotNil(t, err, "Sensors with invalid content should return an error")
+}
+
+func TestWrongSensors(t *testing.T) {
+	err := Setup([]types.Sensor{types.Sensor{Name: "temperature"}})
+	assert.NotNil(t, err, "Sensors without path should return an error")
+	err = Setup([]types.Sensor{types.Sensor{Name: "temperature", Path: "a"}, types.Sensor{Name: "temperature", Path: "b"}})
+	assert.NotNil(t, err, "Repeated sensors should return an error")
+	assert.Equal(t, len(GetSensorsAvailable()), 0)
+}
@@ -0,0 +1,99 @@
+package telegram_bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/audit_log"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+// Entries shown by /audit
+const auditEntriesShown int = 20
+
+// Pin actions waiting for their result, by chat and pin
+type chatPin struct {
+	chatId int64
+	pin    string
+}
+
+// auditEntry describes the command of the user, text is the command once phrases are translated
+func auditEntry(command Command, text string, nodeOfPin func(pin string) string, result string) types.AuditEntry {
+	entry := types.AuditEntry{Date: time.Now(), UserId: command.UserId, ChatId: command.ChatId, Command: text, Result: result}
+	fields := strings.Fields(text)
+	if len(fields) > 0 && strings.EqualFold(fields[0], "/join") {
+		// Invitation codes are secret
+		entry.Command = fields[0]
+	} else if pin, _, ok := pinButtonAction(firstField(fields)); ok && !strings.HasPrefix(fields[0], "/") {
+		entry.Pin = pin
+		if nodeOfPin != nil {
+			entry.Node = nodeOfPin(pin)
+		}
+	}
+	return entry
+}
+
+// pinAuditEntry is the entry of commands on a pin that do not name it like the buttons (e.g. phrases)
+func pinAuditEntry(command Command, pin string, nodeOfPin func(pin string) string, result string) types.AuditEntry {
+	entry := types.AuditEntry{Date: time.Now(), UserId: command.UserId, ChatId: command.ChatId, Command: command.Text, Pin: pin, Result: result}
+	if nodeOfPin != nil {
+		entry.Node = nodeOfPin(pin)
+	}
+	return entry
+}
+
+// Errors are only printed, the audit log should not stop the users
+func recordAudit(entry types.AuditEntry) {
+	if err := audit_log.Record(entry); err != nil {
+		fmt.Println("There was an error recording an audit entry: ", err.Error())
+	}
+}
+
+func firstField(fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// pinActionResult returns the pin and the result of messages like "Action Light successful"
+func pinActionResult(message string) (string, string, bool) {
+	fields := strings.Fields(message)
+	if len(fields) == 3 && fields[0] == "Action" && fields[2] == "successful" {
+		return fields[1], "successful", true
+	} else if len(fields) == 4 && fields[0] == "Action" && fields[2] == "not" && fields[3] == "successful" {
+		return fields[1], "failed", true
+	}
+	return "", "", false
+}
+
+// "/audit", "/audit user [id]" or "/audit pin [pin]"
+func auditMessage(message string, chatId int64) Message {
+	fields := strings.Fields(message)
+	userId, pin := 0, ""
+	if len(fields) == 3 && strings.EqualFold(fields[1], "user") {
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return buildMessage("The user should be its id (e.g. \"/audit user 1234\")", chatId, -1)
+		}
+		userId = id
+	} else if len(fields) == 3 && strings.EqualFold(fields[1], "pin") {
+		pin = fields[2]
+	} else if len(fields) != 1 {
+		return buildMessage("Audit messages should be \"/audit\", \"/audit user [id]\" or \"/audit pin [pin]\"", chatId, -1)
+	}
+	entries, err := audit_log.GetEntries(userId, pin, auditEntriesShown)
+	if err != nil {
+		return buildMessage("The audit log could not be read: "+err.Error(), chatId, -1)
+	}
+	text := "Audit log:"
+	if len(entries) == 0 {
+		text += "\nNo entries"
+	}
+	for _, entry := range entries {
+		text += "\n" + types.AuditEntryToString(entry)
+	}
+	return buildMessage(text, chatId, -1)
+}
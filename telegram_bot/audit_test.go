@@ -0,0 +1,69 @@
+package telegram_bot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/audit_log"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinActionResult(t *testing.T) {
+	pin, result, ok := pinActionResult("Action lamp successful")
+	assert.True(t, ok)
+	assert.Equal(t, pin, "lamp")
+	assert.Equal(t, result, "successful")
+	_, result, ok = pinActionResult("Action lamp not successful")
+	assert.True(t, ok)
+	assert.Equal(t, result, "failed")
+	_, _, ok = pinActionResult("Programmed actions:")
+	assert.False(t, ok)
+}
+
+func TestTelegramBotAudit(t *testing.T) {
+	require.Nil(t, audit_log.Setup("", 0))
+	telegramOutputChannel := make(chan types.Action)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{
+		TelegramAuthorizedUsers: []int{1},
+		TelegramUsers:           []configuration_loader.TelegramUser{configuration_loader.TelegramUser{Id: 3, Role: configuration_loader.VIEWER_ROLE}},
+	}
+	frontend := newFakeFrontend()
+	nodeOfPin := func(pin string) string { return "living" }
+	err := LaunchTelegramBot(config, frontend, nodeOfPin, types.BotChannels{Actions: telegramOutputChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "lampOn", UserId: 3, ChatId: 3}
+	<-frontend.sent
+	frontend.commands <- Command{Text: "lampOn", UserId: 1, ChatId: 1}
+	<-telegramOutputChannel
+	telegramInputChannel <- types.TelegramMessage{"Action lamp successful", 1}
+	<-frontend.sent
+	frontend.commands <- Command{Text: "/join 1234abcd", UserId: 5, ChatId: 5}
+	<-frontend.sent
+
+	entries, err := audit_log.GetEntries(0, "", 10)
+	require.Nil(t, err)
+	require.Equal(t, len(entries), 4)
+	assert.Equal(t, entries[0].Result, "Viewers can only query the status, lampOn not allowed", "Denied commands should be recorded")
+	assert.Equal(t, entries[1].Result, "accepted")
+	assert.Equal(t, entries[2].Result, "successful", "The result of the pin should be recorded")
+	assert.Equal(t, entries[2].Node, "living")
+	assert.Equal(t, entries[3].Command, "/join", "Invitation codes should not be recorded")
+
+	frontend.commands <- Command{Text: "/audit pin lamp", UserId: 1, ChatId: 1}
+	msg := <-frontend.sent
+	assert.Equal(t, len(strings.Split(msg.Text, "\n")), 4)
+	frontend.commands <- Command{Text: "/audit user 7", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "Audit log:\nNo entries")
+	frontend.commands <- Command{Text: "/audit user me", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "The user should be its id (e.g. \"/audit user 1234\")")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
@@ -0,0 +1,68 @@
+package telegram_bot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+// Commands waiting for a confirmation are forgotten if the user does not answer in this time
+const confirmationTimeout = time.Minute
+
+// pendingConfirmation is a command on a pin that requires confirmation, it is run when its user confirms it.
+// Phrases keep what they ask to do, their text is not a command
+type pendingConfirmation struct {
+	command    Command
+	expiration time.Time
+	order      *phrase
+}
+
+func (c pendingConfirmation) expired(now time.Time) bool {
+	return !now.Before(c.expiration)
+}
+
+// confirmationPin returns the pin that requires confirmation changed by the command, empty when there is none.
+// Programmed actions are confirmed when they are created (or replaced), not every time they run
+func confirmationPin(text string, chatId int64, requireConfirmation []string) string {
+	fields := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	if len(requireConfirmation) == 0 || fields[0] == "" {
+		return ""
+	}
+	pin := ""
+	switch strings.ToLower(fields[0]) {
+	case "createprogrammedaction", "updateprogrammedaction":
+		if len(fields) < 2 {
+			return ""
+		}
+		serialized := fields[1]
+		if strings.EqualFold(fields[0], "updateprogrammedaction") {
+			// The id goes before the new programmed action
+			updateFields := strings.Fields(serialized)
+			if len(updateFields) != 2 {
+				return ""
+			}
+			serialized = updateFields[1]
+		}
+		programmedAction, err := types.ProgrammedActionFromString(serialized, chatId)
+		if err != nil {
+			return ""
+		}
+		pin = programmedAction.Action.Pin
+	default:
+		if strings.HasPrefix(fields[0], "/") {
+			return ""
+		}
+		pin, _, _ = pinButtonAction(fields[0])
+	}
+	if pin == "" || !contains(requireConfirmation, pin) {
+		return ""
+	}
+	return pin
+}
+
+// e.g. "\"GarageOn\"? Garage requires confirmation" with the buttons to confirm or cancel it
+func confirmationMessage(text string, pin string, chatId int64) Message {
+	keyboard := Keyboard{[]Button{Button{"Yes", "/confirm"}, Button{"No", "/cancel"}}}
+	return Message{Text: "\"" + text + "\"? " + pin + " requires confirmation", ChatId: chatId, Keyboard: keyboard}
+}
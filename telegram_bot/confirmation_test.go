@@ -0,0 +1,75 @@
+package telegram_bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirmationPin(t *testing.T) {
+	requireConfirmation := []string{"garage"}
+	assert.Equal(t, confirmationPin("garageOn", 1, requireConfirmation), "garage")
+	assert.Equal(t, confirmationPin("garageOff 10m", 1, requireConfirmation), "garage", "Timers should be confirmed")
+	assert.Equal(t, confirmationPin("garageOnAndOff 2s", 1, requireConfirmation), "garage")
+	assert.Equal(t, confirmationPin("lampOn", 1, requireConfirmation), "")
+	assert.Equal(t, confirmationPin("garageOn", 1, nil), "")
+	assert.Equal(t, confirmationPin("/vacation on", 1, []string{"/vacation"}), "", "Commands starting with \"/\" do not change pins")
+	assert.Equal(t, confirmationPin("CreateProgrammedAction garage;true;false;07:30:00", 1, requireConfirmation), "garage", "Programmed actions should be confirmed when they are created")
+	assert.Equal(t, confirmationPin("UpdateProgrammedAction 1a2b3c garage;true;false;07:30:00", 1, requireConfirmation), "garage")
+	assert.Equal(t, confirmationPin("UpdateProgrammedAction 1a2b3c", 1, requireConfirmation), "")
+	assert.Equal(t, confirmationPin("CreateProgrammedAction lamp;true;false;07:30:00", 1, requireConfirmation), "")
+	assert.Equal(t, confirmationPin("RemoveProgrammedAction 1a2b3c", 1, requireConfirmation), "", "Removals do not change pins")
+}
+
+func TestTelegramBotConfirmation(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{
+		TelegramAuthorizedUsers: []int{1, 2},
+		RequireConfirmation:     []string{"garage"},
+	}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, ProgrammedActions: operationsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "garageOn", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-frontend.sent, confirmationMessage("garageOn", "garage", 1))
+	frontend.commands <- Command{Text: "/confirm", UserId: 2, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "There is nothing to confirm", "Only the user of the command can confirm it")
+	frontend.commands <- Command{Text: "/confirm", UserId: 1, ChatId: 1, CallbackId: "a"}
+	assert.Equal(t, <-frontend.answered, "a:")
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"garage", true, 1})
+	frontend.commands <- Command{Text: "/confirm", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "There is nothing to confirm", "Commands should only be confirmed once")
+
+	frontend.commands <- Command{Text: "lampOn", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"lamp", true, 1}, "Other pins should not be confirmed")
+
+	frontend.commands <- Command{Text: "CreateProgrammedAction garage;false;true;22:00:00", UserId: 1, ChatId: 1}
+	<-frontend.sent
+	frontend.commands <- Command{Text: "/cancel", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "Cancelled")
+	frontend.commands <- Command{Text: "CreateProgrammedAction garage;false;true;22:00:00", UserId: 1, ChatId: 1}
+	<-frontend.sent
+	frontend.commands <- Command{Text: "/confirm", UserId: 1, ChatId: 1}
+	operation := <-operationsChannel
+	assert.Equal(t, operation.Operation, int32(types.CREATE))
+	assert.Equal(t, operation.ProgrammedAction.Action.Pin, "garage")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
+
+func TestPendingConfirmationExpires(t *testing.T) {
+	now := time.Now()
+	pending := pendingConfirmation{Command{Text: "garageOn"}, now.Add(confirmationTimeout), nil}
+	assert.False(t, pending.expired(now))
+	assert.True(t, pending.expired(now.Add(confirmationTimeout)))
+}
@@ -0,0 +1,152 @@
+package telegram_bot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+// Conversations are forgotten if the user does not answer in this time
+const conversationTimeout = 5 * time.Minute
+
+const (
+	askPin = iota
+	askState
+	askTime
+	askRepeat
+	askConfirmation
+)
+
+// conversation guides a user through the creation of a programmed action, one question at a time
+type conversation struct {
+	step       int
+	userId     int
+	pin        string
+	state      bool
+	at         time.Time
+	repeat     bool
+	weekdays   []time.Weekday
+	expiration time.Time
+}
+
+var cancelButton = Button{"Cancel", "/cancel"}
+
+// newConversation asks for the pin, pins are offered as buttons
+func newConversation(userId int, pins []string, chatId int64, now time.Time) (*conversation, Message) {
+	c := conversation{step: askPin, userId: userId, expiration: now.Add(conversationTimeout)}
+	var keyboard Keyboard
+	for _, pin := range pins {
+		keyboard = append(keyboard, []Button{Button{pin, pin}})
+	}
+	keyboard = append(keyboard, []Button{cancelButton})
+	return &c, Message{Text: "New programmed action. Which pin?", ChatId: chatId, Keyboard: keyboard}
+}
+
+func (c *conversation) expired(now time.Time) bool {
+	return !now.Before(c.expiration)
+}
+
+// handle processes an answer and returns the next question. When the user confirms it returns the
+// programmed action in the raw "pin;state;repeat;hh:mm:ss[;options]" syntax
+func (c *conversation) handle(text string, pinAllowed func(pin string) bool, chatId int64, now time.Time) (reply Message, programmedAction string, finished bool) {
+	c.expiration = now.Add(conversationTimeout)
+	answer := strings.TrimSpace(text)
+	switch c.step {
+	case askPin:
+		if len(strings.Fields(answer)) != 1 || !pinAllowed(answer) {
+			return c.question("You can not program "+answer+", which pin?", nil, chatId), "", false
+		}
+		c.pin = answer
+		c.step = askState
+		return c.question("Turn "+c.pin+" on or off?", []Button{Button{"On", "on"}, Button{"Off", "off"}}, chatId), "", false
+	case askState:
+		if !strings.EqualFold(answer, "on") && !strings.EqualFold(answer, "off") {
+			return c.question("Please answer \"on\" or \"off\"", []Button{Button{"On", "on"}, Button{"Off", "off"}}, chatId), "", false
+		}
+		c.state = strings.EqualFold(answer, "on")
+		c.step = askTime
+		return c.question("At what time? (hh:mm)", nil, chatId), "", false
+	case askTime:
+		at, valid := time.Time{}, false
+		for _, layout := range []string{"15:04", "15:04:05"} {
+			if parsed, err := time.Parse(layout, answer); err == nil {
+				at, valid = parsed, true
+			}
+		}
+		if !valid {
+			return c.question("The time should be \"hh:mm\" (e.g. \"07:30\")", nil, chatId), "", false
+		}
+		c.at = at
+		c.step = askRepeat
+		return c.repeatQuestion("How often? You can also send the days (e.g. \"mon wed fri\")", chatId), "", false
+	case askRepeat:
+		switch strings.ToLower(answer) {
+		case "once":
+			c.repeat, c.weekdays = false, nil
+		case "daily":
+			c.repeat, c.weekdays = true, nil
+		case "weekdays":
+			c.repeat, c.weekdays = true, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+		case "weekends":
+			c.repeat, c.weekdays = true, []time.Weekday{time.Saturday, time.Sunday}
+		default:
+			weekdays, err := types.WeekdaysFromString(strings.Join(strings.Fields(strings.Replace(answer, ",", " ", -1)), ","))
+			if err != nil {
+				return c.repeatQuestion(err.Error(), chatId), "", false
+			}
+			c.repeat, c.weekdays = true, weekdays
+		}
+		c.step = askConfirmation
+		return c.question(c.summary()+"?", []Button{Button{"Confirm", "confirm"}}, chatId), "", false
+	case askConfirmation:
+		if !strings.EqualFold(answer, "confirm") {
+			return c.question("Please confirm or cancel: "+c.summary(), []Button{Button{"Confirm", "confirm"}}, chatId), "", false
+		}
+		return Message{}, c.programmedAction(), true
+	}
+	return Message{}, "", true
+}
+
+func (c *conversation) question(text string, buttons []Button, chatId int64) Message {
+	keyboard := Keyboard{append(buttons, cancelButton)}
+	return Message{Text: text, ChatId: chatId, Keyboard: keyboard}
+}
+
+func (c *conversation) repeatQuestion(text string, chatId int64) Message {
+	keyboard := Keyboard{
+		[]Button{Button{"Once", "once"}, Button{"Every day", "daily"}},
+		[]Button{Button{"Weekdays", "weekdays"}, Button{"Weekends", "weekends"}},
+		[]Button{cancelButton},
+	}
+	return Message{Text: text, ChatId: chatId, Keyboard: keyboard}
+}
+
+// e.g. "Turn Light on at 07:30 on mon,tue,wed,thu,fri"
+func (c *conversation) summary() string {
+	result := "Turn " + c.pin
+	if c.state {
+		result += " on"
+	} else {
+		result += " off"
+	}
+	result += " at " + c.at.Format("15:04")
+	if !c.repeat {
+		result += " once"
+	} else if len(c.weekdays) > 0 {
+		result += " on " + types.WeekdaysToString(c.weekdays)
+	} else {
+		result += " every day"
+	}
+	return result
+}
+
+func (c *conversation) programmedAction() string {
+	programmedAction := types.ProgrammedAction{
+		Action:   types.Action{Pin: c.pin, State: c.state},
+		Repeat:   c.repeat,
+		Time:     types.MyTime(c.at),
+		Weekdays: c.weekdays,
+	}
+	return types.ProgrammedActionToString(programmedAction)
+}
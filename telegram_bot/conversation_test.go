@@ -0,0 +1,103 @@
+package telegram_bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversation(t *testing.T) {
+	now := time.Date(2020, 5, 3, 12, 0, 0, 0, time.Local)
+	pinAllowed := func(pin string) bool { return pin == "light" }
+	current, msg := newConversation(1, []string{"light"}, 1, now)
+	assert.Equal(t, msg.Text, "New programmed action. Which pin?")
+	assert.Equal(t, msg.Keyboard, Keyboard{[]Button{Button{"light", "light"}}, []Button{cancelButton}})
+
+	msg, _, finished := current.handle("boiler", pinAllowed, 1, now)
+	assert.False(t, finished)
+	assert.Equal(t, msg.Text, "You can not program boiler, which pin?")
+	msg, _, _ = current.handle("light", pinAllowed, 1, now)
+	assert.Equal(t, msg.Text, "Turn light on or off?")
+	msg, _, _ = current.handle("up", pinAllowed, 1, now)
+	assert.Equal(t, msg.Text, "Please answer \"on\" or \"off\"")
+	msg, _, _ = current.handle("on", pinAllowed, 1, now)
+	assert.Equal(t, msg.Text, "At what time? (hh:mm)")
+	msg, _, _ = current.handle("7.30", pinAllowed, 1, now)
+	assert.Equal(t, msg.Text, "The time should be \"hh:mm\" (e.g. \"07:30\")")
+	msg, _, _ = current.handle("07:30", pinAllowed, 1, now)
+	assert.Equal(t, len(msg.Keyboard), 3, "The repetitions should be offered as buttons")
+	msg, _, _ = current.handle("monday", pinAllowed, 1, now)
+	assert.Contains(t, msg.Text, "Day not valid")
+	msg, _, _ = current.handle("mon, wed fri", pinAllowed, 1, now)
+	assert.Equal(t, msg.Text, "Turn light on at 07:30 on mon,wed,fri?")
+	msg, programmedAction, finished := current.handle("confirm", pinAllowed, 1, now)
+	assert.True(t, finished)
+	assert.Equal(t, programmedAction, "light;true;true;07:30:00;days:mon,wed,fri")
+
+	current, _ = newConversation(1, nil, 1, now)
+	current.handle("light", pinAllowed, 1, now)
+	current.handle("off", pinAllowed, 1, now)
+	current.handle("23:00", pinAllowed, 1, now)
+	msg, _, _ = current.handle("once", pinAllowed, 1, now)
+	assert.Equal(t, msg.Text, "Turn light off at 23:00 once?")
+	_, programmedAction, _ = current.handle("confirm", pinAllowed, 1, now)
+	assert.Equal(t, programmedAction, "light;false;false;23:00:00")
+
+	assert.False(t, current.expired(now.Add(conversationTimeout-time.Second)))
+	assert.True(t, current.expired(now.Add(conversationTimeout)), "Conversations should expire when the user does not answer")
+}
+
+func TestTelegramBotConversation(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{TelegramAuthorizedUsers: []int{1, 2}}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, ProgrammedActions: operationsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "/start", UserId: 1, ChatId: 1}
+	<-telegramOutputChannel
+	telegramInputChannel <- types.TelegramMessage{"start light boiler", 1}
+	<-frontend.sent
+
+	frontend.commands <- Command{Text: "/new", UserId: 1, ChatId: 1}
+	msg := <-frontend.sent
+	assert.Equal(t, len(msg.Keyboard), 3, "The pins of the menu should be offered")
+	frontend.commands <- Command{Text: "light", UserId: 1, ChatId: 1, CallbackId: "a"}
+	<-frontend.answered
+	assert.Equal(t, (<-frontend.sent).Text, "Turn light on or off?")
+	frontend.commands <- Command{Text: "on", UserId: 2, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "Which pin should be turned on?", "Other users of the chat should not answer")
+	frontend.commands <- Command{Text: "on", UserId: 1, ChatId: 1}
+	<-frontend.sent
+	frontend.commands <- Command{Text: "07:30", UserId: 1, ChatId: 1}
+	<-frontend.sent
+	frontend.commands <- Command{Text: "daily", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "Turn light on at 07:30 every day?")
+	frontend.commands <- Command{Text: "confirm", UserId: 1, ChatId: 1}
+	operation := <-operationsChannel
+	assert.Equal(t, operation.Operation, int32(types.CREATE), "The programmed action should be created like the ones sent with CreateProgrammedAction")
+	assert.Equal(t, operation.ProgrammedAction.Action, types.Action{"light", true, 1})
+	assert.True(t, operation.ProgrammedAction.Repeat)
+
+	frontend.commands <- Command{Text: "/new", UserId: 1, ChatId: 1}
+	<-frontend.sent
+	frontend.commands <- Command{Text: "/cancel", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "Cancelled")
+	frontend.commands <- Command{Text: "/cancel", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "There is nothing to cancel")
+
+	frontend.commands <- Command{Text: "CreateProgrammedAction light;false;true;23:00:00", UserId: 1, ChatId: 1}
+	operation = <-operationsChannel
+	assert.Equal(t, operation.ProgrammedAction.Action, types.Action{"light", false, 1}, "The raw syntax should keep working")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
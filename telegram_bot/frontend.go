@@ -0,0 +1,55 @@
+package telegram_bot
+
+import "fmt"
+
+// Command is a message received from a chat
+type Command struct {
+	Text      string
+	UserId    int
+	ChatId    int64
+	MessageId int
+	// Set when the command comes from a button, MessageId is then the message with the button
+	CallbackId string
+}
+
+// Button shows Text and sends Data as a command when it is pressed
+type Button struct {
+	Text string
+	Data string
+}
+
+type Keyboard [][]Button
+
+// Message is a response to a chat, it is shown with a keyboard if it has one
+type Message struct {
+	Text   string
+	ChatId int64
+	// -1 (or 0) when the message does not reply to another one
+	ReplyToMessageId int
+	Keyboard         Keyboard
+}
+
+// Frontend is the chat system the bot talks through (telegram, a fake one in tests...)
+type Frontend interface {
+	// Commands are received until Stop is called
+	Commands() (<-chan Command, error)
+	SendText(chatId int64, text string, replyToMessageId int) error
+	SendKeyboard(chatId int64, text string, keyboard Keyboard) error
+	// Replaces the text of a message sent with a keyboard, keeping the keyboard
+	EditText(chatId int64, messageId int, text string, keyboard Keyboard) error
+	// Every command with CallbackId should be answered (text may be empty)
+	AnswerCallback(callbackId string, text string) error
+	Stop()
+}
+
+func sendMessage(frontend Frontend, msg Message) {
+	var err error
+	if len(msg.Keyboard) > 0 {
+		err = frontend.SendKeyboard(msg.ChatId, msg.Text, msg.Keyboard)
+	} else {
+		err = frontend.SendText(msg.ChatId, msg.Text, msg.ReplyToMessageId)
+	}
+	if err != nil {
+		fmt.Println("There was an error sending a message to the chat: ", err.Error())
+	}
+}
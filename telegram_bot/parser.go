@@ -0,0 +1,360 @@
+package telegram_bot
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+)
+
+// phraseParser understands phrases like "turn on kitchen light", "pump on for 10 minutes" or
+// "kitchen light off at 22:30 every weekday" and returns what they ask to do with the pin
+type phraseParser struct {
+	// Other names of the pins, with their words in lowercase
+	aliases map[string]string
+	// Phrases waiting for the user to choose one of their pins, by chat
+	ambiguous map[int64]ambiguousPhrase
+}
+
+type ambiguousPhrase struct {
+	phrase     phrase
+	candidates []string
+}
+
+// A phrase once its words are classified, name contains the words that are left and pin the pin they match
+type phrase struct {
+	name     []string
+	pin      string
+	state    bool
+	stateSet bool
+	at       *time.Time
+	duration time.Duration
+	delay    time.Duration
+	repeat   bool
+	weekdays []time.Weekday
+}
+
+var fillerWords = map[string]bool{"turn": true, "switch": true, "set": true, "put": true, "please": true, "the": true, "to": true}
+
+var weekdayWords = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+func newPhraseParser(aliases map[string]string) *phraseParser {
+	parser := phraseParser{aliases: make(map[string]string), ambiguous: make(map[int64]ambiguousPhrase)}
+	for alias, pin := range aliases {
+		parser.aliases[strings.Join(nameWords(alias), " ")] = pin
+	}
+	return &parser
+}
+
+// isPhrase reports if the text should be translated, commands and pin buttons are left as they are.
+// Pins can end like the buttons (e.g. "TurnOff on"), so only the buttons of known pins are commands
+func isPhrase(text string, pins []string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "/") {
+		return false
+	} else if pin, _, ok := pinButtonAction(fields[0]); ok && (len(pins) == 0 || contains(pins, pin)) {
+		return false
+	}
+	switch strings.ToLower(fields[0]) {
+	case "createprogrammedaction", "removeprogrammedaction", "updateprogrammedaction", "getprogrammedactions":
+		return false
+	}
+	return true
+}
+
+// isChoice reports if the text is one of the pins offered for the last ambiguous phrase of the chat,
+// pin names can look like commands (e.g. "TurnOff")
+func (p *phraseParser) isChoice(text string, chatId int64) bool {
+	ambiguous, ok := p.ambiguous[chatId]
+	return ok && contains(ambiguous.candidates, strings.TrimSpace(text))
+}
+
+// translate returns the phrase with the pin it matches, or a reply when the phrase is not complete or the pin is ambiguous.
+// Both are empty if the text does not look like an order (e.g. it does not say "on" or "off")
+func (p *phraseParser) translate(text string, pins []string, chatId int64) (*phrase, *Message) {
+	if ambiguous, ok := p.ambiguous[chatId]; ok {
+		delete(p.ambiguous, chatId)
+		if contains(ambiguous.candidates, strings.TrimSpace(text)) {
+			chosen := ambiguous.phrase
+			chosen.pin = strings.TrimSpace(text)
+			return &chosen, nil
+		}
+	}
+	parsed, reason := parsePhrase(text)
+	if !parsed.stateSet {
+		return nil, nil
+	} else if reason != "" {
+		msg := buildMessage(reason, chatId, -1)
+		return nil, &msg
+	} else if len(parsed.name) == 0 {
+		msg := buildMessage("Which pin should be turned "+stateName(parsed.state)+"?", chatId, -1)
+		return nil, &msg
+	}
+	candidates := p.matchPins(parsed.name, pins)
+	if len(candidates) == 0 {
+		msg := buildMessage("I do not know any pin called \""+strings.Join(parsed.name, " ")+"\"", chatId, -1)
+		return nil, &msg
+	} else if len(candidates) > 1 {
+		// Buttons only send the pin, commands with options could be too long for them
+		p.ambiguous[chatId] = ambiguousPhrase{phrase: parsed, candidates: candidates}
+		keyboard := Keyboard{}
+		for _, pin := range candidates {
+			keyboard = append(keyboard, []Button{Button{pin, pin}})
+		}
+		msg := Message{Text: "Which one? " + strings.Join(candidates, ", "), ChatId: chatId, Keyboard: keyboard}
+		return nil, &msg
+	}
+	parsed.pin = candidates[0]
+	return &parsed, nil
+}
+
+func parsePhrase(text string) (phrase, string) {
+	var result phrase
+	words := strings.Fields(strings.ToLower(strings.Replace(text, ",", " ", -1)))
+	for index := 0; index < len(words); index++ {
+		word := words[index]
+		next := ""
+		if index+1 < len(words) {
+			next = words[index+1]
+		}
+		switch {
+		case fillerWords[word]:
+		case word == "on" && result.stateSet:
+			// "on mondays", "on weekdays"...
+		case isWeekdayWord(word) && result.stateSet:
+			index = parseWeekdays(words, index, &result) - 1
+		case (word == "on" || word == "off") && !result.stateSet:
+			result.state, result.stateSet = word == "on", true
+		case word == "at":
+			at, valid := time.Time{}, false
+			for _, layout := range []string{"15:04", "15:04:05"} {
+				if parsed, err := time.Parse(layout, next); err == nil {
+					at, valid = parsed, true
+				}
+			}
+			if !valid {
+				return result, "The time should be \"hh:mm\" (e.g. \"at 22:30\")"
+			}
+			result.at = &at
+			index++
+		case word == "for" || word == "in":
+			duration, consumed := parseDuration(words[index+1:])
+			if consumed == 0 {
+				return result, "The duration should be a number and a unit (e.g. \"" + word + " 10 minutes\")"
+			} else if word == "for" {
+				result.duration = duration
+			} else {
+				result.delay = duration
+			}
+			index += consumed
+		case word == "daily" || (word == "every" && next == "day"):
+			result.repeat = true
+			if word == "every" {
+				index++
+			}
+		case word == "once":
+			result.repeat = false
+		case word == "every" && isWeekdayWord(next):
+			index = parseWeekdays(words, index+1, &result) - 1
+		case word == "weekdays" || (word == "every" && next == "weekday"):
+			result.repeat = true
+			result.weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+			if word == "every" {
+				index++
+			}
+		case word == "weekends" || (word == "every" && next == "weekend"):
+			result.repeat = true
+			result.weekdays = []time.Weekday{time.Saturday, time.Sunday}
+			if word == "every" {
+				index++
+			}
+		default:
+			result.name = append(result.name, word)
+		}
+	}
+	if result.duration != 0 && (!result.state || result.at != nil || result.delay != 0) {
+		return result, "Only \"on for [duration]\" can be used with a duration (e.g. \"pump on for 10 minutes\")"
+	} else if result.delay != 0 && result.at != nil {
+		return result, "Use \"in [duration]\" or \"at [time]\", not both"
+	} else if result.repeat && result.at == nil {
+		return result, "Repeated actions need a time (e.g. \"light off at 22:30 every day\")"
+	}
+	return result, ""
+}
+
+// Parses "10 minutes", "1 hour" or "90s" and returns the number of words used
+func parseDuration(words []string) (time.Duration, int) {
+	if len(words) == 0 {
+		return 0, 0
+	}
+	if duration, err := time.ParseDuration(words[0]); err == nil && duration > 0 {
+		return duration, 1
+	}
+	amount, err := strconv.Atoi(words[0])
+	if err != nil || amount <= 0 || len(words) < 2 {
+		return 0, 0
+	}
+	units := map[string]time.Duration{"second": time.Second, "seconds": time.Second, "sec": time.Second, "secs": time.Second,
+		"minute": time.Minute, "minutes": time.Minute, "min": time.Minute, "mins": time.Minute,
+		"hour": time.Hour, "hours": time.Hour}
+	unit, ok := units[words[1]]
+	if !ok {
+		return 0, 0
+	}
+	return time.Duration(amount) * unit, 2
+}
+
+func isWeekdayWord(word string) bool {
+	_, ok := weekdayFromWord(word)
+	return ok
+}
+
+// "mon", "monday" and "mondays" are monday
+func weekdayFromWord(word string) (time.Weekday, bool) {
+	for index, name := range weekdayWords {
+		if len(word) >= 3 && (strings.HasPrefix(name, word) || word == name+"s") {
+			return time.Weekday(index), true
+		}
+	}
+	return time.Sunday, false
+}
+
+// Reads days ("monday and friday", "mon wed fri"...) from words[index] and returns the index of the first word after them
+func parseWeekdays(words []string, index int, result *phrase) int {
+	result.repeat = true
+	result.weekdays = nil
+	for ; index < len(words); index++ {
+		if weekday, ok := weekdayFromWord(words[index]); ok {
+			result.weekdays = append(result.weekdays, weekday)
+		} else if words[index] != "and" {
+			break
+		}
+	}
+	sort.Slice(result.weekdays, func(i, j int) bool { return result.weekdays[i] < result.weekdays[j] })
+	return index
+}
+
+// programmedAction returns the programmed action of phrases with a time, the first run is the next time the clock shows it
+func (p phrase) programmedAction(chatId int64, now time.Time) types.ProgrammedAction {
+	date := time.Date(now.Year(), now.Month(), now.Day(), p.at.Hour(), p.at.Minute(), p.at.Second(), 0, now.Location())
+	for date.Before(now) {
+		date = date.Add(time.Hour * 24)
+	}
+	return types.ProgrammedAction{Action: types.Action{Pin: p.pin, State: p.state, ChatId: chatId}, Repeat: p.repeat, Time: types.MyTime(date), Weekdays: p.weekdays}
+}
+
+// matchPins returns the pins whose name or alias matches the words, the best matches only
+func (p *phraseParser) matchPins(words []string, pins []string) []string {
+	names := make(map[string]string)
+	for _, pin := range pins {
+		names[strings.Join(nameWords(pin), " ")] = pin
+	}
+	// Aliases of other pins are left out, the user can not change them
+	for alias, pin := range p.aliases {
+		if contains(pins, pin) {
+			names[alias] = pin
+		}
+	}
+	wanted := strings.Join(words, " ")
+	bestScore := 0
+	var result []string
+	for name, pin := range names {
+		score := matchScore(words, wanted, strings.Fields(name), name)
+		if score > bestScore {
+			bestScore, result = score, nil
+		}
+		if score == bestScore && score > 0 && !contains(result, pin) {
+			result = append(result, pin)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 3 for the same name, 2 when every word is in the name ("light" in "kitchen light"), 1 for typos
+func matchScore(words []string, wanted string, nameWords []string, name string) int {
+	if wanted == name {
+		return 3
+	}
+	exact, fuzzy := true, true
+	for _, word := range words {
+		found, foundFuzzy := false, false
+		for _, nameWord := range nameWords {
+			found = found || word == nameWord
+			foundFuzzy = foundFuzzy || word == nameWord || (len(word) > 3 && levenshtein(word, nameWord) <= 1)
+		}
+		exact = exact && found
+		fuzzy = fuzzy && foundFuzzy
+	}
+	if exact {
+		return 2
+	} else if fuzzy || levenshtein(wanted, name) <= len(name)/4 {
+		return 1
+	}
+	return 0
+}
+
+// Words of a pin name in lowercase ("kitchenLight", "kitchen_light" and "Kitchen light" are "kitchen light")
+func nameWords(name string) []string {
+	var builder strings.Builder
+	var previous rune
+	for _, character := range name {
+		if character == '_' || character == '-' {
+			character = ' '
+		} else if unicode.IsUpper(character) && (unicode.IsLower(previous) || unicode.IsDigit(previous)) {
+			builder.WriteRune(' ')
+		} else if unicode.IsDigit(character) && unicode.IsLetter(previous) {
+			builder.WriteRune(' ')
+		}
+		builder.WriteRune(unicode.ToLower(character))
+		previous = character
+	}
+	return strings.Fields(builder.String())
+}
+
+// Edit distance between a and b, counting swapped letters as one edit
+func levenshtein(a string, b string) int {
+	first, second := []rune(a), []rune(b)
+	var beforePrevious []int
+	previous := make([]int, len(second)+1)
+	for index := range previous {
+		previous[index] = index
+	}
+	for i := 1; i <= len(first); i++ {
+		current := make([]int, len(second)+1)
+		current[0] = i
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+			current[j] = minimum(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			// Swapped letters ("pmup") are a single typo
+			if i > 1 && j > 1 && first[i-1] == second[j-2] && first[i-2] == second[j-1] {
+				current[j] = minimum(current[j], beforePrevious[j-2]+1)
+			}
+		}
+		beforePrevious, previous = previous, current
+	}
+	return previous[len(second)]
+}
+
+func minimum(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
+
+func stateName(state bool) string {
+	if state {
+		return "on"
+	}
+	return "off"
+}
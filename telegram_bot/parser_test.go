@@ -0,0 +1,198 @@
+package telegram_bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslatePhrases(t *testing.T) {
+	parser := newPhraseParser(map[string]string{"Kitchen light": "light1"})
+	pins := []string{"light1", "bedroomLight", "pump"}
+	translate := func(text string) string {
+		order, reply := parser.translate(text, pins, 1)
+		if reply != nil {
+			return reply.Text
+		} else if order == nil {
+			return ""
+		}
+		return orderToString(*order)
+	}
+	assert.Equal(t, translate("turn on kitchen light"), "light1 on")
+	assert.Equal(t, translate("Switch the bedroom light off"), "bedroomLight off", "Pin names should be split in words")
+	assert.Equal(t, translate("pump on for 10 minutes"), "pump on for 10m0s")
+	assert.Equal(t, translate("pump off in 1 hour"), "pump off in 1h0m0s")
+	assert.Equal(t, translate("turn on the pmup"), "pump on", "Typos should be corrected")
+	assert.Equal(t, translate("kitchen light off at 22:30 every weekday"), "light1 off at 22:30:00 on mon,tue,wed,thu,fri")
+	assert.Equal(t, translate("pump on at 7:00 on mondays and fridays"), "pump on at 07:00:00 on mon,fri")
+	assert.Equal(t, translate("pump on at 07:00 daily"), "pump on at 07:00:00 daily")
+	assert.Equal(t, translate("pump on at 07:00"), "pump on at 07:00:00")
+
+	assert.Equal(t, translate("hello"), "", "Texts without on or off should not be translated")
+	assert.Equal(t, translate("turn on the garage"), "I do not know any pin called \"garage\"")
+	assert.Equal(t, translate("turn off"), "Which pin should be turned off?")
+	assert.Equal(t, translate("pump on every day"), "Repeated actions need a time (e.g. \"light off at 22:30 every day\")")
+	assert.Equal(t, translate("pump off for 10 minutes"), "Only \"on for [duration]\" can be used with a duration (e.g. \"pump on for 10 minutes\")")
+	assert.Equal(t, translate("pump on for a while"), "The duration should be a number and a unit (e.g. \"for 10 minutes\")")
+
+	order, reply := parser.translate("light off at 23:00", pins, 1)
+	assert.Nil(t, order)
+	require.NotNil(t, reply, "Ambiguous pins should be clarified")
+	assert.Equal(t, reply.Text, "Which one? bedroomLight, light1")
+	assert.Equal(t, reply.Keyboard, Keyboard{[]Button{Button{"bedroomLight", "bedroomLight"}}, []Button{Button{"light1", "light1"}}})
+	assert.True(t, parser.isChoice("bedroomLight", 1))
+	assert.False(t, parser.isChoice("bedroomLight", 2))
+	assert.Equal(t, translate("bedroomLight"), "bedroomLight off at 23:00:00", "The chosen pin should complete the phrase")
+	assert.Equal(t, translate("bedroomLight"), "", "Phrases should only be completed once")
+
+	assert.False(t, isPhrase("lightOn", nil))
+	assert.False(t, isPhrase("lightOn", []string{"light"}))
+	assert.False(t, isPhrase("/agenda", nil))
+	assert.False(t, isPhrase("CreateProgrammedAction light;true;true;07:30:00", nil))
+	assert.True(t, isPhrase("turn on the light", nil))
+	assert.True(t, isPhrase("TurnOff on", []string{"TurnOff"}), "Pins that end like the buttons should be understood")
+	assert.False(t, isPhrase("TurnOffOn", []string{"TurnOff"}))
+}
+
+func TestTranslatePhrasesWithAliasesOfOtherPins(t *testing.T) {
+	parser := newPhraseParser(map[string]string{"kitchen light": "light1", "garden light": "light2"})
+	order, reply := parser.translate("garden light on", []string{"light1"}, 1)
+	assert.Nil(t, order, "Aliases of pins that are not allowed should not be matched")
+	require.NotNil(t, reply)
+	assert.Equal(t, reply.Text, "I do not know any pin called \"garden light\"")
+
+	order, reply = parser.translate("light on", []string{"light1"}, 1)
+	assert.Nil(t, reply, "Aliases of pins that are not allowed should not make the phrase ambiguous")
+	require.NotNil(t, order)
+	assert.Equal(t, order.pin, "light1")
+}
+
+func TestPhraseProgrammedAction(t *testing.T) {
+	parser := newPhraseParser(nil)
+	order, _ := parser.translate("pump on at 07:00 daily", []string{"pump"}, 1)
+	require.NotNil(t, order)
+	now := time.Date(2020, 5, 3, 21, 0, 0, 0, time.Local)
+	programmedAction := order.programmedAction(1, now)
+	assert.Equal(t, programmedAction.Action, types.Action{"pump", true, 1})
+	assert.True(t, programmedAction.Repeat)
+	assert.Equal(t, time.Time(programmedAction.Time), time.Date(2020, 5, 4, 7, 0, 0, 0, time.Local), "Times already past today should run tomorrow")
+}
+
+// orderToString describes the orders in the tests, e.g. "pump on for 10m0s" or "light off at 22:30:00 daily"
+func orderToString(order phrase) string {
+	result := order.pin + " " + stateName(order.state)
+	if order.duration != 0 {
+		result += " for " + order.duration.String()
+	} else if order.delay != 0 {
+		result += " in " + order.delay.String()
+	} else if order.at != nil {
+		result += " at " + order.at.Format("15:04:05")
+	}
+	if len(order.weekdays) > 0 {
+		result += " on " + types.WeekdaysToString(order.weekdays)
+	} else if order.repeat {
+		result += " daily"
+	}
+	return result
+}
+
+func TestTelegramBotPhrases(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.PinsActive = []types.PairNamePin{types.PairNamePin{"kitchenLight", 18}, types.PairNamePin{"waterPump", 23}}
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{
+		TelegramUsers: []configuration_loader.TelegramUser{configuration_loader.TelegramUser{Id: 1, Role: configuration_loader.VIEWER_ROLE}},
+		PinAliases:    map[string]string{"pump": "waterPump"},
+	}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, ProgrammedActions: operationsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "turn on the kitchen light", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "Viewers can only query the status, \"turn on the kitchen light\" not allowed", "Phrases should be authorized like the commands on their pin")
+	telegramExitChannel <- true
+	<-telegramExitChannel
+
+	config.ServerConfiguration.TelegramUsers[0].Role = configuration_loader.ADMIN_ROLE
+	err = LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, ProgrammedActions: operationsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+	frontend.commands <- Command{Text: "turn on the kitchen light", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"kitchenLight", true, 1})
+	frontend.commands <- Command{Text: "pump on for 5 minutes", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"waterPump", true, 1}, "Aliases should be understood")
+	operation := <-operationsChannel
+	assert.True(t, operation.ProgrammedAction.Timer)
+	frontend.commands <- Command{Text: "kitchenLightOff", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"kitchenLight", false, 1}, "Commands should keep working")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
+
+func TestTelegramBotPhrasesWithPinsLikeCommands(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.PinsActive = []types.PairNamePin{types.PairNamePin{"TurnOff", 18}, types.PairNamePin{"lampOn", 23}, types.PairNamePin{"lampOff", 24}}
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{
+		TelegramUsers:       []configuration_loader.TelegramUser{configuration_loader.TelegramUser{Id: 1, Role: configuration_loader.ADMIN_ROLE}},
+		RequireConfirmation: []string{"lampOn"},
+	}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, ProgrammedActions: operationsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "TurnOff on", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"TurnOff", true, 1}, "Phrases should not be read as commands")
+	frontend.commands <- Command{Text: "TurnOff off in 5 minutes", UserId: 1, ChatId: 1}
+	operation := <-operationsChannel
+	assert.True(t, operation.ProgrammedAction.Timer)
+	assert.Equal(t, operation.ProgrammedAction.Action, types.Action{"TurnOff", false, 1})
+	frontend.commands <- Command{Text: "TurnOffOn", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"TurnOff", true, 1}, "The buttons of the pin should keep working")
+
+	frontend.commands <- Command{Text: "lamp on", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "Which one? lampOff, lampOn")
+	frontend.commands <- Command{Text: "lampOff", UserId: 1, ChatId: 1, CallbackId: "a"}
+	assert.Equal(t, <-frontend.answered, "a:")
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"lampOff", true, 1}, "The chosen pin should not be read as a command")
+
+	frontend.commands <- Command{Text: "lampOn off", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-frontend.sent, confirmationMessage("lampOn off", "lampOn", 1), "Phrases should be confirmed like the commands on their pin")
+	frontend.commands <- Command{Text: "/confirm", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"lampOn", false, 1})
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
+
+func TestTelegramBotPhrasesOnlyMatchControllablePins(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.PinsActive = []types.PairNamePin{types.PairNamePin{"kitchenLight", 18}, types.PairNamePin{"bedroomLight", 23}}
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{
+		TelegramUsers: []configuration_loader.TelegramUser{configuration_loader.TelegramUser{Id: 1, Role: configuration_loader.OPERATOR_ROLE, Pins: []string{"kitchenLight"}}},
+	}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "light on", UserId: 1, ChatId: 1}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"kitchenLight", true, 1}, "Pins the user can not change should not be candidates")
+	frontend.commands <- Command{Text: "turn on the bedroom light", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "I do not know any pin called \"bedroom light\"")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
@@ -0,0 +1,343 @@
+package telegram_bot
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/file_storage"
+)
+
+type permissions struct {
+	// Users of the configuration file, they can not be revoked
+	users map[int]configuration_loader.TelegramUser
+	// Users that joined with an invitation code, stored in joinedUsersPath
+	joinedUsers     map[int]configuration_loader.TelegramUser
+	joinedUsersPath string
+	invitations     map[string]invitation
+	// Resolves the node of the pins for the users allowed in whole nodes
+	nodeOfPin func(pin string) string
+}
+
+type invitation struct {
+	role       string
+	expiration time.Time
+}
+
+const invitationCodeLength = 8
+const defaultInvitationDuration = 24 * time.Hour
+
+func newPermissions(config configuration_loader.ServerConfiguration, nodeOfPin func(pin string) string) *permissions {
+	result := permissions{
+		users:       make(map[int]configuration_loader.TelegramUser),
+		joinedUsers: make(map[int]configuration_loader.TelegramUser),
+		invitations: make(map[string]invitation),
+		nodeOfPin:   nodeOfPin,
+	}
+	for _, id := range config.TelegramAuthorizedUsers {
+		result.users[id] = configuration_loader.TelegramUser{Id: id, Role: configuration_loader.ADMIN_ROLE}
+	}
+	for _, user := range config.TelegramUsers {
+		result.users[user.Id] = user
+	}
+	return &result
+}
+
+// loadJoinedUsers reads the users that joined with invitation codes from path (if it exists) and stores them there from now on
+func (p *permissions) loadJoinedUsers(path string) error {
+	p.joinedUsersPath = path
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("[telegram_bot]: Could not read the telegram users: " + err.Error())
+	}
+	var users []configuration_loader.TelegramUser
+	err = json.Unmarshal(content, &users)
+	if err != nil {
+		return errors.New("[telegram_bot]: Telegram users file not valid: " + err.Error())
+	}
+	for _, user := range users {
+		if _, inConfiguration := p.users[user.Id]; !inConfiguration {
+			p.joinedUsers[user.Id] = user
+		}
+	}
+	return nil
+}
+
+func (p *permissions) storeJoinedUsers() error {
+	if p.joinedUsersPath == "" {
+		return nil
+	}
+	users := make([]configuration_loader.TelegramUser, 0, len(p.joinedUsers))
+	for _, user := range p.joinedUsers {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	err := file_storage.StoreJSON(p.joinedUsersPath, users)
+	if err != nil {
+		return errors.New("[telegram_bot]: Could not store the telegram users: " + err.Error())
+	}
+	return nil
+}
+
+func (p *permissions) user(id int) (configuration_loader.TelegramUser, bool) {
+	if user, ok := p.users[id]; ok {
+		return user, ok
+	}
+	user, ok := p.joinedUsers[id]
+	return user, ok
+}
+
+// invite handles "/invite role [duration]", the code can be used once before it expires
+func (p *permissions) invite(fields []string, now time.Time) string {
+	wrongFormat := "Wrong format: \"/invite admin|operator|viewer [duration]\" (e.g. \"/invite operator 24h\")"
+	if len(fields) < 2 || len(fields) > 3 {
+		return wrongFormat
+	}
+	role := strings.ToLower(fields[1])
+	if role != configuration_loader.ADMIN_ROLE && role != configuration_loader.OPERATOR_ROLE && role != configuration_loader.VIEWER_ROLE {
+		return wrongFormat
+	}
+	duration := defaultInvitationDuration
+	if len(fields) == 3 {
+		var err error
+		duration, err = time.ParseDuration(fields[2])
+		if err != nil || duration <= 0 {
+			return wrongFormat
+		}
+	}
+	p.removeExpiredInvitations(now)
+	code := newInvitationCode()
+	expiration := now.Add(duration)
+	p.invitations[code] = invitation{role: role, expiration: expiration}
+	return "Invitation code for a new " + role + ": " + code + " (valid until " + expiration.Format("2006-01-02 15:04") + "). The new user should send \"/join " + code + "\" to the bot"
+}
+
+// join redeems an invitation code for userId
+func (p *permissions) join(userId int, fields []string, now time.Time) string {
+	if _, ok := p.user(userId); ok {
+		return "You are already authorized"
+	} else if len(fields) != 2 {
+		return "Wrong format: \"/join CODE\""
+	}
+	p.removeExpiredInvitations(now)
+	code := strings.ToUpper(fields[1])
+	invitation, ok := p.invitations[code]
+	if !ok {
+		return "Invitation code not valid"
+	}
+	delete(p.invitations, code)
+	p.joinedUsers[userId] = configuration_loader.TelegramUser{Id: userId, Role: invitation.role}
+	if err := p.storeJoinedUsers(); err != nil {
+		fmt.Println("The telegram users could not be stored: " + err.Error())
+	}
+	return "Welcome! You can use the bot as " + invitation.role + ", send /start to see the menu"
+}
+
+// usersDescription lists the users and the invitations that were not used yet
+func (p *permissions) usersDescription(now time.Time) string {
+	var lines []string
+	ids := make([]int, 0, len(p.users)+len(p.joinedUsers))
+	for id := range p.users {
+		ids = append(ids, id)
+	}
+	for id := range p.joinedUsers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		user, _ := p.user(id)
+		line := strconv.Itoa(id) + ": " + user.Role
+		if len(user.Pins) > 0 {
+			line += ", pins: " + strings.Join(user.Pins, " ")
+		}
+		if len(user.Nodes) > 0 {
+			line += ", nodes: " + strings.Join(user.Nodes, " ")
+		}
+		if _, inConfiguration := p.users[id]; inConfiguration {
+			line += " (configuration file)"
+		}
+		lines = append(lines, line)
+	}
+	p.removeExpiredInvitations(now)
+	codes := make([]string, 0, len(p.invitations))
+	for code := range p.invitations {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		lines = append(lines, "Invitation "+code+": "+p.invitations[code].role+" until "+p.invitations[code].expiration.Format("2006-01-02 15:04"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// revoke handles "/revoke id|code", users of the configuration file can not be revoked
+// revoke returns the answer for the admin and the id of the user revoked (0 when no user was revoked)
+func (p *permissions) revoke(fields []string) (string, int) {
+	if len(fields) != 2 {
+		return "Wrong format: \"/revoke user_id|invitation_code\"", 0
+	}
+	if _, ok := p.invitations[strings.ToUpper(fields[1])]; ok {
+		delete(p.invitations, strings.ToUpper(fields[1]))
+		return "Invitation " + strings.ToUpper(fields[1]) + " revoked", 0
+	}
+	id, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "Wrong format: \"/revoke user_id|invitation_code\"", 0
+	}
+	if _, inConfiguration := p.users[id]; inConfiguration {
+		return "User " + fields[1] + " is in the configuration file, it can not be revoked", 0
+	} else if _, ok := p.joinedUsers[id]; !ok {
+		return "User " + fields[1] + " not found", 0
+	}
+	delete(p.joinedUsers, id)
+	if err := p.storeJoinedUsers(); err != nil {
+		fmt.Println("The telegram users could not be stored: " + err.Error())
+	}
+	return "User " + fields[1] + " revoked", id
+}
+
+func (p *permissions) removeExpiredInvitations(now time.Time) {
+	for code, invitation := range p.invitations {
+		if !now.Before(invitation.expiration) {
+			delete(p.invitations, code)
+		}
+	}
+}
+
+// Codes only use letters, so they can not be confused with user ids in /revoke
+func newInvitationCode() string {
+	const letters = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	code := make([]byte, invitationCodeLength)
+	rand.Read(code)
+	for index := range code {
+		code[index] = letters[int(code[index])%len(letters)]
+	}
+	return string(code)
+}
+
+func (p *permissions) pinAllowed(user configuration_loader.TelegramUser, pin string) bool {
+	if len(user.Pins) == 0 && len(user.Nodes) == 0 {
+		return true
+	}
+	for _, allowed := range user.Pins {
+		if allowed == pin {
+			return true
+		}
+	}
+	if p.nodeOfPin != nil {
+		node := p.nodeOfPin(pin)
+		for _, allowed := range user.Nodes {
+			if node != "" && allowed == node {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Pins the user can change, in the same order
+func (p *permissions) controllablePins(user configuration_loader.TelegramUser, pins []string) []string {
+	var result []string
+	if user.Role == configuration_loader.VIEWER_ROLE {
+		return result
+	}
+	for _, pin := range pins {
+		if p.pinAllowed(user, pin) {
+			result = append(result, pin)
+		}
+	}
+	return result
+}
+
+// Returns the reason why the user can not send the command, empty if it is allowed
+func (p *permissions) checkCommand(user configuration_loader.TelegramUser, fields []string) string {
+	action := fields[0]
+	switch strings.ToLower(action) {
+	case "/start", "getprogrammedactions", "/agenda", "/timers", "/check", "/status":
+		return ""
+	case "/history":
+		pin := ""
+		for _, field := range fields[1:] {
+			if !isHistoryDate(field) {
+				pin = field
+				break
+			}
+		}
+		// The server only shows the executions of the pins the user can see
+		if pin != "" && !p.pinAllowed(user, pin) {
+			return "You are not allowed to see " + pin
+		}
+		return ""
+	case "/subscribe", "/unsubscribe":
+		// The server only notifies the events of the pins the user can see
+		if len(fields) == 3 && strings.EqualFold(fields[1], "pin") && !p.pinAllowed(user, fields[2]) {
+			return "You are not allowed to see " + fields[2]
+		} else if len(fields) == 3 && strings.EqualFold(fields[1], "node") && (len(user.Pins) > 0 || len(user.Nodes) > 0) && !contains(user.Nodes, fields[2]) {
+			return "You are not allowed to see node " + fields[2]
+		}
+		return ""
+	case "/mute":
+		return ""
+	case "/sequence", "/override":
+		if len(fields) == 1 {
+			return ""
+		}
+		return requireRole(user, configuration_loader.ADMIN_ROLE, action)
+	case "/vacation", "/replay", "/raindelay", "/invite", "/users", "/revoke", "/audit":
+		return requireRole(user, configuration_loader.ADMIN_ROLE, action)
+	case "/remind", "/new", "createprogrammedaction", "updateprogrammedaction", "removeprogrammedaction":
+		// The server checks the pins of the programmed actions
+		return requireRole(user, configuration_loader.OPERATOR_ROLE, action)
+	}
+	if pin, _, ok := pinButtonAction(action); ok {
+		return p.checkPin(user, pin, action)
+	}
+	return ""
+}
+
+// Returns the reason why the user can not change the pin with the command, empty if it is allowed
+func (p *permissions) checkPin(user configuration_loader.TelegramUser, pin string, command string) string {
+	if reason := requireRole(user, configuration_loader.OPERATOR_ROLE, command); reason != "" {
+		return reason
+	} else if !p.pinAllowed(user, pin) {
+		return "You are not allowed to change " + pin
+	}
+	return ""
+}
+
+func requireRole(user configuration_loader.TelegramUser, role string, action string) string {
+	if user.Role == configuration_loader.ADMIN_ROLE || user.Role == role {
+		return ""
+	}
+	if role == configuration_loader.ADMIN_ROLE {
+		return "Only admins can use " + action
+	}
+	return "Viewers can only query the status, " + action + " not allowed"
+}
+
+func isHistoryDate(field string) bool {
+	if strings.EqualFold(field, "today") || strings.EqualFold(field, "yesterday") {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", field)
+	return err == nil
+}
+
+func contains(values []string, value string) bool {
+	for _, current := range values {
+		if current == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package telegram_bot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPermissions() *permissions {
+	config := configuration_loader.ServerConfiguration{
+		TelegramAuthorizedUsers: []int{1},
+		TelegramUsers: []configuration_loader.TelegramUser{
+			configuration_loader.TelegramUser{Id: 2, Role: configuration_loader.OPERATOR_ROLE, Pins: []string{"lamp"}},
+			configuration_loader.TelegramUser{Id: 3, Role: configuration_loader.VIEWER_ROLE},
+			configuration_loader.TelegramUser{Id: 4, Role: configuration_loader.OPERATOR_ROLE, Nodes: []string{"garden"}},
+		},
+	}
+	return newPermissions(config, func(pin string) string {
+		if pin == "water" {
+			return "garden"
+		}
+		return "living"
+	})
+}
+
+func TestCheckCommand(t *testing.T) {
+	permissions := testPermissions()
+	admin, ok := permissions.user(1)
+	require.True(t, ok, "TelegramAuthorizedUsers should be admins")
+	assert.Equal(t, admin.Role, configuration_loader.ADMIN_ROLE)
+	kid, _ := permissions.user(2)
+	viewer, _ := permissions.user(3)
+	gardener, _ := permissions.user(4)
+	_, ok = permissions.user(5)
+	assert.False(t, ok)
+
+	check := func(user configuration_loader.TelegramUser, command string) string {
+		return permissions.checkCommand(user, strings.Fields(command))
+	}
+	assert.Equal(t, check(admin, "boilerOn"), "")
+	assert.Equal(t, check(admin, "/vacation on"), "")
+	assert.Equal(t, check(kid, "lampOn"), "")
+	assert.Equal(t, check(kid, "lampOnAndOff 30m"), "")
+	assert.Equal(t, check(kid, "boilerOn"), "You are not allowed to change boiler")
+	assert.Equal(t, check(kid, "/vacation on"), "Only admins can use /vacation")
+	assert.Equal(t, check(kid, "/sequence"), "", "Status queries should be allowed")
+	assert.Equal(t, check(kid, "/sequence run garden"), "Only admins can use /sequence")
+	assert.Equal(t, check(kid, "/history lamp today"), "")
+	assert.Equal(t, check(kid, "/history today boiler"), "You are not allowed to see boiler")
+	assert.Equal(t, check(kid, "/history"), "", "The server filters the history of users with allow lists")
+	assert.Equal(t, check(kid, "RemoveProgrammedAction 1a2b3c"), "", "The server checks the pins of the programmed actions")
+	assert.Equal(t, check(viewer, "lampOn"), "Viewers can only query the status, lampOn not allowed")
+	assert.NotEqual(t, check(viewer, "RemoveProgrammedAction 1a2b3c"), "")
+	assert.Equal(t, check(viewer, "/agenda"), "")
+	assert.Equal(t, check(viewer, "/history"), "")
+	assert.Equal(t, check(viewer, "/subscribe all"), "", "Viewers should be able to subscribe")
+	assert.Equal(t, check(kid, "/subscribe pin lamp"), "")
+	assert.Equal(t, check(kid, "/subscribe pin boiler"), "You are not allowed to see boiler")
+	assert.Equal(t, check(kid, "/subscribe node garden"), "You are not allowed to see node garden")
+	assert.Equal(t, check(gardener, "/subscribe node garden"), "")
+	assert.Equal(t, check(kid, "/mute 2h"), "")
+	assert.Equal(t, check(gardener, "waterOn"), "", "Users allowed in a node can change its pins")
+	assert.NotEqual(t, check(gardener, "lampOn"), "")
+
+	assert.Equal(t, permissions.controllablePins(admin, []string{"lamp", "boiler", "water"}), []string{"lamp", "boiler", "water"})
+	assert.Equal(t, permissions.controllablePins(kid, []string{"lamp", "boiler", "water"}), []string{"lamp"})
+	assert.Equal(t, permissions.controllablePins(gardener, []string{"lamp", "boiler", "water"}), []string{"water"})
+	assert.Nil(t, permissions.controllablePins(viewer, []string{"lamp", "boiler", "water"}), "Viewers can not change any pin")
+}
+
+func TestTelegramBotPermissions(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{
+		TelegramUsers: []configuration_loader.TelegramUser{configuration_loader.TelegramUser{Id: 2, Role: configuration_loader.OPERATOR_ROLE, Pins: []string{"lamp"}}},
+	}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, ProgrammedActions: operationsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "boilerOn", UserId: 2, ChatId: 2, MessageId: 4}
+	assert.Equal(t, <-frontend.sent, Message{Text: "You are not allowed to change boiler", ChatId: 2, ReplyToMessageId: 4})
+	frontend.commands <- Command{Text: "boilerOff", UserId: 2, ChatId: 2, MessageId: 5, CallbackId: "a"}
+	assert.Equal(t, <-frontend.answered, "a:You are not allowed to change boiler", "Buttons should be checked too")
+	frontend.commands <- Command{Text: "lampOn", UserId: 2, ChatId: 2}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"lamp", true, 2})
+
+	frontend.commands <- Command{Text: "/start", UserId: 2, ChatId: 2}
+	<-telegramOutputChannel
+	telegramInputChannel <- types.TelegramMessage{"start lamp boiler", 2}
+	msg := <-frontend.sent
+	assert.Equal(t, len(msg.Keyboard), 3, "The menu should only show the pins the user can change")
+	assert.Equal(t, msg.Keyboard[2][0].Data, "lampOn")
+
+	frontend.commands <- Command{Text: "RemoveProgrammedAction 1a2b3c", UserId: 2, ChatId: 2}
+	operation := <-operationsChannel
+	assert.Equal(t, operation.AllowedPins, []string{"lamp"}, "The server should receive the pins the user can change")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
+
+func TestInvitations(t *testing.T) {
+	directory, err := ioutil.TempDir("", "telegram_users")
+	require.Nil(t, err)
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "telegram_users.json")
+	permissions := testPermissions()
+	require.Nil(t, permissions.loadJoinedUsers(path), "A missing file should not be an error")
+	now := time.Date(2020, 5, 3, 12, 0, 0, 0, time.Local)
+
+	assert.Contains(t, permissions.invite(strings.Fields("/invite owner"), now), "Wrong format")
+	assert.Contains(t, permissions.invite(strings.Fields("/invite operator tomorrow"), now), "Wrong format")
+	response := permissions.invite(strings.Fields("/invite operator 24h"), now)
+	require.Equal(t, len(permissions.invitations), 1)
+	var code string
+	for code = range permissions.invitations {
+	}
+	assert.Equal(t, response, "Invitation code for a new operator: "+code+" (valid until 2020-05-04 12:00). The new user should send \"/join "+code+"\" to the bot")
+
+	assert.Equal(t, permissions.join(1, []string{"/join", code}, now), "You are already authorized")
+	assert.Equal(t, permissions.join(10, []string{"/join", "WRONGCODE"}, now), "Invitation code not valid")
+	assert.Equal(t, permissions.join(10, []string{"/join", strings.ToLower(code)}, now.Add(time.Hour)), "Welcome! You can use the bot as operator, send /start to see the menu")
+	user, ok := permissions.user(10)
+	assert.True(t, ok)
+	assert.Equal(t, user.Role, configuration_loader.OPERATOR_ROLE)
+	assert.Equal(t, permissions.join(11, []string{"/join", code}, now), "Invitation code not valid", "Codes should only be used once")
+
+	permissions.invite(strings.Fields("/invite viewer 1h"), now)
+	for code = range permissions.invitations {
+	}
+	assert.Equal(t, permissions.join(11, []string{"/join", code}, now.Add(time.Hour)), "Invitation code not valid", "Expired codes should not be valid")
+
+	permissions.invite(strings.Fields("/invite viewer 1h"), now)
+	for code = range permissions.invitations {
+	}
+	assert.Equal(t, permissions.usersDescription(now), "1: admin (configuration file)\n2: operator, pins: lamp (configuration file)\n3: viewer (configuration file)\n4: operator, nodes: garden (configuration file)\n10: operator\nInvitation "+code+": viewer until 2020-05-03 13:00")
+	response, revoked := permissions.revoke([]string{"/revoke", code})
+	assert.Equal(t, response, "Invitation "+code+" revoked")
+	assert.Equal(t, revoked, 0)
+	assert.Equal(t, len(permissions.invitations), 0)
+
+	loaded := testPermissions()
+	require.Nil(t, loaded.loadJoinedUsers(path))
+	_, ok = loaded.user(10)
+	assert.True(t, ok, "Joined users should be stored")
+
+	response, revoked = permissions.revoke([]string{"/revoke", "1"})
+	assert.Equal(t, response, "User 1 is in the configuration file, it can not be revoked")
+	assert.Equal(t, revoked, 0)
+	response, _ = permissions.revoke([]string{"/revoke", "12"})
+	assert.Equal(t, response, "User 12 not found")
+	response, revoked = permissions.revoke([]string{"/revoke", "10"})
+	assert.Equal(t, response, "User 10 revoked")
+	assert.Equal(t, revoked, 10, "The user revoked should be returned to remove its subscriptions")
+	_, ok = permissions.user(10)
+	assert.False(t, ok)
+	loaded = testPermissions()
+	require.Nil(t, loaded.loadJoinedUsers(path))
+	_, ok = loaded.user(10)
+	assert.False(t, ok, "Revoked users should be removed from the stored users")
+
+	admin, _ := permissions.user(1)
+	operator, _ := permissions.user(2)
+	assert.Equal(t, permissions.checkCommand(admin, []string{"/invite", "viewer"}), "")
+	assert.Equal(t, permissions.checkCommand(operator, []string{"/invite", "viewer"}), "Only admins can use /invite")
+	assert.Equal(t, permissions.checkCommand(operator, []string{"/users"}), "Only admins can use /users")
+	assert.Equal(t, permissions.checkCommand(admin, []string{"/audit", "pin", "lamp"}), "")
+	assert.Equal(t, permissions.checkCommand(operator, []string{"/audit"}), "Only admins can use /audit")
+}
+
+func TestTelegramBotJoin(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	subscriptionRequestsChannel := make(chan types.SubscriptionRequest)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{TelegramAuthorizedUsers: []int{1}}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, SubscriptionRequests: subscriptionRequestsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "lampOn", UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-frontend.sent).Text, "User not authorized :(")
+	frontend.commands <- Command{Text: "/invite viewer 2h", UserId: 1, ChatId: 1}
+	invitation := <-frontend.sent
+	code := strings.Fields(invitation.Text)[6]
+	frontend.commands <- Command{Text: "/join " + code, UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-frontend.sent).Text, "Welcome! You can use the bot as viewer, send /start to see the menu")
+	frontend.commands <- Command{Text: "lampOn", UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-frontend.sent).Text, "Viewers can only query the status, lampOn not allowed", "Joined users should have the role of the invitation")
+	frontend.commands <- Command{Text: "/revoke 2", UserId: 1, ChatId: 1}
+	assert.Equal(t, (<-frontend.sent).Text, "User 2 revoked")
+	assert.Equal(t, <-subscriptionRequestsChannel, types.SubscriptionRequest{Operation: types.SUBSCRIPTION_REVOKE, ChatId: 1, UserId: 2}, "The subscriptions of revoked users should be removed")
+	frontend.commands <- Command{Text: "/start", UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-frontend.sent).Text, "User not authorized :(")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
@@ -2,6 +2,7 @@ package telegram_bot
 
 import (
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -9,140 +10,657 @@ import (
 
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
-func LaunchTelegramBot(config configuration_loader.InitialConfiguration, outputChannel chan types.Action, programmedActionOperationsChannel chan types.ProgrammedActionOperation, inputChannel chan types.TelegramMessage, exitChannel chan bool) error {
-	bot, err := tgbotapi.NewBotAPI(config.ServerConfiguration.TelegramBotToken)
+func LaunchTelegramBot(config configuration_loader.InitialConfiguration, frontend Frontend, nodeOfPin func(pin string) string, channels types.BotChannels, exitChannel chan bool) error {
+	commands, err := frontend.Commands()
 	if err != nil {
 		return err
 	}
 	fmt.Println("Telegram bot created correctly, waiting for messages")
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates, err := bot.GetUpdatesChan(u)
-	if err != nil {
-		return err
-	}
-
-	go func(updatesChannel tgbotapi.UpdatesChannel) {
+	go func(commandsChannel <-chan Command) {
+		// Last menu sent to every chat and pin buttons waiting for the result of their action
+		menus := make(map[int64]Message)
+		pendingEdits := make(map[int64]pendingEdit)
+		permissions := newPermissions(*config.ServerConfiguration, nodeOfPin)
+		if config.DataDirectory != "" {
+			err := permissions.loadJoinedUsers(filepath.Join(config.DataDirectory, "telegram_users.json"))
+			if err != nil {
+				fmt.Println("Only the users of the configuration file will be authorized: " + err.Error())
+			}
+		}
+		// Last user of every chat, the menu only shows the pins the user can change
+		chatUsers := make(map[int64]int)
+		// Guided creations of programmed actions and pins received in the last menu, offered by them
+		conversations := make(map[int64]*conversation)
+		var knownPins []string
+		// Pin actions whose result is recorded in the audit log when it arrives
+		pendingAudits := make(map[chatPin]types.AuditEntry)
+		// Commands on pins that require confirmation, by chat
+		confirmations := make(map[int64]pendingConfirmation)
+		parser := newPhraseParser(config.ServerConfiguration.PinAliases)
+		var localPins []string
+		for _, pin := range config.PinsActive {
+			localPins = append(localPins, pin.Name)
+		}
+		// Phrases are sent to the server directly, their text is recorded in the audit log
+		runOrder := func(order phrase, command Command) {
+			entry := pinAuditEntry(command, order.pin, nodeOfPin, "accepted")
+			recordAudit(entry)
+			pendingAudits[chatPin{command.ChatId, order.pin}] = entry
+			user, _ := permissions.user(command.UserId)
+			go sendOrder(order, command.ChatId, user, channels)
+		}
 		for {
 			createProgrammedActionRegex := regexp.MustCompile("^CreateProgrammedAction (.*)$")
 			removeProgrammedActionRegex := regexp.MustCompile("^RemoveProgrammedAction (.*)$")
+			updateProgrammedActionRegex := regexp.MustCompile("^UpdateProgrammedAction (.*)$")
 			select {
 			case _ = <-exitChannel:
 				fmt.Println("Exit signal received in telegram bot")
+				frontend.Stop()
 				exitChannel <- true
 				return
-			case update := <-updatesChannel:
-				if update.Message == nil {
+			case command := <-commandsChannel:
+				user, userAuthorized := permissions.user(command.UserId)
+				messageDivided := strings.Fields(command.Text)
+				pins := append(append([]string{}, knownPins...), localPins...)
+				if current, ok := conversations[command.ChatId]; userAuthorized && (isPhrase(command.Text, pins) || parser.isChoice(command.Text, command.ChatId)) && (!ok || current.userId != command.UserId || current.expired(time.Now())) {
+					// Only the pins the user can change are matched, users that can not change any (e.g. viewers)
+					// get the phrase matched anyway so they are told why it is not allowed
+					if controllable := permissions.controllablePins(user, pins); len(controllable) > 0 {
+						pins = controllable
+					}
+					order, reply := parser.translate(command.Text, pins, command.ChatId)
+					if (reply != nil || order != nil) && command.CallbackId != "" {
+						if err := frontend.AnswerCallback(command.CallbackId, ""); err != nil {
+							fmt.Println("There was an error answering a button: ", err.Error())
+						}
+					}
+					if reply != nil {
+						sendMessage(frontend, *reply)
+						continue
+					} else if order != nil {
+						// Phrases are authorized and confirmed like the commands on their pin
+						chatUsers[command.ChatId] = command.UserId
+						if notAllowed := permissions.checkPin(user, order.pin, "\""+command.Text+"\""); notAllowed != "" {
+							sendMessage(frontend, buildMessage(notAllowed, command.ChatId, command.MessageId))
+							fmt.Println("User " + strconv.Itoa(command.UserId) + " not allowed: " + command.Text)
+							recordAudit(pinAuditEntry(command, order.pin, nodeOfPin, notAllowed))
+						} else if contains(config.ServerConfiguration.RequireConfirmation, order.pin) {
+							confirmations[command.ChatId] = pendingConfirmation{command, time.Now().Add(confirmationTimeout), order}
+							sendMessage(frontend, confirmationMessage(command.Text, order.pin, command.ChatId))
+						} else {
+							runOrder(*order, command)
+						}
+						continue
+					}
+				}
+				if len(messageDivided) > 0 && strings.ToLower(messageDivided[0]) == "/join" && command.CallbackId == "" {
+					// New users are not authorized yet, they join with an invitation code
+					response := permissions.join(command.UserId, messageDivided, time.Now())
+					recordAudit(auditEntry(command, command.Text, nodeOfPin, response))
+					sendMessage(frontend, buildMessage(response, command.ChatId, command.MessageId))
 					continue
 				}
-				userAuthorized := false
-				for _, user := range config.ServerConfiguration.TelegramAuthorizedUsers {
-					if user == update.Message.From.ID {
-						userAuthorized = true
+				notAllowed := ""
+				if userAuthorized && len(messageDivided) > 0 {
+					notAllowed = permissions.checkCommand(user, messageDivided)
+				}
+				if command.CallbackId != "" {
+					answer := ""
+					if !userAuthorized {
+						answer = "User not authorized :("
+					} else if notAllowed != "" {
+						answer = notAllowed
+					}
+					if err := frontend.AnswerCallback(command.CallbackId, answer); err != nil {
+						fmt.Println("There was an error answering a button: ", err.Error())
 					}
 				}
 				if userAuthorized {
-					messageDivided := strings.Fields(update.Message.Text)
+					if len(messageDivided) == 0 {
+						continue
+					}
+					chatUsers[command.ChatId] = command.UserId
+					if notAllowed != "" {
+						if command.CallbackId == "" {
+							sendMessage(frontend, buildMessage(notAllowed, command.ChatId, command.MessageId))
+						}
+						fmt.Println("User " + strconv.Itoa(command.UserId) + " not allowed: " + command.Text)
+						recordAudit(auditEntry(command, command.Text, nodeOfPin, notAllowed))
+						continue
+					}
 					possibleAction := messageDivided[0]
+					if current, ok := conversations[command.ChatId]; ok && current.expired(time.Now()) {
+						delete(conversations, command.ChatId)
+					}
+					if strings.ToLower(possibleAction) == "/cancel" {
+						if pending, ok := confirmations[command.ChatId]; ok && pending.command.UserId == command.UserId {
+							delete(confirmations, command.ChatId)
+							recordAudit(auditEntry(pending.command, pending.command.Text, nodeOfPin, "cancelled"))
+							sendMessage(frontend, buildMessage("Cancelled", command.ChatId, -1))
+						} else if _, ok := conversations[command.ChatId]; ok {
+							delete(conversations, command.ChatId)
+							sendMessage(frontend, buildMessage("Cancelled", command.ChatId, -1))
+						} else {
+							sendMessage(frontend, buildMessage("There is nothing to cancel", command.ChatId, -1))
+						}
+						continue
+					} else if strings.ToLower(possibleAction) == "/new" {
+						current, msg := newConversation(command.UserId, permissions.controllablePins(user, knownPins), command.ChatId, time.Now())
+						conversations[command.ChatId] = current
+						sendMessage(frontend, msg)
+						continue
+					} else if current, ok := conversations[command.ChatId]; ok && current.userId == command.UserId && !strings.HasPrefix(possibleAction, "/") {
+						// Commands starting with "/" keep working in the middle of a conversation
+						msg, programmedAction, finished := current.handle(command.Text, func(pin string) bool {
+							return (len(knownPins) == 0 || contains(knownPins, pin)) && permissions.pinAllowed(user, pin)
+						}, command.ChatId, time.Now())
+						if !finished {
+							sendMessage(frontend, msg)
+							continue
+						}
+						delete(conversations, command.ChatId)
+						go func() {
+							msg := createProgrammedAction(programmedAction, command.ChatId, user, channels.ProgrammedActions)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+						continue
+					}
+					// Conversations ask for confirmation themselves, other commands on pins that require it wait for "/confirm"
+					if strings.ToLower(possibleAction) == "/confirm" {
+						pending, ok := confirmations[command.ChatId]
+						if !ok || pending.command.UserId != command.UserId {
+							sendMessage(frontend, buildMessage("There is nothing to confirm", command.ChatId, -1))
+							continue
+						}
+						delete(confirmations, command.ChatId)
+						if pending.expired(time.Now()) {
+							sendMessage(frontend, buildMessage("The confirmation expired, send \""+pending.command.Text+"\" again", command.ChatId, -1))
+							continue
+						} else if pending.order != nil {
+							runOrder(*pending.order, pending.command)
+							continue
+						}
+						command = pending.command
+						messageDivided = strings.Fields(command.Text)
+						possibleAction = messageDivided[0]
+					} else if pin := confirmationPin(command.Text, command.ChatId, config.ServerConfiguration.RequireConfirmation); pin != "" {
+						confirmations[command.ChatId] = pendingConfirmation{command, time.Now().Add(confirmationTimeout), nil}
+						sendMessage(frontend, confirmationMessage(command.Text, pin, command.ChatId))
+						continue
+					}
+					// Answers of conversations are not recorded, the programmed action they create is
+					entry := auditEntry(command, command.Text, nodeOfPin, "accepted")
+					recordAudit(entry)
+					if entry.Pin != "" {
+						pendingAudits[chatPin{command.ChatId, entry.Pin}] = entry
+					}
+					if pin, state, ok := pinButtonAction(possibleAction); ok && command.CallbackId != "" {
+						pendingEdits[command.ChatId] = pendingEdit{messageId: command.MessageId, pin: pin, state: state}
+					}
 					if strings.ToLower(possibleAction) == "/start" {
-						outputChannel <- types.Action{"start", true, update.Message.Chat.ID}
+						channels.Actions <- types.Action{"start", true, command.ChatId}
 						continue
+					} else if strings.ToLower(possibleAction) == "/agenda" {
+						go func() {
+							msg := requestAgenda(command.Text, command.ChatId, user, channels.AgendaRequests)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if strings.ToLower(possibleAction) == "/history" {
+						go func() {
+							msg := requestHistory(command.Text, command.ChatId, user, channels.HistoryRequests)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if strings.ToLower(possibleAction) == "/vacation" {
+						go func() {
+							msg := requestVacationMode(command.Text, command.ChatId, channels.VacationRequests)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if strings.ToLower(possibleAction) == "/replay" {
+						go func() {
+							msg := requestReplay(command.Text, command.ChatId, channels.ReplayRequests)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if strings.ToLower(possibleAction) == "/override" {
+						go func() {
+							msg := requestOverrides(command.Text, command.ChatId, channels.OverrideRequests)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if strings.ToLower(possibleAction) == "/sequence" || strings.ToLower(possibleAction) == "/raindelay" {
+						go func() {
+							msg := requestSequence(command.Text, command.ChatId, channels.SequenceRequests)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if strings.ToLower(possibleAction) == "/status" {
+						go func() {
+							channels.StatusRequests <- types.StatusRequest{ChatId: command.ChatId, AllowedPins: user.Pins, AllowedNodes: user.Nodes}
+						}()
+					} else if strings.ToLower(possibleAction) == "/subscribe" || strings.ToLower(possibleAction) == "/unsubscribe" || strings.ToLower(possibleAction) == "/mute" {
+						go func() {
+							msg := requestSubscription(command.Text, command.ChatId, user, channels.SubscriptionRequests)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if strings.ToLower(possibleAction) == "/audit" {
+						go func() {
+							sendMessage(frontend, auditMessage(command.Text, command.ChatId))
+						}()
+					} else if strings.ToLower(possibleAction) == "/invite" {
+						sendMessage(frontend, buildMessage(permissions.invite(messageDivided, time.Now()), command.ChatId, command.MessageId))
+					} else if strings.ToLower(possibleAction) == "/users" {
+						sendMessage(frontend, buildMessage(permissions.usersDescription(time.Now()), command.ChatId, -1))
+					} else if strings.ToLower(possibleAction) == "/revoke" {
+						response, revokedUser := permissions.revoke(messageDivided)
+						sendMessage(frontend, buildMessage(response, command.ChatId, command.MessageId))
+						if revokedUser != 0 {
+							// The server keeps notifying the subscriptions of the user otherwise
+							go func() {
+								channels.SubscriptionRequests <- types.SubscriptionRequest{Operation: types.SUBSCRIPTION_REVOKE, ChatId: command.ChatId, UserId: revokedUser}
+							}()
+						}
+					} else if strings.ToLower(possibleAction) == "/remind" {
+						go func() {
+							msg := createReminder(command.Text, command.ChatId, user, channels.ProgrammedActions)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if strings.ToLower(possibleAction) == "/check" {
+						go func() {
+							channels.ProgrammedActions <- types.ProgrammedActionOperation{Operation: types.CHECK, ProgrammedAction: types.ProgrammedAction{Action: types.Action{ChatId: command.ChatId}}, AllowedPins: user.Pins, AllowedNodes: user.Nodes}
+						}()
+					} else if strings.ToLower(possibleAction) == "/timers" {
+						go func() {
+							channels.ProgrammedActions <- types.ProgrammedActionOperation{Operation: types.GET_TIMERS, ProgrammedAction: types.ProgrammedAction{Action: types.Action{ChatId: command.ChatId}}, AllowedPins: user.Pins, AllowedNodes: user.Nodes}
+						}()
 					} else if matched, err := regexp.Match("OnAndOff$", []byte(possibleAction)); err == nil && matched {
 						go func() {
-							msg := turnPinOnAndOff(update.Message.Text, config, update.Message.Chat.ID, update.Message.MessageID, outputChannel)
+							msg := turnPinOnAndOff(command.Text, config, command.ChatId, command.MessageId, user, channels.Actions, channels.ProgrammedActions)
+							if msg != nil {
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if matched, err = regexp.Match("On$", []byte(possibleAction)); err == nil && matched && len(messageDivided) > 1 {
+						go func() {
+							msg := createTimer(command.Text, true, command.ChatId, command.MessageId, user, channels.ProgrammedActions)
 							if msg != nil {
-								bot.Send(msg)
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if matched, err = regexp.Match("Off$", []byte(possibleAction)); err == nil && matched && len(messageDivided) > 1 {
+						go func() {
+							msg := createTimer(command.Text, false, command.ChatId, command.MessageId, user, channels.ProgrammedActions)
+							if msg != nil {
+								sendMessage(frontend, *msg)
 							}
 						}()
 					} else if matched, err = regexp.Match("On$", []byte(possibleAction)); err == nil && matched {
-						go turnPinOn(update.Message.Text, config, update.Message.Chat.ID, update.Message.MessageID, outputChannel)
+						go turnPinOn(command.Text, config, command.ChatId, command.MessageId, channels.Actions)
 					} else if matched, err = regexp.Match("Off$", []byte(possibleAction)); err == nil && matched {
-						go turnPinOff(update.Message.Text, config, update.Message.Chat.ID, update.Message.MessageID, outputChannel)
-					} else if matchedGroups := createProgrammedActionRegex.FindStringSubmatch(update.Message.Text); len(matchedGroups) > 1 {
+						go turnPinOff(command.Text, config, command.ChatId, command.MessageId, channels.Actions)
+					} else if matchedGroups := createProgrammedActionRegex.FindStringSubmatch(command.Text); len(matchedGroups) > 1 {
 						go func() {
-							msg := createProgrammedAction(matchedGroups[1], update.Message.Chat.ID, programmedActionOperationsChannel)
+							msg := createProgrammedAction(matchedGroups[1], command.ChatId, user, channels.ProgrammedActions)
 							if msg != nil {
-								bot.Send(msg)
+								sendMessage(frontend, *msg)
 							}
 						}()
-					} else if matchedGroups := removeProgrammedActionRegex.FindStringSubmatch(update.Message.Text); len(matchedGroups) > 1 {
+					} else if matchedGroups := removeProgrammedActionRegex.FindStringSubmatch(command.Text); len(matchedGroups) > 1 {
 						go func() {
-							msg := removeProgrammedAction(matchedGroups[1], update.Message.Chat.ID, programmedActionOperationsChannel)
+							msg := removeProgrammedAction(matchedGroups[1], command.ChatId, user, channels.ProgrammedActions)
 							if msg != nil {
-								bot.Send(msg)
+								sendMessage(frontend, *msg)
+							}
+						}()
+					} else if matchedGroups := updateProgrammedActionRegex.FindStringSubmatch(command.Text); len(matchedGroups) > 1 {
+						go func() {
+							msg := updateProgrammedAction(matchedGroups[1], command.ChatId, user, channels.ProgrammedActions)
+							if msg != nil {
+								sendMessage(frontend, *msg)
 							}
 						}()
 					} else if matched, err = regexp.Match("^GetProgrammedActions$", []byte(possibleAction)); err == nil && matched {
 						go func() {
-							programmedActionOperationsChannel <- types.ProgrammedActionOperation{Operation: types.GET_ACTIONS, ProgrammedAction: types.ProgrammedAction{Action: types.Action{ChatId: update.Message.Chat.ID}}}
+							channels.ProgrammedActions <- types.ProgrammedActionOperation{Operation: types.GET_ACTIONS, ProgrammedAction: types.ProgrammedAction{Action: types.Action{ChatId: command.ChatId}}, AllowedPins: user.Pins, AllowedNodes: user.Nodes}
 						}()
 					} else {
-						bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "Message was not correct"))
+						sendMessage(frontend, buildMessage("Message was not correct", command.ChatId, -1))
 						fmt.Println("Wrong message: " + possibleAction)
 					}
 				} else {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "User not authorized :(")
-					msg.ReplyToMessageID = update.Message.MessageID
-					bot.Send(msg)
-					fmt.Println("User " + strconv.FormatInt(update.Message.Chat.ID, 10) + " tried to send a message (not authorized)")
+					if command.CallbackId == "" {
+						sendMessage(frontend, buildMessage("User not authorized :(", command.ChatId, command.MessageId))
+					}
+					fmt.Println("User " + strconv.FormatInt(command.ChatId, 10) + " tried to send a message (not authorized)")
+					recordAudit(auditEntry(command, command.Text, nodeOfPin, "not authorized"))
+				}
+			case response := <-channels.Responses:
+				if pin, result, ok := pinActionResult(response.Message); ok {
+					if entry, pending := pendingAudits[chatPin{response.ChatId, pin}]; pending {
+						delete(pendingAudits, chatPin{response.ChatId, pin})
+						entry.Date, entry.Result = time.Now(), result
+						recordAudit(entry)
+					}
 				}
-			case response := <-inputChannel:
 				fields := strings.Fields(response.Message)
-				if fields[0] == "start" {
-					msg := createMarkupForMessages(fields[1:], response.ChatId)
-					bot.Send(msg)
-				} else if fields[0] == "ProgrammedActions" {
-					msg := createGetProgrammedActionsResponse(response.Message, response.ChatId)
-					bot.Send(msg)
+				edit, editPending := pendingEdits[response.ChatId]
+				menu, menuSent := menus[response.ChatId]
+				if len(fields) > 0 && fields[0] == "start" {
+					knownPins = fields[1:]
+					pins := knownPins
+					if user, ok := permissions.user(chatUsers[response.ChatId]); ok {
+						pins = permissions.controllablePins(user, pins)
+					}
+					menus[response.ChatId] = createMarkupForMessages(pins, response.ChatId)
+					sendMessage(frontend, menus[response.ChatId])
+				} else if editPending && menuSent && (response.Message == "Action "+edit.pin+" successful" || response.Message == "Action "+edit.pin+" not successful") {
+					// Show the result in the menu instead of sending another message
+					delete(pendingEdits, response.ChatId)
+					text := menu.Text + "\n" + edit.result(response.Message == "Action "+edit.pin+" successful")
+					if err := frontend.EditText(response.ChatId, edit.messageId, text, menu.Keyboard); err != nil {
+						fmt.Println("There was an error editing a message: ", err.Error())
+					}
+				} else if len(fields) > 0 && fields[0] == "ProgrammedActions" {
+					sendMessage(frontend, createGetProgrammedActionsResponse(response.Message, response.ChatId))
+				} else if len(fields) > 0 && fields[0] == "Timers" {
+					sendMessage(frontend, createGetTimersResponse(response.Message, response.ChatId))
 				} else {
-					msg := tgbotapi.NewMessage(response.ChatId, response.Message)
-					bot.Send(msg)
+					sendMessage(frontend, buildMessage(response.Message, response.ChatId, -1))
 				}
 			}
 		}
-	}(updates)
+	}(commands)
 	return nil
 }
 
-func turnPinOn(message string, config configuration_loader.InitialConfiguration, chatId int64, replyToMessageId int, outputChannel chan types.Action) *tgbotapi.MessageConfig {
+type pendingEdit struct {
+	messageId int
+	pin       string
+	state     bool
+}
+
+func (e pendingEdit) result(success bool) string {
+	state := "off"
+	if e.state {
+		state = "on"
+	}
+	if success {
+		return e.pin + " is " + state
+	}
+	return e.pin + " could not be turned " + state
+}
+
+// Pin and state of the actions sent by the pin buttons ("LightOn", "LightOff" and "LightOnAndOff")
+func pinButtonAction(action string) (string, bool, bool) {
+	if strings.HasSuffix(action, "OnAndOff") {
+		return strings.TrimSuffix(action, "OnAndOff"), true, true
+	} else if strings.HasSuffix(action, "On") {
+		return strings.TrimSuffix(action, "On"), true, true
+	} else if strings.HasSuffix(action, "Off") {
+		return strings.TrimSuffix(action, "Off"), false, true
+	}
+	return "", false, false
+}
+
+func turnPinOn(message string, config configuration_loader.InitialConfiguration, chatId int64, replyToMessageId int, outputChannel chan types.Action) *Message {
 	firstPart := strings.Fields(message)[0]
 	pin := firstPart[:len(firstPart)-2]
 	outputChannel <- types.Action{pin, true, chatId}
 	return nil
 }
 
-func turnPinOff(message string, config configuration_loader.InitialConfiguration, chatId int64, replyToMessageId int, outputChannel chan types.Action) *tgbotapi.MessageConfig {
+func turnPinOff(message string, config configuration_loader.InitialConfiguration, chatId int64, replyToMessageId int, outputChannel chan types.Action) *Message {
 	firstPart := strings.Fields(message)[0]
 	pin := firstPart[:len(firstPart)-3]
 	outputChannel <- types.Action{pin, false, chatId}
 	return nil
 }
 
-func removeProgrammedAction(message string, chatId int64, outputChannel chan types.ProgrammedActionOperation) *tgbotapi.MessageConfig {
+func removeProgrammedAction(message string, chatId int64, user configuration_loader.TelegramUser, outputChannel chan types.ProgrammedActionOperation) *Message {
+	fields := strings.Fields(message)
+	if len(fields) != 1 {
+		msg := buildMessage("Remove messages should only contain the programmed action id", chatId, -1)
+		return &msg
+	}
+	programmedAction := types.ProgrammedAction{Id: fields[0], Action: types.Action{ChatId: chatId}}
+	outputChannel <- types.ProgrammedActionOperation{ProgrammedAction: programmedAction, Operation: types.REMOVE, AllowedPins: user.Pins, AllowedNodes: user.Nodes, UserId: user.Id}
+	return nil
+}
+
+func createProgrammedAction(message string, chatId int64, user configuration_loader.TelegramUser, outputChannel chan types.ProgrammedActionOperation) *Message {
 	programmedAction, err := types.ProgrammedActionFromString(message, chatId)
 	if err != nil {
 		msg := buildMessage("Programmed action not well defined: "+err.Error(), chatId, -1)
 		return &msg
 	}
-	outputChannel <- types.ProgrammedActionOperation{ProgrammedAction: *programmedAction, Operation: types.REMOVE}
+	outputChannel <- types.ProgrammedActionOperation{ProgrammedAction: *programmedAction, Operation: types.CREATE, AllowedPins: user.Pins, AllowedNodes: user.Nodes, UserId: user.Id}
 	return nil
 }
 
-func createProgrammedAction(message string, chatId int64, outputChannel chan types.ProgrammedActionOperation) *tgbotapi.MessageConfig {
-	programmedAction, err := types.ProgrammedActionFromString(message, chatId)
+func createReminder(message string, chatId int64, user configuration_loader.TelegramUser, outputChannel chan types.ProgrammedActionOperation) *Message {
+	fields := strings.Fields(message)[1:]
+	repeat := len(fields) > 0 && strings.EqualFold(fields[0], "daily")
+	if repeat {
+		fields = fields[1:]
+	}
+	var reminderTime time.Time
+	valid := false
+	if len(fields) > 1 {
+		for _, layout := range []string{"15:04", "15:04:05"} {
+			if parsed, err := time.Parse(layout, fields[0]); err == nil {
+				reminderTime, valid = parsed, true
+			}
+		}
+	}
+	if !valid {
+		msg := buildMessage("Reminders should be \"/remind [daily] hh:mm [message]\" (e.g. \"/remind 20:00 take the bins out\")", chatId, -1)
+		return &msg
+	}
+	now := time.Now()
+	date := time.Date(now.Year(), now.Month(), now.Day(), reminderTime.Hour(), reminderTime.Minute(), reminderTime.Second(), 0, now.Location())
+	for date.Before(now) {
+		date = date.Add(time.Hour * 24)
+	}
+	reminder := types.NewReminder(strings.Join(fields[1:], " "), chatId, date, repeat)
+	outputChannel <- types.ProgrammedActionOperation{ProgrammedAction: reminder, Operation: types.CREATE, AllowedPins: user.Pins, AllowedNodes: user.Nodes, UserId: user.Id}
+	return nil
+}
+
+func updateProgrammedAction(message string, chatId int64, user configuration_loader.TelegramUser, outputChannel chan types.ProgrammedActionOperation) *Message {
+	fields := strings.Fields(message)
+	if len(fields) == 1 {
+		msg := buildMessage("To update this programmed action send \"UpdateProgrammedAction "+fields[0]+" [pin];[state];[repeat];[hh:mm:ss]\"", chatId, -1)
+		return &msg
+	} else if len(fields) != 2 {
+		msg := buildMessage("Update messages should contain the programmed action id and the new programmed action", chatId, -1)
+		return &msg
+	}
+	programmedAction, err := types.ProgrammedActionFromString(fields[1], chatId)
 	if err != nil {
 		msg := buildMessage("Programmed action not well defined: "+err.Error(), chatId, -1)
 		return &msg
 	}
-	outputChannel <- types.ProgrammedActionOperation{ProgrammedAction: *programmedAction, Operation: types.CREATE}
+	programmedAction.Id = fields[0]
+	outputChannel <- types.ProgrammedActionOperation{ProgrammedAction: *programmedAction, Operation: types.UPDATE, AllowedPins: user.Pins, AllowedNodes: user.Nodes, UserId: user.Id}
+	return nil
+}
+
+func requestAgenda(message string, chatId int64, user configuration_loader.TelegramUser, outputChannel chan types.AgendaRequest) *Message {
+	request := types.AgendaRequest{ChatId: chatId, AllowedPins: user.Pins, AllowedNodes: user.Nodes}
+	fields := strings.Fields(message)
+	if len(fields) > 1 {
+		if executions, err := strconv.Atoi(fields[1]); err == nil && executions > 0 {
+			request.Executions = executions
+		} else if horizon, err := time.ParseDuration(fields[1]); err == nil && horizon > 0 {
+			request.Horizon = horizon
+		} else {
+			msg := buildMessage("Agenda messages should be \"/agenda\", \"/agenda [executions]\" or \"/agenda [duration]\" (e.g. \"/agenda 5\" or \"/agenda 12h\")", chatId, -1)
+			return &msg
+		}
+	}
+	outputChannel <- request
+	return nil
+}
+
+func requestHistory(message string, chatId int64, user configuration_loader.TelegramUser, outputChannel chan types.HistoryRequest) *Message {
+	request := types.HistoryRequest{ChatId: chatId, AllowedPins: user.Pins, AllowedNodes: user.Nodes}
+	fields := strings.Fields(message)
+	for _, field := range fields[1:] {
+		now := time.Now()
+		if strings.EqualFold(field, "today") {
+			request.Date = now
+		} else if strings.EqualFold(field, "yesterday") {
+			request.Date = now.AddDate(0, 0, -1)
+		} else if date, err := time.ParseInLocation("2006-01-02", field, now.Location()); err == nil {
+			request.Date = date
+		} else if request.Pin == "" {
+			request.Pin = field
+		} else {
+			msg := buildMessage("History messages should be \"/history [pin] [date]\", where the date is \"today\", \"yesterday\" or \"yyyy-mm-dd\" (e.g. \"/history Light today\")", chatId, -1)
+			return &msg
+		}
+	}
+	outputChannel <- request
+	return nil
+}
+
+func requestVacationMode(message string, chatId int64, outputChannel chan types.VacationModeRequest) *Message {
+	fields := strings.Fields(message)
+	if len(fields) != 2 || (!strings.EqualFold(fields[1], "on") && !strings.EqualFold(fields[1], "off")) {
+		msg := buildMessage("Vacation messages should be \"/vacation on\" or \"/vacation off\"", chatId, -1)
+		return &msg
+	}
+	outputChannel <- types.VacationModeRequest{Enabled: strings.EqualFold(fields[1], "on"), ChatId: chatId}
+	return nil
+}
+
+func requestReplay(message string, chatId int64, outputChannel chan types.ReplayRequest) *Message {
+	fields := strings.Fields(message)
+	request := types.ReplayRequest{ChatId: chatId}
+	if len(fields) == 2 && strings.EqualFold(fields[1], "off") {
+		outputChannel <- request
+		return nil
+	} else if len(fields) >= 2 && len(fields) <= 3 && strings.EqualFold(fields[1], "on") {
+		request.Enabled = true
+		if len(fields) == 3 {
+			from, err := time.ParseInLocation("2006-01-02", fields[2], time.Now().Location())
+			if err != nil || !from.Before(time.Now()) {
+				msg := buildMessage("The week to replay should start in a past date with the format \"yyyy-mm-dd\"", chatId, -1)
+				return &msg
+			}
+			request.From = from
+		}
+		outputChannel <- request
+		return nil
+	}
+	msg := buildMessage("Replay messages should be \"/replay on\" (to replay the last week), \"/replay on [yyyy-mm-dd]\" (to replay the week starting that day) or \"/replay off\"", chatId, -1)
+	return &msg
+}
+
+func requestOverrides(message string, chatId int64, outputChannel chan types.OverrideRequest) *Message {
+	fields := strings.Fields(message)
+	request := types.OverrideRequest{ChatId: chatId}
+	if len(fields) > 3 || (len(fields) > 1 && !strings.EqualFold(fields[1], "clear")) {
+		msg := buildMessage("Override messages should be \"/override\" (to list them) or \"/override clear [pin]\"", chatId, -1)
+		return &msg
+	}
+	request.Clear = len(fields) > 1
+	if len(fields) == 3 {
+		request.Pin = fields[2]
+	}
+	outputChannel <- request
+	return nil
+}
+
+func requestSequence(message string, chatId int64, outputChannel chan types.SequenceRequest) *Message {
+	fields := strings.Fields(message)
+	request := types.SequenceRequest{Operation: types.SEQUENCE_STATUS, ChatId: chatId}
+	if strings.EqualFold(fields[0], "/raindelay") {
+		days, err := strconv.Atoi(strings.Join(fields[1:], ""))
+		if err != nil || days < 0 {
+			msg := buildMessage("Rain delay messages should be \"/raindelay [days]\" (0 cancels it)", chatId, -1)
+			return &msg
+		}
+		request.Operation = types.SEQUENCE_RAIN_DELAY
+		request.Days = days
+		outputChannel <- request
+		return nil
+	}
+	if len(fields) == 3 && strings.EqualFold(fields[1], "run") {
+		request.Operation = types.SEQUENCE_RUN
+		request.Name = fields[2]
+	} else if len(fields) == 2 && strings.EqualFold(fields[1], "skip") {
+		request.Operation = types.SEQUENCE_SKIP
+	} else if len(fields) == 2 && strings.EqualFold(fields[1], "abort") {
+		request.Operation = types.SEQUENCE_ABORT
+	} else if len(fields) != 1 {
+		msg := buildMessage("Sequence messages should be \"/sequence\" (to get the status), \"/sequence run [name]\", \"/sequence skip\" or \"/sequence abort\"", chatId, -1)
+		return &msg
+	}
+	outputChannel <- request
+	return nil
+}
+
+func requestSubscription(message string, chatId int64, user configuration_loader.TelegramUser, outputChannel chan types.SubscriptionRequest) *Message {
+	fields := strings.Fields(message)
+	request := types.SubscriptionRequest{Operation: types.SUBSCRIPTION_LIST, ChatId: chatId, UserId: user.Id, AllowedPins: user.Pins, AllowedNodes: user.Nodes}
+	if strings.EqualFold(fields[0], "/mute") {
+		if len(fields) != 2 {
+			msg := buildMessage("Mute messages should be \"/mute [duration]\" (e.g. \"/mute 2h\") or \"/mute off\"", chatId, -1)
+			return &msg
+		}
+		request.Operation = types.SUBSCRIPTION_MUTE
+		if !strings.EqualFold(fields[1], "off") {
+			duration, err := time.ParseDuration(fields[1])
+			if err != nil || duration <= 0 {
+				msg := buildMessage("Mute messages should be \"/mute [duration]\" (e.g. \"/mute 2h\") or \"/mute off\"", chatId, -1)
+				return &msg
+			}
+			request.Duration = duration
+		}
+		outputChannel <- request
+		return nil
+	}
+	if len(fields) == 1 && strings.EqualFold(fields[0], "/subscribe") {
+		outputChannel <- request
+		return nil
+	}
+	request.Operation = types.SUBSCRIPTION_ADD
+	if strings.EqualFold(fields[0], "/unsubscribe") {
+		request.Operation = types.SUBSCRIPTION_REMOVE
+	}
+	if len(fields) == 2 && strings.EqualFold(fields[1], "all") {
+		request.Kind = "all"
+	} else if len(fields) == 3 && (strings.EqualFold(fields[1], "pin") || strings.EqualFold(fields[1], "node")) {
+		request.Kind = strings.ToLower(fields[1])
+		request.Target = fields[2]
+	} else {
+		msg := buildMessage("Subscription messages should be \"/subscribe\" (to list them), \""+fields[0]+" pin [pin]\", \""+fields[0]+" node [node]\" or \""+fields[0]+" all\"", chatId, -1)
+		return &msg
+	}
+	outputChannel <- request
 	return nil
 }
 
-func turnPinOnAndOff(message string, config configuration_loader.InitialConfiguration, chatId int64, replyToMessageId int, outputChannel chan types.Action) *tgbotapi.MessageConfig {
+func turnPinOnAndOff(message string, config configuration_loader.InitialConfiguration, chatId int64, replyToMessageId int, user configuration_loader.TelegramUser, outputChannel chan types.Action, programmedActionOperationsChannel chan types.ProgrammedActionOperation) *Message {
 	fields := strings.Fields(message)
 	if len(fields) < 2 {
 		msg := buildMessage("OnAndOff messages should contain at least two words (action and time)", chatId, replyToMessageId)
@@ -151,48 +669,121 @@ func turnPinOnAndOff(message string, config configuration_loader.InitialConfigur
 	firstPart := fields[0]
 	pin := firstPart[:len(firstPart)-8]
 	duration, err := time.ParseDuration(fields[1])
-	if err != nil {
+	if err != nil || duration <= 0 {
 		msg := buildMessage("Time not set properly", chatId, replyToMessageId)
 		return &msg
 	}
-	outputChannel <- types.Action{pin, true, chatId}
-	time.Sleep(duration)
-	outputChannel <- types.Action{pin, false, chatId}
+	turnPinOnFor(pin, duration, chatId, user, outputChannel, programmedActionOperationsChannel)
 	return nil
 }
 
-type messageHandlingFunc func(action string, config configuration_loader.InitialConfiguration, chatId int64, replyToMessageId int, outputChannel chan types.Action) *tgbotapi.MessageConfig
+func turnPinOnFor(pin string, duration time.Duration, chatId int64, user configuration_loader.TelegramUser, outputChannel chan types.Action, programmedActionOperationsChannel chan types.ProgrammedActionOperation) {
+	outputChannel <- types.Action{pin, true, chatId}
+	// The node owns the timer, so it survives server restarts and can be cancelled
+	programmedActionOperationsChannel <- types.ProgrammedActionOperation{ProgrammedAction: types.NewTimer(types.Action{pin, false, chatId}, duration), Operation: types.CREATE, AllowedPins: user.Pins, AllowedNodes: user.Nodes, UserId: user.Id}
+}
 
-func buildMessage(msgContent string, chatId int64, replyToMessageId int) tgbotapi.MessageConfig {
-	msg := tgbotapi.NewMessage(chatId, msgContent)
-	if replyToMessageId != -1 {
-		msg.ReplyToMessageID = replyToMessageId
+// sendOrder sends the action, the timer or the programmed action a phrase asks for
+func sendOrder(order phrase, chatId int64, user configuration_loader.TelegramUser, channels types.BotChannels) {
+	if order.at != nil {
+		channels.ProgrammedActions <- types.ProgrammedActionOperation{ProgrammedAction: order.programmedAction(chatId, time.Now()), Operation: types.CREATE, AllowedPins: user.Pins, AllowedNodes: user.Nodes, UserId: user.Id}
+	} else if order.duration != 0 {
+		turnPinOnFor(order.pin, order.duration, chatId, user, channels.Actions, channels.ProgrammedActions)
+	} else if order.delay != 0 {
+		channels.ProgrammedActions <- types.ProgrammedActionOperation{ProgrammedAction: types.NewTimer(types.Action{order.pin, order.state, chatId}, order.delay), Operation: types.CREATE, AllowedPins: user.Pins, AllowedNodes: user.Nodes, UserId: user.Id}
+	} else {
+		channels.Actions <- types.Action{order.pin, order.state, chatId}
 	}
-	return msg
 }
 
-func createMarkupForMessages(messages []string, chatId int64) tgbotapi.MessageConfig {
-	markup := tgbotapi.NewReplyKeyboard()
-	markup.Keyboard = append(markup.Keyboard, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("/start")))
-	markup.Keyboard = append(markup.Keyboard, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("GetProgrammedActions")))
+func createTimer(message string, state bool, chatId int64, replyToMessageId int, user configuration_loader.TelegramUser, outputChannel chan types.ProgrammedActionOperation) *Message {
+	fields := strings.Fields(message)
+	if len(fields) != 2 {
+		msg := buildMessage("Timer messages should contain two words (action and time), e.g. \"LightOff 30m\"", chatId, replyToMessageId)
+		return &msg
+	}
+	pin := strings.TrimSuffix(fields[0], "Off")
+	if state {
+		pin = strings.TrimSuffix(fields[0], "On")
+	}
+	duration, err := time.ParseDuration(fields[1])
+	if err != nil || duration <= 0 {
+		msg := buildMessage("Time not set properly", chatId, replyToMessageId)
+		return &msg
+	}
+	outputChannel <- types.ProgrammedActionOperation{ProgrammedAction: types.NewTimer(types.Action{pin, state, chatId}, duration), Operation: types.CREATE, AllowedPins: user.Pins, AllowedNodes: user.Nodes, UserId: user.Id}
+	return nil
+}
+
+type messageHandlingFunc func(action string, config configuration_loader.InitialConfiguration, chatId int64, replyToMessageId int, outputChannel chan types.Action) *Message
+
+func buildMessage(msgContent string, chatId int64, replyToMessageId int) Message {
+	return Message{Text: msgContent, ChatId: chatId, ReplyToMessageId: replyToMessageId}
+}
+
+func createMarkupForMessages(messages []string, chatId int64) Message {
+	keyboard := Keyboard{
+		[]Button{Button{"Menu", "/start"}, Button{"Status", "/status"}},
+		[]Button{Button{"Programmed actions", "GetProgrammedActions"}, Button{"Agenda", "/agenda"}, Button{"Timers", "/timers"}, Button{"History", "/history"}},
+	}
 	for _, value := range messages {
-		markup.Keyboard = append(markup.Keyboard, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(value+"On"), tgbotapi.NewKeyboardButton(value+"Off"), tgbotapi.NewKeyboardButton(value+"OnAndOff 2s")))
+		keyboard = append(keyboard, []Button{Button{value + " on", value + "On"}, Button{value + " off", value + "Off"}, Button{value + " 2s", value + "OnAndOff 2s"}})
 	}
-	msg := tgbotapi.NewMessage(chatId, "Welcome to rpi bot")
-	msg.ReplyMarkup = markup
+	msg := Message{Text: "Welcome to rpi bot", ChatId: chatId, Keyboard: keyboard}
 	fmt.Println("User with id \"" + strconv.FormatInt(chatId, 10) + "\" requested message types")
 	return msg
 }
 
-func createGetProgrammedActionsResponse(message string, chatId int64) tgbotapi.MessageConfig {
-	markup := tgbotapi.NewReplyKeyboard()
-	markup.Keyboard = append(markup.Keyboard, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("/start")))
+func createGetProgrammedActionsResponse(message string, chatId int64) Message {
+	keyboard := Keyboard{[]Button{Button{"Menu", "/start"}, Button{"New", "/new"}}}
+	text := "Programmed messages currently active:"
 	fields := strings.Fields(message)
 	for index := 1; index < len(fields); index++ {
-		markup.Keyboard = append(markup.Keyboard, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("RemoveProgrammedAction "+fields[index])))
+		idAndAction := strings.SplitN(fields[index], "=", 2)
+		if len(idAndAction) != 2 {
+			continue
+		}
+		programmedAction, err := types.ProgrammedActionFromString(idAndAction[1], chatId)
+		if err != nil {
+			continue
+		}
+		programmedAction.Id = idAndAction[0]
+		text += "\n" + types.ProgrammedActionToCompactString(*programmedAction)
+		keyboard = append(keyboard, []Button{Button{"Remove " + programmedAction.Id, "RemoveProgrammedAction " + programmedAction.Id}, Button{"Update " + programmedAction.Id, "UpdateProgrammedAction " + programmedAction.Id}})
 	}
-	msg := tgbotapi.NewMessage(chatId, "Programmed messages currently active:")
-	msg.ReplyMarkup = markup
+	msg := Message{Text: text, ChatId: chatId, Keyboard: keyboard}
 	fmt.Println("User with id \"" + strconv.FormatInt(chatId, 10) + "\" requested programmed messages")
 	return msg
 }
+
+func createGetTimersResponse(message string, chatId int64) Message {
+	keyboard := Keyboard{[]Button{Button{"Menu", "/start"}}}
+	text := "Timers currently active:"
+	fields := strings.Fields(message)
+	now := time.Now()
+	for index := 1; index < len(fields); index++ {
+		idAndAction := strings.SplitN(fields[index], "=", 2)
+		if len(idAndAction) != 2 {
+			continue
+		}
+		timer, err := types.ProgrammedActionFromString(idAndAction[1], chatId)
+		if err != nil || !timer.Timer {
+			continue
+		}
+		timer.Id = idAndAction[0]
+		text += "\n" + timer.Id + " " + timer.Action.Pin
+		if timer.Action.State {
+			text += " on"
+		} else {
+			text += " off"
+		}
+		text += " in " + timer.Remaining(now).String() + " (at " + timer.Time.Format("15:04:05") + ")"
+		keyboard = append(keyboard, []Button{Button{"Cancel " + timer.Id, "RemoveProgrammedAction " + timer.Id}})
+	}
+	if len(keyboard) == 1 {
+		text = "There are not any timers active"
+	}
+	msg := Message{Text: text, ChatId: chatId, Keyboard: keyboard}
+	fmt.Println("User with id \"" + strconv.FormatInt(chatId, 10) + "\" requested timers")
+	return msg
+}
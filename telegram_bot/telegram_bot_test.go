@@ -1,64 +1,222 @@
 package telegram_bot
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
 	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestWrongConfig(t *testing.T) {
+func TestWrongToken(t *testing.T) {
+	server := newFakeBotApi(t, nil)
+	defer server.Close()
+	_, err := NewTelegramFrontend("asdf", server.URL, nil)
+	assert.NotEqual(t, err, nil, "Wrong token should return an error")
+}
+
+func TestLaunchTelegramBot(t *testing.T) {
 	telegramOutputChannel := make(chan types.Action)
 	telegramInputChannel := make(chan types.TelegramMessage)
 	telegramExitChannel := make(chan bool)
 	var config configuration_loader.InitialConfiguration
 	var serverConfig configuration_loader.ServerConfiguration
 	config.ServerConfiguration = &serverConfig
-	config.ServerConfiguration.TelegramBotToken = "asdf"
 	config.ServerConfiguration.TelegramAuthorizedUsers = append(config.ServerConfiguration.TelegramAuthorizedUsers, 1234, 5678)
-	err := LaunchTelegramBot(config, telegramOutputChannel, nil, telegramInputChannel, telegramExitChannel)
-	assert.NotEqual(t, err, nil, "Wrong config should return an error")
+	config.PinsActive = append(config.PinsActive, types.PairNamePin{"Light", 1})
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	assert.Nil(t, err)
+
+	frontend.commands <- Command{Text: "LightOn", UserId: 1, ChatId: 1, MessageId: 3}
+	msg := <-frontend.sent
+	assert.Equal(t, msg, Message{Text: "User not authorized :(", ChatId: 1, ReplyToMessageId: 3})
+	frontend.commands <- Command{Text: "LightOn", UserId: 1234, ChatId: 1234}
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"Light", true, 1234})
+	frontend.commands <- Command{Text: "Light", UserId: 1234, ChatId: 1234}
+	assert.Equal(t, (<-frontend.sent).Text, "Message was not correct")
+	telegramInputChannel <- types.TelegramMessage{"start Light", 1234}
+	msg = <-frontend.sent
+	assert.Equal(t, msg.Keyboard[2], []Button{Button{"Light on", "LightOn"}, Button{"Light off", "LightOff"}, Button{"Light 2s", "LightOnAndOff 2s"}})
+	telegramInputChannel <- types.TelegramMessage{"Pin Light on", 1234}
+	assert.Equal(t, <-frontend.sent, Message{Text: "Pin Light on", ChatId: 1234, ReplyToMessageId: -1})
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+	assert.True(t, frontend.stopped)
 }
 
-func TestLaunchTelegramBot(t *testing.T) {
+func TestTelegramBotButtons(t *testing.T) {
 	telegramOutputChannel := make(chan types.Action)
 	telegramInputChannel := make(chan types.TelegramMessage)
 	telegramExitChannel := make(chan bool)
 	var config configuration_loader.InitialConfiguration
 	var serverConfig configuration_loader.ServerConfiguration
 	config.ServerConfiguration = &serverConfig
-	config.ServerConfiguration.TelegramBotToken = "153667468:AAHlSHlMqSt1f_uFmVRJbm5gntu2HI4WW8I"
-	config.ServerConfiguration.TelegramAuthorizedUsers = append(config.ServerConfiguration.TelegramAuthorizedUsers, 1234, 5678)
-	config.PinsActive = append(config.PinsActive, types.PairNamePin{"Light", 1})
-	go func() {
-		time.Sleep(2 * time.Second)
-		telegramExitChannel <- true
-		<-telegramExitChannel
-		close(telegramExitChannel)
-	}()
-	LaunchTelegramBot(config, telegramOutputChannel, nil, telegramInputChannel, telegramExitChannel)
+	config.ServerConfiguration.TelegramAuthorizedUsers = append(config.ServerConfiguration.TelegramAuthorizedUsers, 1234)
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "LightOn", UserId: 1, ChatId: 1, MessageId: 3, CallbackId: "a"}
+	assert.Equal(t, <-frontend.answered, "a:User not authorized :(", "Buttons should be authorized like text messages")
+	telegramInputChannel <- types.TelegramMessage{"start Light", 1234}
+	<-frontend.sent
+	frontend.commands <- Command{Text: "LightOff", UserId: 1234, ChatId: 1234, MessageId: 7, CallbackId: "b"}
+	assert.Equal(t, <-frontend.answered, "b:")
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"Light", false, 1234})
+	telegramInputChannel <- types.TelegramMessage{"Action Light successful", 1234}
+	msg := <-frontend.edited
+	assert.Equal(t, msg.Text, "Welcome to rpi bot\nLight is off", "The menu should show the resulting state")
+	assert.Equal(t, msg.ReplyToMessageId, 7)
+	assert.Equal(t, len(msg.Keyboard), 3, "The menu should keep its buttons")
+
+	frontend.commands <- Command{Text: "LightOn", UserId: 1234, ChatId: 1234, MessageId: 7, CallbackId: "c"}
+	<-frontend.answered
+	<-telegramOutputChannel
+	telegramInputChannel <- types.TelegramMessage{"Action Light not successful", 1234}
+	assert.Equal(t, (<-frontend.edited).Text, "Welcome to rpi bot\nLight could not be turned on")
+	telegramInputChannel <- types.TelegramMessage{"Action Light successful", 1234}
+	assert.Equal(t, (<-frontend.sent).Text, "Action Light successful", "Results of text messages should be sent as messages")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
+
+func TestTelegramFrontendWithApiUrl(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	var serverConfig configuration_loader.ServerConfiguration
+	config.ServerConfiguration = &serverConfig
+	config.ServerConfiguration.TelegramAuthorizedUsers = append(config.ServerConfiguration.TelegramAuthorizedUsers, 1234)
+	sent := make(chan url.Values, 10)
+	server := newFakeBotApi(t, sent)
+	defer server.Close()
+	frontend, err := NewTelegramFrontend("153667468:token", server.URL, nil)
+	require.Nil(t, err)
+	err = LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"start", true, 1234}, "The update of the fake server should be received")
+	telegramInputChannel <- types.TelegramMessage{"start Light", 1234}
+	values := <-sent
+	assert.Equal(t, values.Get("chat_id"), "1234")
+	assert.Equal(t, values.Get("text"), "Welcome to rpi bot")
+	assert.Contains(t, values.Get("reply_markup"), "LightOnAndOff 2s")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
+
+type fakeFrontend struct {
+	commands chan Command
+	sent     chan Message
+	edited   chan Message
+	answered chan string
+	stopped  bool
+}
+
+func newFakeFrontend() *fakeFrontend {
+	return &fakeFrontend{commands: make(chan Command), sent: make(chan Message, 10), edited: make(chan Message, 10), answered: make(chan string, 10)}
+}
+
+func (f *fakeFrontend) Commands() (<-chan Command, error) {
+	return f.commands, nil
+}
+
+func (f *fakeFrontend) SendText(chatId int64, text string, replyToMessageId int) error {
+	f.sent <- Message{Text: text, ChatId: chatId, ReplyToMessageId: replyToMessageId}
+	return nil
+}
+
+func (f *fakeFrontend) SendKeyboard(chatId int64, text string, keyboard Keyboard) error {
+	f.sent <- Message{Text: text, ChatId: chatId, Keyboard: keyboard}
+	return nil
+}
+
+func (f *fakeFrontend) EditText(chatId int64, messageId int, text string, keyboard Keyboard) error {
+	f.edited <- Message{Text: text, ChatId: chatId, ReplyToMessageId: messageId, Keyboard: keyboard}
+	return nil
+}
+
+func (f *fakeFrontend) AnswerCallback(callbackId string, text string) error {
+	f.answered <- callbackId + ":" + text
+	return nil
+}
+
+func (f *fakeFrontend) Stop() {
+	f.stopped = true
+}
+
+// Fake Bot API server, it accepts the token "153667468:token", sends a /start update and forwards the sent messages and webhooks
+func newFakeBotApi(t *testing.T, sent chan url.Values) *httptest.Server {
+	updateSent := false
+	var mutex sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/bot153667468:token/") {
+			w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized"}`))
+			return
+		}
+		r.ParseForm()
+		switch strings.TrimPrefix(r.URL.Path, "/bot153667468:token/") {
+		case "getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"rpi","username":"rpi_bot"}}`))
+		case "getUpdates":
+			mutex.Lock()
+			first := !updateSent
+			updateSent = true
+			mutex.Unlock()
+			if first {
+				w.Write([]byte(`{"ok":true,"result":[{"update_id":1,"message":{"message_id":1,"date":0,"text":"/start","from":{"id":1234,"first_name":"user"},"chat":{"id":1234,"type":"private"}}}]}`))
+			} else {
+				time.Sleep(100 * time.Millisecond)
+				w.Write([]byte(`{"ok":true,"result":[]}`))
+			}
+		case "setWebhook":
+			// Polling removes the webhook with an empty url first
+			if r.Form.Get("url") != "" {
+				sent <- r.Form
+			}
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		case "answerCallbackQuery", "editMessageText":
+			sent <- r.Form
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		case "sendMessage":
+			sent <- r.Form
+			w.Write([]byte(`{"ok":true,"result":{"message_id":2,"date":0,"chat":{"id":1234,"type":"private"}}}`))
+		default:
+			t.Errorf("Unexpected Bot API method: %s", r.URL.Path)
+		}
+	}))
 }
 
 func TestGetMessagesAvailableMarkup(t *testing.T) {
 	messages := []string{"Light", "Water"}
 	msg := createMarkupForMessages(messages, 0)
-	markup, ok := msg.ReplyMarkup.(tgbotapi.ReplyKeyboardMarkup)
-	assert.True(t, ok, "Error getting the message's reply markup")
-	assert.Equal(t, len(markup.Keyboard), 4, "The message should contain three rows (/start, light and water)")
-	assert.Equal(t, len(markup.Keyboard[0]), 1, "The message should contain one column (/start)")
-	assert.Equal(t, markup.Keyboard[0][0].Text, "/start", "Button should contain \"/start\" and it is \"%s\"", markup.Keyboard[0][0].Text)
-	assert.Equal(t, markup.Keyboard[1][0].Text, "GetProgrammedActions", "Button should contain \"GetProgrammedActions\" and it is \"%s\"", markup.Keyboard[0][0].Text)
-	assert.Equal(t, len(markup.Keyboard[2]), 3, "The message should contain three columns (on, off, onAndOff)")
-	assert.Equal(t, markup.Keyboard[2][0].Text, "LightOn", "Button should contain \"LightOn\" and it is \"%s\"", markup.Keyboard[2][0].Text)
-	assert.Equal(t, markup.Keyboard[2][1].Text, "LightOff", "Button should contain \"LightOff\" and it is \"%s\"", markup.Keyboard[2][1].Text)
-	assert.Equal(t, markup.Keyboard[2][2].Text, "LightOnAndOff 2s", "Button should contain \"LightOnAndOff 2s\" and it is \"%s\"", markup.Keyboard[2][2].Text)
-	assert.Equal(t, len(markup.Keyboard[3]), 3, "The message should contain three columns (on, off, onAndOff)")
-	assert.Equal(t, markup.Keyboard[3][0].Text, "WaterOn", "Button should contain \"WaterOn\" and it is \"%s\"", markup.Keyboard[3][0].Text)
-	assert.Equal(t, markup.Keyboard[3][1].Text, "WaterOff", "Button should contain \"WaterOff\" and it is \"%s\"", markup.Keyboard[3][1].Text)
-	assert.Equal(t, markup.Keyboard[3][2].Text, "WaterOnAndOff 2s", "Button should contain \"WaterOnAndOff 2s\" and it is \"%s\"", markup.Keyboard[3][2].Text)
+	markup := msg.Keyboard
+	assert.NotNil(t, markup, "Error getting the message's keyboard")
+	assert.Equal(t, len(markup), 4, "The message should contain three rows (/start, light and water)")
+	assert.Equal(t, len(markup[0]), 2, "The message should contain two columns (/start, /status)")
+	assert.Equal(t, markup[0][0].Data, "/start", "Button should contain \"/start\" and it is \"%s\"", markup[0][0].Data)
+	assert.Equal(t, markup[1][0].Data, "GetProgrammedActions", "Button should contain \"GetProgrammedActions\" and it is \"%s\"", markup[0][0].Data)
+	assert.Equal(t, len(markup[2]), 3, "The message should contain three columns (on, off, onAndOff)")
+	assert.Equal(t, markup[2][0].Data, "LightOn", "Button should contain \"LightOn\" and it is \"%s\"", markup[2][0].Data)
+	assert.Equal(t, markup[2][1].Data, "LightOff", "Button should contain \"LightOff\" and it is \"%s\"", markup[2][1].Data)
+	assert.Equal(t, markup[2][2].Data, "LightOnAndOff 2s", "Button should contain \"LightOnAndOff 2s\" and it is \"%s\"", markup[2][2].Data)
+	assert.Equal(t, len(markup[3]), 3, "The message should contain three columns (on, off, onAndOff)")
+	assert.Equal(t, markup[3][0].Data, "WaterOn", "Button should contain \"WaterOn\" and it is \"%s\"", markup[3][0].Data)
+	assert.Equal(t, markup[3][1].Data, "WaterOff", "Button should contain \"WaterOff\" and it is \"%s\"", markup[3][1].Data)
+	assert.Equal(t, markup[3][2].Data, "WaterOnAndOff 2s", "Button should contain \"WaterOnAndOff 2s\" and it is \"%s\"", markup[3][2].Data)
 }
 
 func TestTurnPinOn(t *testing.T) {
@@ -104,17 +262,287 @@ func TestTurnPinOnAndOff(t *testing.T) {
 	config.PinsActive = append(config.PinsActive, types.PairNamePin{"Light", 1})
 	config.PinsActive = append(config.PinsActive, types.PairNamePin{"Water", 2})
 	telegramOutputChannel := make(chan types.Action)
-	msg := turnPinOnAndOff("LightOnAndOff", config, 0, 0, telegramOutputChannel)
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	msg := turnPinOnAndOff("LightOnAndOff", config, 0, 0, configuration_loader.TelegramUser{}, telegramOutputChannel, operationsChannel)
 	assert.Equal(t, msg.Text, "OnAndOff messages should contain at least two words (action and time)", "Wrong message should return an error")
-	msg = turnPinOnAndOff("LightOnAndOff 40w", config, 0, 0, telegramOutputChannel)
+	msg = turnPinOnAndOff("LightOnAndOff 40w", config, 0, 0, configuration_loader.TelegramUser{}, telegramOutputChannel, operationsChannel)
 	assert.Equal(t, msg.Text, "Time not set properly", "Wrong time format should return an error")
 	go func() {
-		turnPinOnAndOff("LightOnAndOff 1s", config, 0, 0, telegramOutputChannel)
+		turnPinOnAndOff("LightOnAndOff 1m", config, 0, 0, configuration_loader.TelegramUser{}, telegramOutputChannel, operationsChannel)
 	}()
 	action := <-telegramOutputChannel
 	assert.Equal(t, action.Pin, "Light", "Pin name should be \"Light\", instead it is \"%s\"", action.Pin)
-	assert.Equal(t, action.State, true, "Action's state should be false")
-	action = <-telegramOutputChannel
-	assert.Equal(t, action.Pin, "Light", "Pin name should be \"Light\", instead it is \"%s\"", action.Pin)
-	assert.Equal(t, action.State, false, "Action's state should be true")
+	assert.Equal(t, action.State, true, "Action's state should be true")
+	operation := <-operationsChannel
+	assert.Equal(t, operation.Operation, int32(types.CREATE))
+	assert.True(t, operation.ProgrammedAction.Timer, "The pin should be turned off by a timer in the node")
+	assert.Equal(t, operation.ProgrammedAction.Action.Pin, "Light")
+	assert.Equal(t, operation.ProgrammedAction.Action.State, false, "Timer's state should be false")
+	assert.InDelta(t, operation.ProgrammedAction.Remaining(time.Now()).Seconds(), 60, 2)
+}
+
+func TestCreateTimer(t *testing.T) {
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	msg := createTimer("LightOff 30m now", false, 0, 0, configuration_loader.TelegramUser{}, operationsChannel)
+	assert.NotNil(t, msg, "Timer messages with more than two words should return an error")
+	msg = createTimer("LightOff soon", false, 0, 0, configuration_loader.TelegramUser{}, operationsChannel)
+	assert.NotNil(t, msg, "Wrong time format should return an error")
+	go func() {
+		createTimer("LightOff 30m", false, 1, 0, configuration_loader.TelegramUser{Id: 7, Pins: []string{"Light"}}, operationsChannel)
+		createTimer("WaterOn 1h", true, 1, 0, configuration_loader.TelegramUser{}, operationsChannel)
+	}()
+	operation := <-operationsChannel
+	assert.True(t, operation.ProgrammedAction.Timer)
+	assert.Equal(t, operation.ProgrammedAction.Action, types.Action{"Light", false, 1})
+	assert.Equal(t, operation.UserId, 7, "The server should know the user to record the result in the audit log")
+	assert.Equal(t, operation.AllowedPins, []string{"Light"})
+	assert.InDelta(t, operation.ProgrammedAction.Remaining(time.Now()).Minutes(), 30, 0.1)
+	operation = <-operationsChannel
+	assert.Equal(t, operation.ProgrammedAction.Action, types.Action{"Water", true, 1})
+	assert.InDelta(t, operation.ProgrammedAction.Remaining(time.Now()).Minutes(), 60, 0.1)
+}
+
+func TestGetTimersResponse(t *testing.T) {
+	deadline := time.Now().Add(30 * time.Minute)
+	msg := createGetTimersResponse("Timers 1a2b3c=Light;false;false;"+deadline.Format("15:04:05")+";timer:"+strconv.FormatInt(deadline.Unix(), 10), 0)
+	markup := msg.Keyboard
+	assert.NotNil(t, markup, "Error getting the message's keyboard")
+	assert.Equal(t, len(markup), 2, "The message should contain two rows (/start and one per timer)")
+	assert.Equal(t, markup[1][0].Data, "RemoveProgrammedAction 1a2b3c", "Timers should be cancellable")
+	assert.Regexp(t, "1a2b3c Light off in (29m59s|30m0s)", msg.Text, "The remaining time should be shown")
+	msg = createGetTimersResponse("Timers", 0)
+	assert.Equal(t, msg.Text, "There are not any timers active")
+}
+
+func TestRequestAgenda(t *testing.T) {
+	agendaRequestsChannel := make(chan types.AgendaRequest)
+	msg := requestAgenda("/agenda tomorrow", 0, configuration_loader.TelegramUser{}, agendaRequestsChannel)
+	assert.NotNil(t, msg, "Wrong agenda parameters should return an error")
+	go func() {
+		requestAgenda("/agenda", 1, configuration_loader.TelegramUser{}, agendaRequestsChannel)
+		requestAgenda("/agenda 5", 1, configuration_loader.TelegramUser{}, agendaRequestsChannel)
+		requestAgenda("/agenda 12h", 1, configuration_loader.TelegramUser{}, agendaRequestsChannel)
+	}()
+	request := <-agendaRequestsChannel
+	assert.Equal(t, request, types.AgendaRequest{ChatId: 1})
+	request = <-agendaRequestsChannel
+	assert.Equal(t, request, types.AgendaRequest{Executions: 5, ChatId: 1})
+	request = <-agendaRequestsChannel
+	assert.Equal(t, request, types.AgendaRequest{Horizon: 12 * time.Hour, ChatId: 1})
+}
+
+func TestUpdateProgrammedAction(t *testing.T) {
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	msg := updateProgrammedAction("1a2b3c", 0, configuration_loader.TelegramUser{}, operationsChannel)
+	assert.NotNil(t, msg, "Update messages without the new programmed action should return the instructions")
+	assert.Contains(t, msg.Text, "UpdateProgrammedAction 1a2b3c ")
+	msg = updateProgrammedAction("1a2b3c Light;true", 0, configuration_loader.TelegramUser{}, operationsChannel)
+	assert.NotNil(t, msg, "Wrong programmed actions should return an error")
+	go func() {
+		updateProgrammedAction("1a2b3c Light;false;false;08:30:00", 1, configuration_loader.TelegramUser{}, operationsChannel)
+	}()
+	operation := <-operationsChannel
+	assert.Equal(t, operation.Operation, int32(types.UPDATE))
+	assert.Equal(t, operation.ProgrammedAction.Id, "1a2b3c")
+	assert.Equal(t, operation.ProgrammedAction.Action.State, false)
+	assert.Equal(t, operation.ProgrammedAction.Repeat, false)
+	assert.Equal(t, operation.ProgrammedAction.Time.Format("15:04:05"), "08:30:00")
+	assert.Equal(t, operation.ProgrammedAction.Action.ChatId, int64(1))
+}
+
+func TestRemoveProgrammedAction(t *testing.T) {
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	msg := removeProgrammedAction("Light;true;true;07:00:00 1a2b3c", 0, configuration_loader.TelegramUser{}, operationsChannel)
+	assert.NotNil(t, msg, "Remove messages should only contain the id")
+	go func() {
+		removeProgrammedAction("1a2b3c", 1, configuration_loader.TelegramUser{}, operationsChannel)
+	}()
+	operation := <-operationsChannel
+	assert.Equal(t, operation.Operation, int32(types.REMOVE))
+	assert.Equal(t, operation.ProgrammedAction.Id, "1a2b3c")
+	assert.Equal(t, operation.ProgrammedAction.Action.ChatId, int64(1))
+}
+
+func TestGetProgrammedActionsResponse(t *testing.T) {
+	msg := createGetProgrammedActionsResponse("ProgrammedActions 1a2b3c=Light;true;true;07:00:00 4d5e6f=Light;false;false;23:00:00", 0)
+	markup := msg.Keyboard
+	assert.NotNil(t, markup, "Error getting the message's keyboard")
+	assert.Equal(t, len(markup), 3, "The message should contain three rows (/start and one per programmed action)")
+	assert.Equal(t, markup[1][0].Data, "RemoveProgrammedAction 1a2b3c")
+	assert.Equal(t, markup[1][1].Data, "UpdateProgrammedAction 1a2b3c")
+	assert.Equal(t, markup[2][0].Data, "RemoveProgrammedAction 4d5e6f")
+	assert.Contains(t, msg.Text, "1a2b3c Light on 07:00:00 daily")
+	assert.Contains(t, msg.Text, "4d5e6f Light off 23:00:00 once")
+}
+
+func TestRequestHistory(t *testing.T) {
+	historyRequestsChannel := make(chan types.HistoryRequest)
+	admin := configuration_loader.TelegramUser{Id: 1, Role: configuration_loader.ADMIN_ROLE}
+	kid := configuration_loader.TelegramUser{Id: 2, Role: configuration_loader.OPERATOR_ROLE, Pins: []string{"Light"}, Nodes: []string{"living"}}
+	msg := requestHistory("/history Light Water", 0, admin, historyRequestsChannel)
+	assert.NotNil(t, msg, "History messages with two pins should return an error")
+	go func() {
+		requestHistory("/history", 1, admin, historyRequestsChannel)
+		requestHistory("/history Light 2020-05-03", 1, admin, historyRequestsChannel)
+		requestHistory("/history today", 1, admin, historyRequestsChannel)
+		requestHistory("/history", 2, kid, historyRequestsChannel)
+	}()
+	request := <-historyRequestsChannel
+	assert.Equal(t, request, types.HistoryRequest{ChatId: 1})
+	request = <-historyRequestsChannel
+	assert.Equal(t, request.Pin, "Light")
+	assert.Equal(t, request.Date.Format("2006-01-02"), "2020-05-03")
+	request = <-historyRequestsChannel
+	assert.Equal(t, request.Pin, "")
+	assert.Equal(t, request.Date.Format("2006-01-02"), time.Now().Format("2006-01-02"))
+	request = <-historyRequestsChannel
+	assert.Equal(t, request, types.HistoryRequest{ChatId: 2, AllowedPins: []string{"Light"}, AllowedNodes: []string{"living"}}, "The server should filter the history with the allow lists of the user")
+}
+
+func TestRequestVacationMode(t *testing.T) {
+	vacationRequestsChannel := make(chan types.VacationModeRequest)
+	msg := requestVacationMode("/vacation", 0, vacationRequestsChannel)
+	assert.NotNil(t, msg, "Vacation messages without on or off should return the instructions")
+	msg = requestVacationMode("/vacation maybe", 0, vacationRequestsChannel)
+	assert.NotNil(t, msg)
+	go func() {
+		requestVacationMode("/vacation on", 1, vacationRequestsChannel)
+		requestVacationMode("/vacation OFF", 1, vacationRequestsChannel)
+	}()
+	assert.Equal(t, <-vacationRequestsChannel, types.VacationModeRequest{Enabled: true, ChatId: 1})
+	assert.Equal(t, <-vacationRequestsChannel, types.VacationModeRequest{Enabled: false, ChatId: 1})
+}
+
+func TestRequestReplay(t *testing.T) {
+	replayRequestsChannel := make(chan types.ReplayRequest)
+	assert.NotNil(t, requestReplay("/replay", 0, replayRequestsChannel), "Replay messages without on or off should return the instructions")
+	assert.NotNil(t, requestReplay("/replay on 03/05/2020", 0, replayRequestsChannel), "Dates with the wrong format should return an error")
+	assert.NotNil(t, requestReplay("/replay on 2999-05-03", 0, replayRequestsChannel), "Weeks in the future can not be replayed")
+	go func() {
+		requestReplay("/replay on", 1, replayRequestsChannel)
+		requestReplay("/replay on 2020-05-03", 1, replayRequestsChannel)
+		requestReplay("/replay off", 1, replayRequestsChannel)
+	}()
+	assert.Equal(t, <-replayRequestsChannel, types.ReplayRequest{Enabled: true, ChatId: 1})
+	request := <-replayRequestsChannel
+	assert.True(t, request.Enabled)
+	assert.Equal(t, request.From, time.Date(2020, 5, 3, 0, 0, 0, 0, time.Local))
+	assert.Equal(t, <-replayRequestsChannel, types.ReplayRequest{Enabled: false, ChatId: 1})
+}
+
+func TestCreateReminder(t *testing.T) {
+	operationsChannel := make(chan types.ProgrammedActionOperation)
+	assert.NotNil(t, createReminder("/remind 20:00", 0, configuration_loader.TelegramUser{}, operationsChannel), "Reminders without message should return an error")
+	assert.NotNil(t, createReminder("/remind tonight take the bins out", 0, configuration_loader.TelegramUser{}, operationsChannel), "Wrong time format should return an error")
+	go func() {
+		createReminder("/remind 20:00 take the bins out", 1, configuration_loader.TelegramUser{Id: 7}, operationsChannel)
+		createReminder("/remind daily 07:30:15 water the plants", 1, configuration_loader.TelegramUser{}, operationsChannel)
+	}()
+	operation := <-operationsChannel
+	assert.Equal(t, operation.Operation, int32(types.CREATE))
+	assert.Equal(t, operation.ProgrammedAction.Type, int32(types.REMINDER_ACTION))
+	assert.Equal(t, operation.ProgrammedAction.Message, "take the bins out")
+	assert.Equal(t, operation.ProgrammedAction.Action.ChatId, int64(1))
+	assert.Equal(t, operation.UserId, 7)
+	assert.False(t, operation.ProgrammedAction.Repeat)
+	assert.Equal(t, operation.ProgrammedAction.Time.Format("15:04:05"), "20:00:00")
+	assert.True(t, time.Time(operation.ProgrammedAction.Time).After(time.Now()), "Reminders should be scheduled in the future")
+	operation = <-operationsChannel
+	assert.True(t, operation.ProgrammedAction.Repeat)
+	assert.Equal(t, operation.ProgrammedAction.Message, "water the plants")
+	assert.Equal(t, operation.ProgrammedAction.Time.Format("15:04:05"), "07:30:15")
+}
+
+func TestRequestOverrides(t *testing.T) {
+	overrideRequestsChannel := make(chan types.OverrideRequest)
+	assert.NotNil(t, requestOverrides("/override light", 0, overrideRequestsChannel), "Override messages should only list or clear them")
+	assert.NotNil(t, requestOverrides("/override clear light now", 0, overrideRequestsChannel))
+	go func() {
+		requestOverrides("/override", 1, overrideRequestsChannel)
+		requestOverrides("/override clear", 1, overrideRequestsChannel)
+		requestOverrides("/override CLEAR light", 1, overrideRequestsChannel)
+	}()
+	assert.Equal(t, <-overrideRequestsChannel, types.OverrideRequest{ChatId: 1})
+	assert.Equal(t, <-overrideRequestsChannel, types.OverrideRequest{Clear: true, ChatId: 1})
+	assert.Equal(t, <-overrideRequestsChannel, types.OverrideRequest{Clear: true, Pin: "light", ChatId: 1})
+}
+
+func TestRequestSequence(t *testing.T) {
+	sequenceRequestsChannel := make(chan types.SequenceRequest)
+	assert.NotNil(t, requestSequence("/sequence run", 0, sequenceRequestsChannel), "Running a sequence needs its name")
+	assert.NotNil(t, requestSequence("/sequence stop", 0, sequenceRequestsChannel))
+	assert.NotNil(t, requestSequence("/raindelay", 0, sequenceRequestsChannel), "Rain delays need the number of days")
+	assert.NotNil(t, requestSequence("/raindelay -1", 0, sequenceRequestsChannel))
+	go func() {
+		requestSequence("/sequence", 1, sequenceRequestsChannel)
+		requestSequence("/sequence run garden", 1, sequenceRequestsChannel)
+		requestSequence("/sequence SKIP", 1, sequenceRequestsChannel)
+		requestSequence("/sequence abort", 1, sequenceRequestsChannel)
+		requestSequence("/raindelay 2", 1, sequenceRequestsChannel)
+		requestSequence("/raindelay 0", 1, sequenceRequestsChannel)
+	}()
+	assert.Equal(t, <-sequenceRequestsChannel, types.SequenceRequest{Operation: types.SEQUENCE_STATUS, ChatId: 1})
+	assert.Equal(t, <-sequenceRequestsChannel, types.SequenceRequest{Operation: types.SEQUENCE_RUN, Name: "garden", ChatId: 1})
+	assert.Equal(t, <-sequenceRequestsChannel, types.SequenceRequest{Operation: types.SEQUENCE_SKIP, ChatId: 1})
+	assert.Equal(t, <-sequenceRequestsChannel, types.SequenceRequest{Operation: types.SEQUENCE_ABORT, ChatId: 1})
+	assert.Equal(t, <-sequenceRequestsChannel, types.SequenceRequest{Operation: types.SEQUENCE_RAIN_DELAY, Days: 2, ChatId: 1})
+	assert.Equal(t, <-sequenceRequestsChannel, types.SequenceRequest{Operation: types.SEQUENCE_RAIN_DELAY, ChatId: 1})
+}
+
+func TestTelegramBotStatus(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	statusRequestsChannel := make(chan types.StatusRequest)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{
+		TelegramUsers: []configuration_loader.TelegramUser{configuration_loader.TelegramUser{Id: 2, Role: configuration_loader.VIEWER_ROLE}, configuration_loader.TelegramUser{Id: 3, Role: configuration_loader.VIEWER_ROLE, Pins: []string{"lamp"}}},
+	}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, StatusRequests: statusRequestsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "/status", UserId: 2, ChatId: 2}
+	assert.Equal(t, <-statusRequestsChannel, types.StatusRequest{ChatId: 2}, "Viewers should be able to query the status")
+	telegramInputChannel <- types.TelegramMessage{"Status:\nLight on", 2}
+	assert.Equal(t, (<-frontend.sent).Text, "Status:\nLight on")
+	frontend.commands <- Command{Text: "/status", UserId: 3, ChatId: 3}
+	assert.Equal(t, <-statusRequestsChannel, types.StatusRequest{ChatId: 3, AllowedPins: []string{"lamp"}}, "The server should receive the pins the user can see")
+	telegramInputChannel <- types.TelegramMessage{"Status:\nlamp on", 3}
+	assert.Equal(t, (<-frontend.sent).Text, "Status:\nlamp on")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+}
+
+func TestTelegramBotSubscriptions(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	subscriptionRequestsChannel := make(chan types.SubscriptionRequest)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{
+		TelegramUsers: []configuration_loader.TelegramUser{configuration_loader.TelegramUser{Id: 2, Role: configuration_loader.VIEWER_ROLE, Pins: []string{"lamp"}}},
+	}
+	frontend := newFakeFrontend()
+	err := LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, SubscriptionRequests: subscriptionRequestsChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+
+	frontend.commands <- Command{Text: "/subscribe all", UserId: 2, ChatId: 2}
+	assert.Equal(t, <-subscriptionRequestsChannel, types.SubscriptionRequest{Operation: types.SUBSCRIPTION_ADD, Kind: "all", ChatId: 2, UserId: 2, AllowedPins: []string{"lamp"}}, "The server should receive the pins the user can see")
+	frontend.commands <- Command{Text: "/unsubscribe pin lamp", UserId: 2, ChatId: 2}
+	assert.Equal(t, <-subscriptionRequestsChannel, types.SubscriptionRequest{Operation: types.SUBSCRIPTION_REMOVE, Kind: "pin", Target: "lamp", ChatId: 2, UserId: 2, AllowedPins: []string{"lamp"}})
+	frontend.commands <- Command{Text: "/subscribe", UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-subscriptionRequestsChannel).Operation, types.SUBSCRIPTION_LIST)
+	frontend.commands <- Command{Text: "/mute 90m", UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-subscriptionRequestsChannel).Duration, 90*time.Minute)
+	frontend.commands <- Command{Text: "/mute off", UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-subscriptionRequestsChannel).Duration, time.Duration(0))
+	frontend.commands <- Command{Text: "/mute later", UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-frontend.sent).Text, "Mute messages should be \"/mute [duration]\" (e.g. \"/mute 2h\") or \"/mute off\"")
+	frontend.commands <- Command{Text: "/subscribe pump", UserId: 2, ChatId: 2}
+	assert.Equal(t, (<-frontend.sent).Text, "Subscription messages should be \"/subscribe\" (to list them), \"/subscribe pin [pin]\", \"/subscribe node [node]\" or \"/subscribe all\"")
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
 }
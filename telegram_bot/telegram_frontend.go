@@ -0,0 +1,136 @@
+package telegram_bot
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+type telegramFrontend struct {
+	bot *tgbotapi.BotAPI
+	// Nil when the updates are polled
+	webhook *configuration_loader.TelegramWebhookConfiguration
+	server  *http.Server
+	// Where the webhook server listens, set by Commands
+	listener net.Listener
+}
+
+// NewTelegramFrontend connects to the Bot API at apiUrl (api.telegram.org when empty),
+// updates are received through webhook when it is set
+func NewTelegramFrontend(token string, apiUrl string, webhook *configuration_loader.TelegramWebhookConfiguration) (Frontend, error) {
+	client := &http.Client{}
+	if apiUrl != "" {
+		target, err := url.Parse(apiUrl)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = &apiUrlRewriter{target: target, transport: http.DefaultTransport}
+	}
+	bot, err := tgbotapi.NewBotAPIWithClient(token, client)
+	if err != nil {
+		return nil, err
+	}
+	return &telegramFrontend{bot: bot, webhook: webhook}, nil
+}
+
+func (f *telegramFrontend) Commands() (<-chan Command, error) {
+	if f.webhook != nil {
+		return f.webhookCommands()
+	}
+	// Telegram does not allow polling the updates while a webhook is registered
+	if _, err := f.bot.RemoveWebhook(); err != nil {
+		return nil, err
+	}
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	updates, err := f.bot.GetUpdatesChan(u)
+	if err != nil {
+		return nil, err
+	}
+	return commandsFromUpdates(updates), nil
+}
+
+// Polled and pushed updates are converted the same way
+func commandsFromUpdates(updates <-chan tgbotapi.Update) <-chan Command {
+	commands := make(chan Command)
+	go func() {
+		for update := range updates {
+			if callback := update.CallbackQuery; callback != nil && callback.Message != nil && callback.From != nil {
+				commands <- Command{Text: callback.Data, UserId: callback.From.ID, ChatId: callback.Message.Chat.ID, MessageId: callback.Message.MessageID, CallbackId: callback.ID}
+			} else if update.Message != nil && update.Message.From != nil {
+				commands <- Command{Text: update.Message.Text, UserId: update.Message.From.ID, ChatId: update.Message.Chat.ID, MessageId: update.Message.MessageID}
+			}
+		}
+	}()
+	return commands
+}
+
+func (f *telegramFrontend) SendText(chatId int64, text string, replyToMessageId int) error {
+	msg := tgbotapi.NewMessage(chatId, text)
+	if replyToMessageId > 0 {
+		msg.ReplyToMessageID = replyToMessageId
+	}
+	_, err := f.bot.Send(msg)
+	return err
+}
+
+func (f *telegramFrontend) SendKeyboard(chatId int64, text string, keyboard Keyboard) error {
+	msg := tgbotapi.NewMessage(chatId, text)
+	msg.ReplyMarkup = inlineKeyboard(keyboard)
+	_, err := f.bot.Send(msg)
+	return err
+}
+
+func (f *telegramFrontend) EditText(chatId int64, messageId int, text string, keyboard Keyboard) error {
+	msg := tgbotapi.NewEditMessageText(chatId, messageId, text)
+	if len(keyboard) > 0 {
+		markup := inlineKeyboard(keyboard)
+		msg.ReplyMarkup = &markup
+	}
+	_, err := f.bot.Send(msg)
+	return err
+}
+
+func (f *telegramFrontend) AnswerCallback(callbackId string, text string) error {
+	_, err := f.bot.AnswerCallbackQuery(tgbotapi.NewCallback(callbackId, text))
+	return err
+}
+
+func inlineKeyboard(keyboard Keyboard) tgbotapi.InlineKeyboardMarkup {
+	markup := tgbotapi.NewInlineKeyboardMarkup()
+	for _, row := range keyboard {
+		var buttons []tgbotapi.InlineKeyboardButton
+		for _, button := range row {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(button.Text, button.Data))
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, buttons)
+	}
+	return markup
+}
+
+func (f *telegramFrontend) Stop() {
+	if f.server != nil {
+		f.server.Close()
+		return
+	}
+	f.bot.StopReceivingUpdates()
+}
+
+// The library always uses api.telegram.org, requests are redirected to the configured Bot API server
+type apiUrlRewriter struct {
+	target    *url.URL
+	transport http.RoundTripper
+}
+
+func (r *apiUrlRewriter) RoundTrip(request *http.Request) (*http.Response, error) {
+	rewritten := request.Clone(request.Context())
+	rewritten.URL.Scheme = r.target.Scheme
+	rewritten.URL.Host = r.target.Host
+	rewritten.URL.Path = strings.TrimSuffix(r.target.Path, "/") + request.URL.Path
+	rewritten.Host = r.target.Host
+	return r.transport.RoundTrip(rewritten)
+}
@@ -0,0 +1,67 @@
+package telegram_bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// webhookCommands serves the endpoint telegram pushes the updates to and registers it if the url is configured
+func (f *telegramFrontend) webhookCommands() (<-chan Command, error) {
+	listener, err := net.Listen("tcp", f.webhook.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	updates := make(chan tgbotapi.Update, f.bot.Buffer)
+	mux := http.NewServeMux()
+	mux.Handle("/"+f.webhook.Secret, webhookHandler(updates))
+	f.server = &http.Server{Handler: mux}
+	f.listener = listener
+	go func() {
+		var err error
+		if f.webhook.CertificateFile != "" {
+			err = f.server.ServeTLS(listener, f.webhook.CertificateFile, f.webhook.KeyFile)
+		} else {
+			err = f.server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Println("The telegram webhook stopped: " + err.Error())
+		}
+	}()
+	if f.webhook.Url != "" {
+		link := strings.TrimSuffix(f.webhook.Url, "/") + "/" + f.webhook.Secret
+		config := tgbotapi.NewWebhook(link)
+		if f.webhook.SelfSigned {
+			config = tgbotapi.NewWebhookWithCert(link, f.webhook.CertificateFile)
+		}
+		if _, err := f.bot.SetWebhook(config); err != nil {
+			f.server.Close()
+			return nil, err
+		}
+	}
+	return commandsFromUpdates(updates), nil
+}
+
+func webhookHandler(updates chan tgbotapi.Update) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Updates should be posted", http.StatusMethodNotAllowed)
+			return
+		}
+		var update tgbotapi.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Update not valid: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Telegram sends the update again if it is not received (e.g. the bot is stopping)
+		select {
+		case updates <- update:
+		case <-r.Context().Done():
+			http.Error(w, "Update not received", http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package telegram_bot
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/configuration_loader"
+	"github.com/Alberto-Izquierdo/RPIHomeServer-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTelegramWebhook(t *testing.T) {
+	telegramOutputChannel := make(chan types.Action)
+	telegramInputChannel := make(chan types.TelegramMessage)
+	telegramExitChannel := make(chan bool)
+	var config configuration_loader.InitialConfiguration
+	config.ServerConfiguration = &configuration_loader.ServerConfiguration{TelegramAuthorizedUsers: []int{1234}}
+	sent := make(chan url.Values, 10)
+	server := newFakeBotApi(t, sent)
+	defer server.Close()
+	webhook := configuration_loader.TelegramWebhookConfiguration{ListenAddress: "127.0.0.1:0", Url: "https://example.com:8443/", Secret: "s3cret"}
+	frontend, err := NewTelegramFrontend("153667468:token", server.URL, &webhook)
+	require.Nil(t, err)
+	err = LaunchTelegramBot(config, frontend, nil, types.BotChannels{Actions: telegramOutputChannel, Responses: telegramInputChannel}, telegramExitChannel)
+	require.Nil(t, err)
+	assert.Equal(t, (<-sent).Get("url"), "https://example.com:8443/s3cret", "The endpoint should be registered in telegram with the secret")
+
+	endpoint := "http://" + frontend.(*telegramFrontend).listener.Addr().String()
+	post := func(path string, update string) int {
+		response, err := http.Post(endpoint+path, "application/json", bytes.NewBufferString(update))
+		require.Nil(t, err)
+		response.Body.Close()
+		return response.StatusCode
+	}
+	assert.Equal(t, post("/wrong", `{"update_id":1}`), http.StatusNotFound, "Only the secret path should receive updates")
+	assert.Equal(t, post("/s3cret", `{"update_id":`), http.StatusBadRequest)
+	assert.Equal(t, post("/s3cret", `{"update_id":1,"message":{"message_id":1,"date":0,"text":"/start","from":{"id":1234,"first_name":"user"},"chat":{"id":1234,"type":"private"}}}`), http.StatusOK)
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"start", true, 1234}, "Pushed updates should be handled like polled ones")
+	telegramInputChannel <- types.TelegramMessage{"start Light", 1234}
+	assert.Equal(t, (<-sent).Get("text"), "Welcome to rpi bot")
+
+	go post("/s3cret", `{"update_id":2,"callback_query":{"id":"a","data":"LightOn","from":{"id":1234,"first_name":"user"},"message":{"message_id":2,"date":0,"chat":{"id":1234,"type":"private"}}}}`)
+	assert.Equal(t, (<-sent).Get("callback_query_id"), "a", "Buttons should be answered")
+	assert.Equal(t, <-telegramOutputChannel, types.Action{"Light", true, 1234})
+
+	telegramExitChannel <- true
+	<-telegramExitChannel
+	_, err = http.Post(endpoint+"/s3cret", "application/json", bytes.NewBufferString(`{"update_id":3}`))
+	assert.NotNil(t, err, "The endpoint should be closed when the bot stops")
+}
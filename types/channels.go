@@ -0,0 +1,67 @@
+package types
+
+// BotChannels connect the telegram bot with the gRPC server, the bot sends the requests of the users
+// and the server sends the answers (and its notifications) to Responses
+type BotChannels struct {
+	Actions              chan Action
+	ProgrammedActions    chan ProgrammedActionOperation
+	AgendaRequests       chan AgendaRequest
+	HistoryRequests      chan HistoryRequest
+	VacationRequests     chan VacationModeRequest
+	ReplayRequests       chan ReplayRequest
+	OverrideRequests     chan OverrideRequest
+	SequenceRequests     chan SequenceRequest
+	StatusRequests       chan StatusRequest
+	SubscriptionRequests chan SubscriptionRequest
+	Responses            chan TelegramMessage
+}
+
+func NewBotChannels() BotChannels {
+	return BotChannels{
+		Actions:              make(chan Action),
+		ProgrammedActions:    make(chan ProgrammedActionOperation),
+		AgendaRequests:       make(chan AgendaRequest),
+		HistoryRequests:      make(chan HistoryRequest),
+		VacationRequests:     make(chan VacationModeRequest),
+		ReplayRequests:       make(chan ReplayRequest),
+		OverrideRequests:     make(chan OverrideRequest),
+		SequenceRequests:     make(chan SequenceRequest),
+		StatusRequests:       make(chan StatusRequest),
+		SubscriptionRequests: make(chan SubscriptionRequest),
+		Responses:            make(chan TelegramMessage),
+	}
+}
+
+// NodeChannels connect the message generator of a node with its gRPC client, the gRPC client forwards the
+// requests of the server and sends the answers of the message generator (and the pin changes) to the server
+type NodeChannels struct {
+	ProgrammedActions chan ProgrammedActionOperation
+	AgendaRequests    chan AgendaRequest
+	Agendas           chan Agenda
+	Executions        chan Execution
+	VacationRequests  chan VacationModeRequest
+	ReplayRequests    chan ReplayRequest
+	OverrideRequests  chan OverrideRequest
+	SequenceRequests  chan SequenceRequest
+	// Every change of the pins, the server uses them for the daily summary and the notifications
+	PinChanges chan PinChange
+	// Actions requested by users (even if they did not change the pin), they can suspend its programmed actions
+	ManualChanges chan PinChange
+	Responses     chan TelegramMessage
+}
+
+func NewNodeChannels() NodeChannels {
+	return NodeChannels{
+		ProgrammedActions: make(chan ProgrammedActionOperation),
+		AgendaRequests:    make(chan AgendaRequest),
+		Agendas:           make(chan Agenda),
+		Executions:        make(chan Execution),
+		VacationRequests:  make(chan VacationModeRequest),
+		ReplayRequests:    make(chan ReplayRequest),
+		OverrideRequests:  make(chan OverrideRequest),
+		SequenceRequests:  make(chan SequenceRequest),
+		PinChanges:        make(chan PinChange),
+		ManualChanges:     make(chan PinChange),
+		Responses:         make(chan TelegramMessage),
+	}
+}
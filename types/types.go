@@ -1,7 +1,12 @@
 package types
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"hash/fnv"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -24,23 +29,286 @@ type TelegramMessage struct {
 
 type MyTime time.Time
 
+type Sensor struct {
+	Name  string
+	Path  string
+	Scale float64
+}
+
+type Condition struct {
+	Pin      string
+	State    bool
+	Sensor   string
+	Operator string
+	Value    float64
+}
+
 type ProgrammedAction struct {
-	Action Action
-	Repeat bool
-	Time   MyTime
+	Id            string
+	Action        Action
+	Repeat        bool
+	Time          MyTime
+	Conditions    []Condition
+	NotifySkipped bool
+	// Send a message to the creator if the execution fails
+	AlertOnFailure bool
+	// Send a message every time it is executed, to NotifyChatId if set or to the creator otherwise
+	Notify       bool
+	NotifyChatId int64
+	// Vacation mode applies a random jitter to it, Jitter stores the one applied to the current occurrence
+	Vacation bool
+	Jitter   time.Duration `json:"-"`
+	// Timers are executed once at an absolute date (stored in Time) instead of at a time of the day
+	Timer bool
+	// PIN_ACTION changes the state of Action.Pin, REMINDER_ACTION sends Message to Action.ChatId and SEQUENCE_ACTION runs Sequence
+	Type     int32
+	Message  string
+	Sequence string
+	// Days of the week a repeated programmed action runs, every day when empty
+	Weekdays []time.Weekday
 }
 
+const (
+	PIN_ACTION = iota
+	REMINDER_ACTION
+	SEQUENCE_ACTION
+)
+
 type ProgrammedActionOperation struct {
 	ProgrammedAction ProgrammedAction
 	Operation        int32
+	// Pins and nodes whose programmed actions the user can change, every one when both are empty
+	AllowedPins  []string
+	AllowedNodes []string
+	// User that sent the operation, for the audit log
+	UserId int
 }
 
 const (
 	CREATE = iota
 	REMOVE
 	GET_ACTIONS
+	UPDATE
+	GET_TIMERS
+	CHECK
+)
+
+type AgendaRequest struct {
+	Id         int64
+	Horizon    time.Duration
+	Executions int
+	ChatId     int64
+	// Pins and nodes whose programmed actions the user can see, every one when both are empty
+	AllowedPins  []string
+	AllowedNodes []string
+}
+
+type AgendaEntry struct {
+	ProgrammedAction ProgrammedAction
+	Date             time.Time
+}
+
+type Agenda struct {
+	RequestId int64
+	Entries   []AgendaEntry
+}
+
+const DefaultAgendaHorizon time.Duration = 24 * time.Hour
+
+type PinStateRequest struct {
+	Id  int64
+	Pin string
+}
+
+type Execution struct {
+	ProgrammedActionId string
+	Action             Action
+	Date               time.Time
+	Success            bool
+	Skipped            bool
+	Error              string
+	// Set for the reminders and sequences, they do not change a pin
+	Description string
+}
+
+type PinChange struct {
+	Pin    string
+	State  bool
+	Date   time.Time
+	Manual bool
+	ChatId int64
+}
+
+type ReplayRequest struct {
+	Enabled bool
+	// Beginning of the week to replay
+	From   time.Time
+	ChatId int64
+}
+
+type HistoryRequest struct {
+	Pin string
+	// Zero to get executions from every day
+	Date   time.Time
+	ChatId int64
+	// Pins and nodes the user can see, every one when both are empty
+	AllowedPins  []string
+	AllowedNodes []string
+}
+
+type VacationConfiguration struct {
+	// Programmed actions with the vacation option are moved randomly up to JitterMinutes before or after their time
+	JitterMinutes int
+	// Pins turned on and off randomly during the evening
+	EpisodePins       []string
+	Episodes          int
+	EpisodeMaxMinutes int
+	EveningStart      MyTime
+	EveningEnd        MyTime
+	// Zero to use a different seed every time
+	Seed int64
+}
+
+type VacationModeRequest struct {
+	Enabled bool
+	ChatId  int64
+}
+
+type SequenceStep struct {
+	Pin     string
+	Minutes int
+	// Minutes to wait after the step before starting the next one
+	GapMinutes int
+}
+
+// Steps are run one after the other, so their pins are never on at the same time
+type Sequence struct {
+	Name  string
+	Steps []SequenceStep
+}
+
+type SequenceRequest struct {
+	Operation int32
+	// Sequence to run
+	Name string
+	// Days to postpone the programmed sequences (zero to cancel the delay)
+	Days   int
+	ChatId int64
+}
+
+// StatusRequest asks every node for the state of its pins
+type StatusRequest struct {
+	Id     int64
+	ChatId int64
+	// Pins and nodes the user can see, every one when both are empty
+	AllowedPins  []string
+	AllowedNodes []string
+}
+
+type PinStatus struct {
+	Pin   string
+	State bool
+	Node  string
+	// Last change of the pin, nil if the node did not record any
+	LastChange *PinChange
+}
+
+// e.g. "Light on (living), since 03/05 07:30 by chat 1234"
+func PinStatusToString(status PinStatus) string {
+	result := status.Pin + " off"
+	if status.State {
+		result = status.Pin + " on"
+	}
+	if status.Node != "" {
+		result += " (" + status.Node + ")"
+	}
+	if status.LastChange == nil {
+		return result + ", no changes recorded"
+	}
+	return result + ", since " + status.LastChange.Date.Format("02/01 15:04") + " " + PinChangeAuthor(*status.LastChange)
+}
+
+// "by chat 1234", "by a user" (e.g. from a node without chat) or "by a programmed action"
+func PinChangeAuthor(pinChange PinChange) string {
+	if pinChange.Manual && pinChange.ChatId != 0 {
+		return "by chat " + strconv.FormatInt(pinChange.ChatId, 10)
+	} else if pinChange.Manual {
+		return "by a user"
+	}
+	return "by a programmed action"
+}
+
+const (
+	SEQUENCE_STATUS = iota
+	SEQUENCE_RUN
+	SEQUENCE_SKIP
+	SEQUENCE_ABORT
+	SEQUENCE_RAIN_DELAY
 )
 
+// AuditEntry records a command of a user and its result
+type AuditEntry struct {
+	Date    time.Time
+	UserId  int
+	ChatId  int64
+	Command string
+	// Pin and node affected by the command, if any
+	Pin    string
+	Node   string
+	Result string
+}
+
+// e.g. "03/05 07:30 user 1234 (chat 1234): LightOff [Light, living] accepted"
+func AuditEntryToString(entry AuditEntry) string {
+	result := entry.Date.Format("02/01 15:04") + " user " + strconv.Itoa(entry.UserId) + " (chat " + strconv.FormatInt(entry.ChatId, 10) + "): " + entry.Command
+	if entry.Pin != "" && entry.Node != "" {
+		result += " [" + entry.Pin + ", " + entry.Node + "]"
+	} else if entry.Pin != "" {
+		result += " [" + entry.Pin + "]"
+	}
+	return result + " " + entry.Result
+}
+
+type SubscriptionRequest struct {
+	Operation int
+	// "pin", "node" or "all"
+	Kind   string
+	Target string
+	// Time the notifications of the chat are muted, 0 unmutes them
+	Duration time.Duration
+	ChatId   int64
+	// User that subscribes, or the user revoked
+	UserId int
+	// Pins and nodes the user can see, every one when both are empty
+	AllowedPins  []string
+	AllowedNodes []string
+}
+
+const (
+	SUBSCRIPTION_LIST = iota
+	SUBSCRIPTION_ADD
+	SUBSCRIPTION_REMOVE
+	SUBSCRIPTION_MUTE
+	// Removes every subscription of a user that is not authorized anymore
+	SUBSCRIPTION_REVOKE
+)
+
+type OverrideConfiguration struct {
+	Pin string
+	// A manual action on the pin suspends its programmed actions for Minutes (or until the next day if UntilTomorrow)
+	Minutes       int
+	UntilTomorrow bool
+}
+
+type OverrideRequest struct {
+	// Clear the override of Pin (or every override if empty), otherwise list them
+	Clear  bool
+	Pin    string
+	ChatId int64
+}
+
+var ConditionOperators = []string{"<=", ">=", "==", "!=", "<", ">"}
+
 func (a *MyTime) UnmarshalJSON(b []byte) error {
 	s := strings.Trim(string(b), "\"")
 	t, err := time.Parse("15:04:05", s)
@@ -61,18 +329,34 @@ func (this ProgrammedAction) LessThan(other interface{}) bool {
 }
 
 func (this ProgrammedAction) Equals(other interface{}) bool {
-	otherTime := other.(ProgrammedAction)
-	equal := otherTime.Action.Pin == this.Action.Pin &&
-		otherTime.Action.State == this.Action.State &&
-		time.Time(otherTime.Time).Hour() == time.Time(this.Time).Hour() &&
-		time.Time(otherTime.Time).Minute() == time.Time(this.Time).Minute() &&
-		time.Time(otherTime.Time).Second() == time.Time(this.Time).Second()
-	return equal
+	return this.Id == other.(ProgrammedAction).Id
+}
+
+func (this ProgrammedAction) SameSchedule(other ProgrammedAction) bool {
+	return ProgrammedActionToString(this) == ProgrammedActionToString(other)
+}
+
+const programmedActionIdLength = 6
+
+func NewProgrammedActionId() string {
+	id := make([]byte, programmedActionIdLength/2)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func ProgrammedActionIdFromString(str string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(str))
+	id := strconv.FormatUint(uint64(hash.Sum32()), 16)
+	for len(id) < programmedActionIdLength {
+		id = "0" + id
+	}
+	return id[:programmedActionIdLength]
 }
 
 func ProgrammedActionFromString(str string, chatId int64) (*ProgrammedAction, error) {
 	fields := strings.Split(str, ";")
-	if len(fields) != 4 {
+	if len(fields) < 4 {
 		return nil, errors.New("Message not correct")
 	}
 	deserializedTime := MyTime{}
@@ -103,6 +387,56 @@ func ProgrammedActionFromString(str string, chatId int64) (*ProgrammedAction, er
 		Repeat: repeat,
 		Time:   MyTime(date),
 	}
+	for _, option := range fields[4:] {
+		if strings.HasPrefix(option, "if:") {
+			condition, err := ConditionFromString(strings.TrimPrefix(option, "if:"))
+			if err != nil {
+				return nil, err
+			}
+			result.Conditions = append(result.Conditions, *condition)
+		} else if strings.EqualFold(option, "notifyskipped") {
+			result.NotifySkipped = true
+		} else if strings.EqualFold(option, "alertonfailure") {
+			result.AlertOnFailure = true
+		} else if strings.EqualFold(option, "vacation") {
+			result.Vacation = true
+		} else if strings.EqualFold(option, "notify") {
+			result.Notify = true
+		} else if strings.HasPrefix(strings.ToLower(option), "notify:") {
+			notifyChatId, err := strconv.ParseInt(option[len("notify:"):], 10, 64)
+			if err != nil {
+				return nil, errors.New("Notification chat not valid: " + option)
+			}
+			result.Notify = true
+			result.NotifyChatId = notifyChatId
+		} else if strings.HasPrefix(option, "remind:") {
+			message, err := url.QueryUnescape(strings.TrimPrefix(option, "remind:"))
+			if err != nil || strings.TrimSpace(message) == "" {
+				return nil, errors.New("Reminder message not valid: " + option)
+			}
+			result.Type = REMINDER_ACTION
+			result.Message = message
+		} else if strings.HasPrefix(option, "sequence:") {
+			result.Type = SEQUENCE_ACTION
+			result.Sequence = strings.TrimPrefix(option, "sequence:")
+		} else if strings.HasPrefix(option, "days:") {
+			weekdays, err := WeekdaysFromString(strings.TrimPrefix(option, "days:"))
+			if err != nil {
+				return nil, err
+			}
+			result.Weekdays = weekdays
+		} else if strings.HasPrefix(option, "timer:") {
+			deadline, err := strconv.ParseInt(strings.TrimPrefix(option, "timer:"), 10, 64)
+			if err != nil {
+				return nil, errors.New("Timer deadline not valid: " + option)
+			}
+			result.Timer = true
+			result.Repeat = false
+			result.Time = MyTime(time.Unix(deadline, 0))
+		} else {
+			return nil, errors.New("Option not known: " + option)
+		}
+	}
 	return &result, nil
 }
 
@@ -119,5 +453,217 @@ func ProgrammedActionToString(p ProgrammedAction) string {
 		result += "false;"
 	}
 	result += p.Time.Format("15:04:05")
+	for _, condition := range p.Conditions {
+		result += ";if:" + ConditionToString(condition)
+	}
+	if p.NotifySkipped {
+		result += ";notifyskipped"
+	}
+	if p.AlertOnFailure {
+		result += ";alertonfailure"
+	}
+	if p.Vacation {
+		result += ";vacation"
+	}
+	if p.Notify && p.NotifyChatId != 0 {
+		result += ";notify:" + strconv.FormatInt(p.NotifyChatId, 10)
+	} else if p.Notify {
+		result += ";notify"
+	}
+	if len(p.Weekdays) > 0 {
+		result += ";days:" + WeekdaysToString(p.Weekdays)
+	}
+	if p.Timer {
+		result += ";timer:" + strconv.FormatInt(time.Time(p.Time).Unix(), 10)
+	}
+	if p.Type == REMINDER_ACTION {
+		// Escaped so the message does not break the ";" and " " separators
+		result += ";remind:" + url.QueryEscape(p.Message)
+	} else if p.Type == SEQUENCE_ACTION {
+		result += ";sequence:" + p.Sequence
+	}
+	return result
+}
+
+func NewReminder(message string, chatId int64, date time.Time, repeat bool) ProgrammedAction {
+	return ProgrammedAction{Type: REMINDER_ACTION, Message: message, Action: Action{ChatId: chatId}, Time: MyTime(date), Repeat: repeat}
+}
+
+// Short description of what the programmed action does (e.g. "light on" or "remind \"take the bins out\"")
+func (p ProgrammedAction) Description() string {
+	if p.Type == REMINDER_ACTION {
+		return "remind \"" + p.Message + "\""
+	} else if p.Type == SEQUENCE_ACTION {
+		return "sequence " + p.Sequence
+	}
+	if p.Action.State {
+		return p.Action.Pin + " on"
+	}
+	return p.Action.Pin + " off"
+}
+
+// RunsOn reports if a repeated programmed action runs the day of date
+func (p ProgrammedAction) RunsOn(date time.Time) bool {
+	if len(p.Weekdays) == 0 {
+		return true
+	}
+	for _, weekday := range p.Weekdays {
+		if weekday == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// WeekdaysFromString parses comma separated days (e.g. "mon,wed,fri")
+func WeekdaysFromString(str string) ([]time.Weekday, error) {
+	var result []time.Weekday
+	for _, name := range strings.Split(str, ",") {
+		found := false
+		for index, weekdayName := range weekdayNames {
+			if strings.EqualFold(name, weekdayName) {
+				result = append(result, time.Weekday(index))
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("Day not valid: \"" + name + "\" (days should be " + strings.Join(weekdayNames, ",") + ")")
+		}
+	}
+	return result, nil
+}
+
+func WeekdaysToString(weekdays []time.Weekday) string {
+	var names []string
+	for _, weekday := range weekdays {
+		names = append(names, weekdayNames[weekday])
+	}
+	return strings.Join(names, ",")
+}
+
+func (p ProgrammedAction) NotificationChatId() int64 {
+	if p.NotifyChatId != 0 {
+		return p.NotifyChatId
+	}
+	return p.Action.ChatId
+}
+
+func NewTimer(action Action, duration time.Duration) ProgrammedAction {
+	deadline := time.Now().Add(duration).Round(time.Second)
+	return ProgrammedAction{Action: action, Time: MyTime(deadline), Timer: true}
+}
+
+func (p ProgrammedAction) Remaining(now time.Time) time.Duration {
+	remaining := time.Time(p.Time).Sub(now).Round(time.Second)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+func ConditionFromString(str string) (*Condition, error) {
+	for _, operator := range ConditionOperators {
+		if index := strings.Index(str, operator); index > 0 {
+			value, err := strconv.ParseFloat(str[index+len(operator):], 64)
+			if err != nil {
+				return nil, errors.New("Sensor condition value not valid: " + str)
+			}
+			return &Condition{Sensor: str[:index], Operator: operator, Value: value}, nil
+		}
+	}
+	fields := strings.Split(str, "=")
+	if len(fields) != 2 || fields[0] == "" {
+		return nil, errors.New("Condition not valid: " + str)
+	}
+	if strings.EqualFold(fields[1], "true") || strings.EqualFold(fields[1], "on") {
+		return &Condition{Pin: fields[0], State: true}, nil
+	} else if strings.EqualFold(fields[1], "false") || strings.EqualFold(fields[1], "off") {
+		return &Condition{Pin: fields[0], State: false}, nil
+	}
+	return nil, errors.New("Pin condition state not valid: " + str)
+}
+
+func ConditionToString(c Condition) string {
+	if c.Sensor != "" {
+		return c.Sensor + c.Operator + strconv.FormatFloat(c.Value, 'f', -1, 64)
+	}
+	if c.State {
+		return c.Pin + "=on"
+	}
+	return c.Pin + "=off"
+}
+
+func (c Condition) Compare(value float64) (bool, error) {
+	switch c.Operator {
+	case "<":
+		return value < c.Value, nil
+	case "<=":
+		return value <= c.Value, nil
+	case ">":
+		return value > c.Value, nil
+	case ">=":
+		return value >= c.Value, nil
+	case "==":
+		return value == c.Value, nil
+	case "!=":
+		return value != c.Value, nil
+	}
+	return false, errors.New("Condition operator not known: " + c.Operator)
+}
+
+func AgendaEntryToString(entry AgendaEntry) string {
+	result := entry.Date.Format("Mon 02/01 15:04:05") + " " + entry.ProgrammedAction.Description()
+	if entry.ProgrammedAction.Timer {
+		result += " (timer)"
+	} else if !entry.ProgrammedAction.Repeat {
+		result += " (once)"
+	}
+	return result
+}
+
+func ProgrammedActionToCompactString(p ProgrammedAction) string {
+	result := p.Id + " " + p.Description() + " " + p.Time.Format("15:04:05")
+	if p.Timer {
+		result += " (" + p.Remaining(time.Now()).String() + " left)"
+	} else if p.Repeat && len(p.Weekdays) > 0 {
+		result += " on " + WeekdaysToString(p.Weekdays)
+	} else if p.Repeat {
+		result += " daily"
+	} else {
+		result += " once"
+	}
+	for index, condition := range p.Conditions {
+		if index == 0 {
+			result += " if "
+		} else {
+			result += " and "
+		}
+		result += ConditionToString(condition)
+	}
+	return result
+}
+
+// e.g. "Light on", "remind \"Take the pills\"" or "sequence Garden"
+func ExecutionDescription(execution Execution) string {
+	if execution.Description != "" {
+		return execution.Description
+	}
+	if execution.Action.State {
+		return execution.Action.Pin + " on"
+	}
+	return execution.Action.Pin + " off"
+}
+
+func ExecutionToString(execution Execution) string {
+	result := execution.Date.Format("Mon 02/01 15:04:05") + " " + ExecutionDescription(execution)
+	if execution.Skipped {
+		result += " skipped: " + execution.Error
+	} else if execution.Success {
+		result += " ok"
+	} else {
+		result += " failed: " + execution.Error
+	}
 	return result
 }
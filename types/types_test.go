@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateProgrammedActionFromString(t *testing.T) {
@@ -27,3 +28,119 @@ func TestCreateProgrammedActionFromString(t *testing.T) {
 		assert.Equal(t, time.Time(programmedAction.Time).Second(), 8)
 	}
 }
+
+func TestProgrammedActionIds(t *testing.T) {
+	first := ProgrammedAction{Id: NewProgrammedActionId(), Action: Action{Pin: "action", State: true}, Repeat: true}
+	second := ProgrammedAction{Id: NewProgrammedActionId(), Action: Action{Pin: "action", State: true}, Repeat: false}
+	assert.Equal(t, len(first.Id), 6)
+	assert.NotEqual(t, first.Id, second.Id)
+	assert.False(t, first.Equals(second), "Programmed actions with different ids should not be equal")
+	assert.False(t, first.SameSchedule(second), "Programmed actions with different repetition should not have the same schedule")
+	second.Repeat = true
+	assert.True(t, first.SameSchedule(second))
+	assert.Equal(t, ProgrammedActionIdFromString("action;true;true;07:00:00"), ProgrammedActionIdFromString("action;true;true;07:00:00"))
+	assert.NotEqual(t, ProgrammedActionIdFromString("action;true;true;07:00:00"), ProgrammedActionIdFromString("action;true;false;07:00:00"))
+}
+
+func TestProgrammedActionConditions(t *testing.T) {
+	programmedAction, err := ProgrammedActionFromString("heater;true;true;07:00:00;if:window=off;if:temperature<18.5;notifyskipped", 123)
+	assert.Nil(t, err)
+	assert.Equal(t, len(programmedAction.Conditions), 2)
+	assert.Equal(t, programmedAction.Conditions[0], Condition{Pin: "window", State: false})
+	assert.Equal(t, programmedAction.Conditions[1], Condition{Sensor: "temperature", Operator: "<", Value: 18.5})
+	assert.True(t, programmedAction.NotifySkipped)
+	assert.Equal(t, ProgrammedActionToString(*programmedAction), "heater;true;true;07:00:00;if:window=off;if:temperature<18.5;notifyskipped")
+
+	_, err = ProgrammedActionFromString("heater;true;true;07:00:00;if:window=maybe", 123)
+	assert.NotNil(t, err, "Pin conditions should have a valid state")
+	_, err = ProgrammedActionFromString("heater;true;true;07:00:00;if:temperature<=warm", 123)
+	assert.NotNil(t, err, "Sensor conditions should have a numeric value")
+
+	met, err := Condition{Sensor: "temperature", Operator: ">=", Value: 20}.Compare(20)
+	assert.Nil(t, err)
+	assert.True(t, met)
+	_, err = Condition{Sensor: "temperature", Operator: "~", Value: 20}.Compare(20)
+	assert.NotNil(t, err, "Unknown operators should return an error")
+}
+
+func TestTimers(t *testing.T) {
+	timer := NewTimer(Action{Pin: "light", State: false, ChatId: 1}, 30*time.Minute)
+	assert.True(t, timer.Timer)
+	assert.False(t, timer.Repeat, "Timers should only be executed once")
+	assert.InDelta(t, timer.Remaining(time.Now()).Minutes(), 30, 0.1)
+	assert.Equal(t, timer.Remaining(time.Now().Add(time.Hour)), time.Duration(0), "Remaining time should not be negative")
+
+	parsed, err := ProgrammedActionFromString(ProgrammedActionToString(timer), 1)
+	assert.Nil(t, err)
+	assert.True(t, parsed.Timer)
+	assert.Equal(t, time.Time(parsed.Time).Unix(), time.Time(timer.Time).Unix(), "Timers should keep their deadline when serialized")
+	_, err = ProgrammedActionFromString("light;false;false;10:00:00;timer:tomorrow", 1)
+	assert.NotNil(t, err)
+}
+
+func TestProgrammedActionNotifications(t *testing.T) {
+	programmedAction, err := ProgrammedActionFromString("light;true;true;07:00:00;notify", 123)
+	assert.Nil(t, err)
+	assert.True(t, programmedAction.Notify)
+	assert.Equal(t, programmedAction.NotificationChatId(), int64(123), "Notifications should be sent to the creator by default")
+	programmedAction, err = ProgrammedActionFromString("light;true;true;07:00:00;notify:456", 123)
+	assert.Nil(t, err)
+	assert.Equal(t, programmedAction.NotificationChatId(), int64(456), "Notifications should be sent to the chat configured")
+	assert.Equal(t, ProgrammedActionToString(*programmedAction), "light;true;true;07:00:00;notify:456")
+	_, err = ProgrammedActionFromString("light;true;true;07:00:00;notify:me", 123)
+	assert.NotNil(t, err)
+}
+
+func TestReminders(t *testing.T) {
+	date := time.Date(2020, 5, 3, 20, 0, 0, 0, time.Local)
+	reminder := NewReminder("take the bins out; both of them", 1, date, true)
+	assert.Equal(t, reminder.Type, int32(REMINDER_ACTION))
+	assert.Equal(t, reminder.Description(), "remind \"take the bins out; both of them\"")
+	serialized := ProgrammedActionToString(reminder)
+	assert.NotContains(t, serialized, " ", "Serialized reminders should not contain spaces")
+	parsed, err := ProgrammedActionFromString(serialized, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, parsed.Type, int32(REMINDER_ACTION))
+	assert.Equal(t, parsed.Message, reminder.Message, "Reminders should keep their message when serialized")
+	assert.True(t, parsed.Repeat)
+	assert.Equal(t, ProgrammedActionToCompactString(*parsed), " remind \"take the bins out; both of them\" 20:00:00 daily")
+	_, err = ProgrammedActionFromString(";false;false;10:00:00;remind:", 1)
+	assert.NotNil(t, err, "Reminders without message should return an error")
+}
+
+func TestWeekdays(t *testing.T) {
+	programmedAction, err := ProgrammedActionFromString("light;true;true;07:30:00;days:mon,TUE,fri", 1)
+	require.Nil(t, err)
+	assert.Equal(t, programmedAction.Weekdays, []time.Weekday{time.Monday, time.Tuesday, time.Friday})
+	assert.Equal(t, ProgrammedActionToString(*programmedAction), "light;true;true;07:30:00;days:mon,tue,fri")
+	assert.Equal(t, ProgrammedActionToCompactString(*programmedAction), " light on 07:30:00 on mon,tue,fri")
+	assert.True(t, programmedAction.RunsOn(time.Date(2020, 5, 4, 0, 0, 0, 0, time.Local)), "2020-05-04 is a monday")
+	assert.False(t, programmedAction.RunsOn(time.Date(2020, 5, 3, 0, 0, 0, 0, time.Local)), "2020-05-03 is a sunday")
+	programmedAction.Weekdays = nil
+	assert.True(t, programmedAction.RunsOn(time.Date(2020, 5, 3, 0, 0, 0, 0, time.Local)), "Actions without weekdays should run every day")
+
+	_, err = ProgrammedActionFromString("light;true;true;07:30:00;days:monday", 1)
+	assert.NotNil(t, err, "Days should be abbreviated")
+}
+
+func TestPinStatusToString(t *testing.T) {
+	date := time.Date(2020, 5, 3, 7, 30, 0, 0, time.Local)
+	assert.Equal(t, PinStatusToString(PinStatus{Pin: "Light", State: true, Node: "living", LastChange: &PinChange{Date: date, Manual: true, ChatId: 1234}}), "Light on (living), since 03/05 07:30 by chat 1234")
+	assert.Equal(t, PinStatusToString(PinStatus{Pin: "Light", LastChange: &PinChange{Date: date}}), "Light off, since 03/05 07:30 by a programmed action")
+	assert.Equal(t, PinStatusToString(PinStatus{Pin: "Light", LastChange: &PinChange{Date: date, Manual: true}}), "Light off, since 03/05 07:30 by a user")
+	assert.Equal(t, PinStatusToString(PinStatus{Pin: "Light", State: true}), "Light on, no changes recorded")
+}
+
+func TestExecutionToString(t *testing.T) {
+	date := time.Date(2020, 5, 3, 7, 30, 0, 0, time.Local)
+	assert.Equal(t, ExecutionToString(Execution{Action: Action{Pin: "Light", State: true}, Date: date, Success: true}), "Sun 03/05 07:30:00 Light on ok")
+	reminder := NewReminder("take the bins out", 1, date, false)
+	assert.Equal(t, ExecutionToString(Execution{Action: reminder.Action, Date: date, Success: true, Description: reminder.Description()}), "Sun 03/05 07:30:00 remind \"take the bins out\" ok")
+	assert.Equal(t, ExecutionToString(Execution{Date: date, Error: "sequences not available", Description: "sequence Garden"}), "Sun 03/05 07:30:00 sequence Garden failed: sequences not available")
+}
+
+func TestAuditEntryToString(t *testing.T) {
+	date := time.Date(2020, 5, 3, 7, 30, 0, 0, time.Local)
+	assert.Equal(t, AuditEntryToString(AuditEntry{date, 1234, 1234, "LightOff", "Light", "living", "accepted"}), "03/05 07:30 user 1234 (chat 1234): LightOff [Light, living] accepted")
+	assert.Equal(t, AuditEntryToString(AuditEntry{date, 1, 2, "/vacation on", "", "", "Only admins can use /vacation"}), "03/05 07:30 user 1 (chat 2): /vacation on Only admins can use /vacation")
+}